@@ -0,0 +1,29 @@
+package claircore
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+// RegisterCompression adds a compression scheme that isn't built into
+// claircore, for distributions that need to handle a format of their own.
+//
+// Blobs starting with "magic" are decompressed with a ReadCloser returned by
+// "open", which is closed once the blob has been read. This applies
+// everywhere claircore detects compression, including layers passed to
+// [Layer.Init] and layers fetched by libindex. Layers in a registered format
+// are accepted whatever their media type.
+//
+// The name must be non-empty, lower case, and not the name of another scheme,
+// and "magic" must be non-empty. RegisterCompression is meant to be called
+// from an init func, but is safe to call concurrently with indexing.
+func RegisterCompression(name string, magic []byte, open func(io.Reader) (io.ReadCloser, error)) error {
+	m := bytes.Clone(magic)
+	mask := bytes.Repeat([]byte{0xFF}, len(m))
+	_, err := zreader.RegisterDecoder(name, mask, func(b []byte) bool {
+		return bytes.Equal(b, m)
+	}, open)
+	return err
+}
@@ -0,0 +1,89 @@
+package claircore_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/quay/claircore"
+)
+
+// RotReader undoes a toy "compression" that adds 1 to every byte.
+type rotReader struct {
+	r io.Reader
+}
+
+func (r rotReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i]--
+	}
+	return n, err
+}
+
+func (rotReader) Close() error { return nil }
+
+func TestRegisterCompression(t *testing.T) {
+	ctx := context.Background()
+	magic := []byte("ROT1\x00")
+	if err := claircore.RegisterCompression("rot1", magic, func(r io.Reader) (io.ReadCloser, error) {
+		if _, err := io.CopyN(io.Discard, r, int64(len(magic))); err != nil {
+			return nil, err
+		}
+		return rotReader{r}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := claircore.RegisterCompression("rot1", magic, nil); err == nil {
+		t.Error("expected error registering a duplicate, got nil")
+	}
+
+	const name, contents = "etc/os-release", "ID=acme\n"
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(contents)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, contents); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	blob := bytes.Clone(magic)
+	for _, b := range layer.Bytes() {
+		blob = append(blob, b+1)
+	}
+
+	var l claircore.Layer
+	desc := claircore.LayerDescription{
+		Digest:    "sha256:" + strings.Repeat("00c0ffee", 8),
+		MediaType: `application/vnd.example.layer.v1.tar+rot1`,
+	}
+	if err := l.Init(ctx, &desc, bytes.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if got, want := l.Compression(), "rot1"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	sys, err := l.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(sys, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), contents; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+// The dictionaries in "dict" are built by "mkdict.go" from the reports in
+// "testdata". Each content type has its own dictionary, with its own ID, so a
+// stored blob records which one it needs.
+//
+//go:embed dict/*.zdict
+var dictFS embed.FS
+
+// The content types that have a dictionary, named "dict/<type>.zdict". The IDs
+// set in "mkdict.go" must be unique across them.
+const (
+	contentIndexReport = "indexreport"
+)
+
+var dicts struct {
+	sync.Once
+	// ByType is keyed by content type.
+	byType map[string][]byte
+	// All holds every dictionary, for decoding.
+	all [][]byte
+	err error
+}
+
+// LoadDicts reads the embedded dictionaries once.
+func loadDicts() error {
+	dicts.Do(func() {
+		ents, err := dictFS.ReadDir("dict")
+		if err != nil {
+			dicts.err = err
+			return
+		}
+		dicts.byType = make(map[string][]byte, len(ents))
+		for _, e := range ents {
+			b, err := dictFS.ReadFile(path.Join("dict", e.Name()))
+			if err != nil {
+				dicts.err = err
+				return
+			}
+			ct := e.Name()[:len(e.Name())-len(path.Ext(e.Name()))]
+			dicts.byType[ct] = b
+			dicts.all = append(dicts.all, b)
+		}
+	})
+	return dicts.err
+}
+
+// CompressBlob compresses "b" with the dictionary for the content type "ct".
+func compressBlob(ct string, b []byte) ([]byte, error) {
+	if err := loadDicts(); err != nil {
+		return nil, fmt.Errorf("unable to load dictionaries: %w", err)
+	}
+	dict, ok := dicts.byType[ct]
+	if !ok {
+		return nil, fmt.Errorf("no dictionary for content type %q", ct)
+	}
+	var buf bytes.Buffer
+	w, err := zreader.WriterDict(&buf, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressBlob reverses [compressBlob]. The dictionary is selected by the ID
+// recorded in "b", so the content type isn't needed.
+func decompressBlob(b []byte) ([]byte, error) {
+	if err := loadDicts(); err != nil {
+		return nil, fmt.Errorf("unable to load dictionaries: %w", err)
+	}
+	rd, c, err := zreader.DetectOpts(bytes.NewReader(b), zreader.ReaderOpts{ZstdDicts: dicts.all})
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	if c != zreader.KindZstd {
+		return nil, fmt.Errorf("unexpected compression %v", c)
+	}
+	return io.ReadAll(rd)
+}
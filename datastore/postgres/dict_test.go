@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/internal/zreader"
+)
+
+// LoadReports returns the JSON, as it's stored, of every index report in
+// "testdata", keyed by image.
+func loadReports(t testing.TB) map[string][]byte {
+	t.Helper()
+	ms, err := filepath.Glob("testdata/*.index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string][]byte, len(ms))
+	for _, n := range ms {
+		b, err := os.ReadFile(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ir claircore.IndexReport
+		if err := json.Unmarshal(b, &ir); err != nil {
+			t.Fatal(err)
+		}
+		v, err := jsonbIndexReport(ir).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[filepath.Base(n)[:len(filepath.Base(n))-len(".index.json")]] = v.([]byte)
+	}
+	return out
+}
+
+func TestDictRoundTrip(t *testing.T) {
+	for name, in := range loadReports(t) {
+		z, err := compressBlob(contentIndexReport, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decompressBlob(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+	t.Run("UnknownType", func(t *testing.T) {
+		if _, err := compressBlob("bogus", []byte("{}")); err == nil {
+			t.Error("expected error, got nil")
+		}
+	})
+	t.Run("NoDict", func(t *testing.T) {
+		// Streams without a dictionary still decode.
+		var buf bytes.Buffer
+		w, err := zreader.Writer(&buf, zreader.KindZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "{}")
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := decompressBlob(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(got), "{}"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+}
+
+func TestDictSize(t *testing.T) {
+	reports := loadReports(t)
+	plain := func(t *testing.T, b []byte) int {
+		var buf bytes.Buffer
+		w, err := zreader.Writer(&buf, zreader.KindZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+	withDict := func(t *testing.T, b []byte) int {
+		z, err := compressBlob(contentIndexReport, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(z)
+	}
+
+	// These reports aren't used to build the dictionary; see "mkdict.go".
+	for _, name := range []string{
+		"docker.io-library-debian-9",
+		"docker.io-library-ubuntu-19.10",
+	} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			in, ok := reports[name]
+			if !ok {
+				t.Fatalf("missing report %q", name)
+			}
+			var ir claircore.IndexReport
+			if err := json.Unmarshal(in, &ir); err != nil {
+				t.Fatal(err)
+			}
+			// A small record, like a single package, benefits the most.
+			var id string
+			for k := range ir.Packages {
+				if id == "" || k < id {
+					id = k
+				}
+			}
+			pkg, err := json.Marshal(ir.Packages[id])
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tc := range []struct {
+				Name string
+				In   []byte
+			}{
+				{Name: "Report", In: in},
+				{Name: "Package", In: pkg},
+			} {
+				p, d := plain(t, tc.In), withDict(t, tc.In)
+				t.Logf("%s: %d bytes, without dictionary: %d bytes, with dictionary: %d bytes", tc.Name, len(tc.In), p, d)
+				if d >= p {
+					t.Errorf("%s: dictionary did not help: %d >= %d", tc.Name, d, p)
+				}
+			}
+		})
+	}
+}
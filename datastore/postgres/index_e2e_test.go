@@ -38,6 +38,7 @@ type indexE2e struct {
 	ctx        context.Context
 	manifest   claircore.Manifest
 	scnrs      indexer.VersionedScanners
+	opts       []IndexerOption
 	packageGen int
 	distGen    int
 	repoGen    int
@@ -170,11 +171,35 @@ func TestIndexE2E(t *testing.T) {
 			distGen:    1500,
 			repoGen:    500,
 		},
+		{
+			name: "3 scanners gen small compressed",
+			scnrs: indexer.VersionedScanners{
+				mockScnr{
+					name:    "test-scanner",
+					kind:    "test",
+					version: "v0.0.1",
+				},
+				mockScnr{
+					name:    "test-scanner1",
+					kind:    "test",
+					version: "v0.0.11",
+				},
+				mockScnr{
+					name:    "test-scanner2",
+					kind:    "test",
+					version: "v0.0.8",
+				},
+			},
+			opts:       []IndexerOption{WithDictCompression()},
+			packageGen: 100,
+			distGen:    150,
+			repoGen:    50,
+		},
 	}
 
 	for _, e := range e2es {
 		pool := pgtest.TestIndexerDB(ctx, t)
-		store := NewIndexerStore(pool)
+		store := NewIndexerStore(pool, e.opts...)
 
 		layer := &claircore.Layer{
 			Hash: claircore.MustParseDigest(`sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef`),
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,12 +11,13 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/remind101/migrate"
 
+	"github.com/quay/claircore"
 	"github.com/quay/claircore/datastore/postgres/migrations"
 	"github.com/quay/claircore/indexer"
 )
 
 // InitPostgresIndexerStore initialize a indexer.Store given the pgxpool.Pool
-func InitPostgresIndexerStore(_ context.Context, pool *pgxpool.Pool, doMigration bool) (indexer.Store, error) {
+func InitPostgresIndexerStore(_ context.Context, pool *pgxpool.Pool, doMigration bool, opts ...IndexerOption) (indexer.Store, error) {
 	db := stdlib.OpenDB(*pool.Config().ConnConfig)
 	defer db.Close()
 
@@ -29,7 +31,7 @@ func InitPostgresIndexerStore(_ context.Context, pool *pgxpool.Pool, doMigration
 		}
 	}
 
-	store := NewIndexerStore(pool)
+	store := NewIndexerStore(pool, opts...)
 	return store, nil
 }
 
@@ -40,12 +42,52 @@ var _ indexer.Store = (*IndexerStore)(nil)
 // All the other exported methods live in their own files.
 type IndexerStore struct {
 	pool *pgxpool.Pool
+	// Compress is set by WithDictCompression.
+	compress bool
 }
 
-func NewIndexerStore(pool *pgxpool.Pool) *IndexerStore {
-	return &IndexerStore{
+func NewIndexerStore(pool *pgxpool.Pool, opts ...IndexerOption) *IndexerStore {
+	s := &IndexerStore{
 		pool: pool,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// IndexerOption specifies optional configuration for an IndexerStore.
+type IndexerOption func(s *IndexerStore)
+
+// WithDictCompression configures the IndexerStore to store index reports
+// compressed with zstd, using a dictionary built into claircore for that kind
+// of record. This makes the stored reports smaller, at the cost of the
+// database no longer being able to query their contents.
+//
+// Reports are read back the same way with or without this option, so it can
+// be turned on or off for an existing database. It requires the indexer
+// migrations up to ID 8.
+func WithDictCompression() IndexerOption {
+	return func(s *IndexerStore) {
+		s.compress = true
+	}
+}
+
+// ReportArgs returns the arguments for the "scan_result" and
+// "scan_result_zstd" columns of the indexreport table. Only one is not nil.
+func (s *IndexerStore) reportArgs(ir *claircore.IndexReport) (interface{}, interface{}, error) {
+	if !s.compress {
+		return jsonbIndexReport(*ir), nil, nil
+	}
+	b, err := json.Marshal(jsonbIndexReport(*ir))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to encode index report: %w", err)
+	}
+	z, err := compressBlob(contentIndexReport, b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to compress index report: %w", err)
+	}
+	return nil, z, nil
 }
 
 func (s *IndexerStore) Close(_ context.Context) error {
@@ -37,7 +37,7 @@ var (
 
 func (s *IndexerStore) IndexReport(ctx context.Context, hash claircore.Digest) (*claircore.IndexReport, bool, error) {
 	const query = `
-	SELECT scan_result
+	SELECT scan_result, scan_result_zstd
 	FROM indexreport
 			 JOIN manifest ON manifest.hash = $1
 	WHERE indexreport.manifest_id = manifest.id;
@@ -45,11 +45,14 @@ func (s *IndexerStore) IndexReport(ctx context.Context, hash claircore.Digest) (
 	// we scan into a jsonbIndexReport which has value/scan method set
 	// then type convert back to scanner.domain object
 	var jsr jsonbIndexReport
+	// The report is in only one of the columns, depending on whether the
+	// store that wrote it used WithDictCompression.
+	var js, zs []byte
 
 	ctx, done := context.WithTimeout(ctx, 5*time.Second)
 	defer done()
 	start := time.Now()
-	err := s.pool.QueryRow(ctx, query, hash).Scan(&jsr)
+	err := s.pool.QueryRow(ctx, query, hash).Scan(&js, &zs)
 	switch {
 	case errors.Is(err, nil):
 	case errors.Is(err, pgx.ErrNoRows):
@@ -60,6 +63,16 @@ func (s *IndexerStore) IndexReport(ctx context.Context, hash claircore.Digest) (
 	indexReportCounter.WithLabelValues("query").Add(1)
 	indexReportDuration.WithLabelValues("query").Observe(time.Since(start).Seconds())
 
+	if zs != nil {
+		js, err = decompressBlob(zs)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decompress index report: %w", err)
+		}
+	}
+	if err := jsr.Scan(js); err != nil {
+		return nil, false, fmt.Errorf("failed to decode index report: %w", err)
+	}
+
 	sr := claircore.IndexReport(jsr)
 	return &sr, true, nil
 }
@@ -0,0 +1,4 @@
+-- Index reports written by a store using dictionary compression are kept
+-- here, zstd compressed, instead of in scan_result. Exactly one of the two
+-- columns is set.
+ALTER TABLE indexreport ADD COLUMN IF NOT EXISTS scan_result_zstd bytea;
@@ -0,0 +1,142 @@
+//go:build tools
+
+// Mkdict is the script used to build the zstd dictionaries in the "dict"
+// directory, from the index reports in "testdata". See dict.go for how they're
+// used.
+//
+// The reports for the images in "holdout" are left out, so that tests can
+// measure the dictionaries against reports they weren't built from.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/quay/claircore"
+)
+
+// Holdout must be kept in sync with the test in dict_test.go.
+var holdout = map[string]bool{
+	"docker.io-library-debian-9":     true,
+	"docker.io-library-ubuntu-19.10": true,
+}
+
+func main() {
+	log.SetFlags(0)
+	ms, err := filepath.Glob("testdata/*.index.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(ms)
+	var recs [][][]byte
+	for _, n := range ms {
+		if holdout[strings.TrimSuffix(filepath.Base(n), ".index.json")] {
+			continue
+		}
+		rs, err := records(n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recs = append(recs, rs)
+	}
+	// Take records from each report in turn, so the history isn't all from
+	// the first one.
+	var contents [][]byte
+	var hist bytes.Buffer
+	for i := 0; ; i++ {
+		more := false
+		for _, rs := range recs {
+			if i >= len(rs) {
+				continue
+			}
+			more = true
+			contents = append(contents, rs[i])
+			if hist.Len()+len(rs[i]) <= histSize {
+				hist.Write(rs[i])
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       indexReportDictID,
+		Contents: contents,
+		History:  hist.Bytes(),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("dict", "indexreport.zdict"), dict, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d byte dictionary from %d records", len(dict), len(contents))
+}
+
+const (
+	// IndexReportDictID is the ID of the "indexreport" dictionary.
+	indexReportDictID = 0x636c6301
+	histSize          = 32 * 1024
+)
+
+// Records returns the index report in "n" split into the JSON of its
+// packages, distributions, repositories, and environments, followed by the
+// whole report.
+func records(n string) ([][]byte, error) {
+	b, err := os.ReadFile(n)
+	if err != nil {
+		return nil, err
+	}
+	var ir claircore.IndexReport
+	if err := json.Unmarshal(b, &ir); err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	add := func(v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		out = append(out, b)
+		return nil
+	}
+	for _, id := range keys(ir.Packages) {
+		if err := add(ir.Packages[id]); err != nil {
+			return nil, err
+		}
+		if err := add(ir.Environments[id]); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range keys(ir.Distributions) {
+		if err := add(ir.Distributions[id]); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range keys(ir.Repositories) {
+		if err := add(ir.Repositories[id]); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(&ir); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -66,13 +66,13 @@ WITH
 		)
 INSERT
 INTO
-	indexreport (manifest_id, scan_result)
+	indexreport (manifest_id, scan_result, scan_result_zstd)
 VALUES
-	((SELECT manifest_id FROM manifests), $2)
+	((SELECT manifest_id FROM manifests), $2, $3)
 ON CONFLICT
 	(manifest_id)
 DO
-	UPDATE SET scan_result = excluded.scan_result;
+	UPDATE SET scan_result = excluded.scan_result, scan_result_zstd = excluded.scan_result_zstd;
 `
 	)
 
@@ -103,12 +103,16 @@ DO
 	}
 
 	// push IndexReport to the store
-	// we cast claircore.IndexReport to jsonbIndexReport in order to obtain the value/scan
-	// implementations
+	// reportArgs casts claircore.IndexReport to jsonbIndexReport in order to
+	// obtain the value/scan implementations, or compresses it
+	js, zs, err := s.reportArgs(ir)
+	if err != nil {
+		return err
+	}
 
 	tctx, done = context.WithTimeout(ctx, 5*time.Second)
 	start := time.Now()
-	_, err = tx.Exec(tctx, upsertIndexReport, ir.Hash, jsonbIndexReport(*ir))
+	_, err = tx.Exec(tctx, upsertIndexReport, ir.Hash, js, zs)
 	done()
 	if err != nil {
 		return fmt.Errorf("failed to upsert scan result: %w", err)
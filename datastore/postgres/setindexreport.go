@@ -47,21 +47,25 @@ WITH
 		)
 INSERT
 INTO
-	indexreport (manifest_id, scan_result)
+	indexreport (manifest_id, scan_result, scan_result_zstd)
 VALUES
-	((SELECT manifest_id FROM manifests), $2)
+	((SELECT manifest_id FROM manifests), $2, $3)
 ON CONFLICT
 	(manifest_id)
 DO
-	UPDATE SET scan_result = excluded.scan_result;
+	UPDATE SET scan_result = excluded.scan_result, scan_result_zstd = excluded.scan_result_zstd;
 `
-	// we cast scanner.IndexReport to jsonbIndexReport in order to obtain the value/scan
-	// implementations
+	// reportArgs casts scanner.IndexReport to jsonbIndexReport in order to
+	// obtain the value/scan implementations, or compresses it
+	js, zs, err := s.reportArgs(ir)
+	if err != nil {
+		return err
+	}
 
 	ctx, done := context.WithTimeout(ctx, 30*time.Second)
 	defer done()
 	start := time.Now()
-	_, err := s.pool.Exec(ctx, query, ir.Hash, jsonbIndexReport(*ir))
+	_, err = s.pool.Exec(ctx, query, ir.Hash, js, zs)
 	if err != nil {
 		return fmt.Errorf("failed to upsert index report: %w", err)
 	}
@@ -0,0 +1,70 @@
+package claircore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+func TestDecompressLimit(t *testing.T) {
+	ctx := context.Background()
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(make([]byte, 1024*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l := Layer{compression: zreader.KindNone}
+	t.Cleanup(func() {
+		for _, f := range l.cleanup {
+			f.Close()
+		}
+	})
+	err := l.decompress(ctx, bytes.NewReader(gz.Bytes()), 1024)
+	t.Log(err)
+	if !errors.Is(err, zreader.ErrSizeLimit) {
+		t.Errorf("got: %v, want: %v", err, zreader.ErrSizeLimit)
+	}
+	// A failed decompression doesn't record a scheme.
+	if got, want := l.compression, zreader.KindNone; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if l.rd != nil {
+		t.Error("unexpected reader set on error")
+	}
+
+	if err := l.decompress(ctx, bytes.NewReader(gz.Bytes()), maxLayerSize); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.compression, zreader.KindGzip; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestDecompressWeak(t *testing.T) {
+	ctx := context.Background()
+	// A valid zlib header, but a weak match: uncompressed layers can start
+	// with these bytes.
+	in := append([]byte("x^"), make([]byte, 1024)...)
+	if got, want := zreader.DetectBytes(in), zreader.KindZlib; got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+	var l Layer
+	if err := l.decompress(ctx, bytes.NewReader(in), maxLayerSize); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.compression, zreader.KindNone; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if l.rd != nil || len(l.cleanup) != 0 {
+		t.Error("weak match decompressed")
+	}
+}
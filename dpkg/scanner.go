@@ -28,8 +28,10 @@ const (
 )
 
 var (
-	_ indexer.VersionedScanner = (*Scanner)(nil)
-	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.VersionedScanner        = (*Scanner)(nil)
+	_ indexer.PackageScanner          = (*Scanner)(nil)
+	_ indexer.ConfigurableScanner     = (*Scanner)(nil)
+	_ indexer.StreamingPackageScanner = (*Scanner)(nil)
 )
 
 // Scanner implements the scanner.PackageScanner interface.
@@ -38,7 +40,10 @@ var (
 // "status" file it finds there.
 //
 // The zero value is ready to use.
-type Scanner struct{}
+type Scanner struct {
+	// Streaming is set by Configure.
+	streaming bool
+}
 
 // Name implements scanner.VersionedScanner.
 func (ps *Scanner) Name() string { return name }
@@ -115,71 +120,18 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 			return nil, fmt.Errorf("reading status file from layer failed: %w", err)
 		}
 
-		// Take all the packages found in the database and attach to the slice
-		// defined outside the loop.
-		found := make(map[string]*claircore.Package)
-		// The database is actually an RFC822-like message with "\n\n"
-		// separators, so don't be alarmed by the usage of the "net/textproto"
-		// package here.
-		tp := textproto.NewReader(bufio.NewReader(db))
-	Restart:
-		hdr, err := tp.ReadMIMEHeader()
-		for ; err == nil && len(hdr) > 0; hdr, err = tp.ReadMIMEHeader() {
-			var ok, installed bool
-			for _, s := range strings.Fields(hdr.Get("Status")) {
-				switch s {
-				case "installed":
-					installed = true
-				case "ok":
-					ok = true
-				}
-			}
-			if !ok || !installed {
-				continue
-			}
-			name := hdr.Get("Package")
-			v := hdr.Get("Version")
-			p := &claircore.Package{
-				Name:      name,
-				Version:   v,
-				Kind:      claircore.BINARY,
-				Arch:      hdr.Get("Architecture"),
-				PackageDB: fn,
-			}
-			if src := hdr.Get("Source"); src != "" {
-				p.Source = &claircore.Package{
-					Name: src,
-					Kind: claircore.SOURCE,
-					// Right now, this is an assumption that discovered source
-					// packages relate to their binary versions. We see this in
-					// Debian.
-					Version:   v,
-					PackageDB: fn,
-				}
-			}
+		found, ps := parseStatus(ctx, fn, db)
+		db.Close()
+		pkgs = append(pkgs, ps...)
 
-			found[name] = p
-			pkgs = append(pkgs, p)
-		}
-		switch {
-		case errors.Is(err, io.EOF):
-		default:
-			zlog.Warn(ctx).Err(err).Msg("unable to read entry")
-			goto Restart
-		}
-
-		const suffix = ".md5sums"
-		ms, err := fs.Glob(sys, filepath.Join(p, "info", "*"+suffix))
+		ms, err := fs.Glob(sys, filepath.Join(p, "info", "*"+md5Suffix))
 		if err != nil {
 			// ???
 			return nil, fmt.Errorf("resetting tar reader failed: %w", err)
 		}
 		hash := md5.New()
 		for _, n := range ms {
-			k := strings.TrimSuffix(filepath.Base(n), suffix)
-			if i := strings.IndexRune(k, ':'); i != -1 {
-				k = k[:i]
-			}
+			k := md5Key(n)
 			p, ok := found[k]
 			if !ok {
 				zlog.Debug(ctx).
@@ -210,3 +162,74 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 
 	return pkgs, nil
 }
+
+// ParseStatus reads the packages from the dpkg "status" file "db", found at
+// "fn". The returned map is keyed by package name and holds the same values as
+// the slice.
+func parseStatus(ctx context.Context, fn string, db io.Reader) (map[string]*claircore.Package, []*claircore.Package) {
+	var pkgs []*claircore.Package
+	found := make(map[string]*claircore.Package)
+	// The database is actually an RFC822-like message with "\n\n"
+	// separators, so don't be alarmed by the usage of the "net/textproto"
+	// package here.
+	tp := textproto.NewReader(bufio.NewReader(db))
+Restart:
+	hdr, err := tp.ReadMIMEHeader()
+	for ; err == nil && len(hdr) > 0; hdr, err = tp.ReadMIMEHeader() {
+		var ok, installed bool
+		for _, s := range strings.Fields(hdr.Get("Status")) {
+			switch s {
+			case "installed":
+				installed = true
+			case "ok":
+				ok = true
+			}
+		}
+		if !ok || !installed {
+			continue
+		}
+		name := hdr.Get("Package")
+		v := hdr.Get("Version")
+		p := &claircore.Package{
+			Name:      name,
+			Version:   v,
+			Kind:      claircore.BINARY,
+			Arch:      hdr.Get("Architecture"),
+			PackageDB: fn,
+		}
+		if src := hdr.Get("Source"); src != "" {
+			p.Source = &claircore.Package{
+				Name: src,
+				Kind: claircore.SOURCE,
+				// Right now, this is an assumption that discovered source
+				// packages relate to their binary versions. We see this in
+				// Debian.
+				Version:   v,
+				PackageDB: fn,
+			}
+		}
+
+		found[name] = p
+		pkgs = append(pkgs, p)
+	}
+	switch {
+	case errors.Is(err, io.EOF):
+	default:
+		zlog.Warn(ctx).Err(err).Msg("unable to read entry")
+		goto Restart
+	}
+	return found, pkgs
+}
+
+// Md5Suffix is the suffix of the per-package checksum files in a dpkg
+// database's "info" directory.
+const md5Suffix = ".md5sums"
+
+// Md5Key returns the package name for the checksum file "n".
+func md5Key(n string) string {
+	k := strings.TrimSuffix(filepath.Base(n), md5Suffix)
+	if i := strings.IndexRune(k, ':'); i != -1 {
+		k = k[:i]
+	}
+	return k
+}
@@ -0,0 +1,147 @@
+package dpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime/trace"
+	"sort"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// ScannerConfig is the struct used to configure a Scanner.
+type ScannerConfig struct {
+	// Streaming causes the Scanner to read the layer as a stream of tar
+	// entries instead of through the layer's fs.FS. See [Scanner.ScanStream].
+	Streaming bool `yaml:"streaming" json:"streaming"`
+}
+
+// Configure implements indexer.ConfigurableScanner.
+func (ps *Scanner) Configure(ctx context.Context, f indexer.ConfigDeserializer) error {
+	var cfg ScannerConfig
+	if err := f(&cfg); err != nil {
+		return err
+	}
+	ps.streaming = cfg.Streaming
+	return nil
+}
+
+// WantsStreaming implements indexer.StreamingPackageScanner.
+func (ps *Scanner) WantsStreaming() bool { return ps.streaming }
+
+// ScanStream implements indexer.StreamingPackageScanner.
+//
+// It reports the same packages as Scan, reading the layer's entries in order
+// instead of through the layer's fs.FS. The whole layer is read, so that every
+// database is found and a file that's replaced later in the layer is seen in
+// its final form.
+func (ps *Scanner) ScanStream(ctx context.Context, layer *claircore.Layer, tr *tar.Reader) ([]*claircore.Package, error) {
+	defer trace.StartRegion(ctx, "Scanner.ScanStream").End()
+	trace.Log(ctx, "layer", layer.Hash.String())
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "dpkg/Scanner.ScanStream",
+		"version", ps.Version(),
+		"layer", layer.Hash.String())
+	zlog.Debug(ctx).Msg("start")
+	defer zlog.Debug(ctx).Msg("done")
+
+	// These are keyed by directory, in the same form as Scan uses.
+	info := make(map[string]bool)
+	status := make(map[string][]byte)
+	sums := make(map[string]map[string]string)
+	hash := md5.New()
+Entries:
+	for {
+		h, err := tr.Next()
+		switch {
+		case errors.Is(err, nil):
+		case errors.Is(err, io.EOF):
+			break Entries
+		default:
+			return nil, fmt.Errorf("dpkg: reading layer failed: %w", err)
+		}
+		n := strings.TrimPrefix(path.Clean("/"+h.Name), "/")
+		dir, f := path.Split(n)
+		if h.Typeflag == tar.TypeDir {
+			if f == "info" {
+				info[dir] = true
+			}
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		// A file in an "info" directory means the directory exists, even if
+		// the archive has no entry for it.
+		if pdir, pf := path.Split(strings.TrimSuffix(dir, "/")); pf == "info" {
+			info[pdir] = true
+			if strings.HasSuffix(f, md5Suffix) {
+				hash.Reset()
+				if _, err := io.Copy(hash, tr); err != nil {
+					zlog.Warn(ctx).
+						Err(err).
+						Str("package", n).
+						Msg("unable to read package metadata")
+					continue
+				}
+				if sums[pdir] == nil {
+					sums[pdir] = make(map[string]string)
+				}
+				sums[pdir][md5Key(f)] = hex.EncodeToString(hash.Sum(nil))
+			}
+			continue
+		}
+		if f != "status" {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading status file from layer failed: %w", err)
+		}
+		status[dir] = b
+	}
+
+	// Sort the databases, so that packages are reported in the same order
+	// every time.
+	dbs := make([]string, 0, len(status))
+	for p := range status {
+		dbs = append(dbs, p)
+	}
+	sort.Strings(dbs)
+	var pkgs []*claircore.Package
+	for _, p := range dbs {
+		if !info[p] {
+			continue
+		}
+		b := status[p]
+		ctx := zlog.ContextWithValues(ctx, "database", p)
+		zlog.Debug(ctx).Msg("examining package database")
+		found, ps := parseStatus(ctx, path.Join(p, "status"), bytes.NewReader(b))
+		pkgs = append(pkgs, ps...)
+		for k, sum := range sums[p] {
+			p, ok := found[k]
+			if !ok {
+				zlog.Debug(ctx).
+					Str("package", k).
+					Msg("extra metadata found, ignoring")
+				continue
+			}
+			p.RepositoryHint = sum
+		}
+		zlog.Debug(ctx).
+			Int("count", len(found)).
+			Msg("found packages")
+	}
+	return pkgs, nil
+}
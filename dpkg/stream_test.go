@@ -0,0 +1,233 @@
+package dpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/test"
+)
+
+// CountReader counts the bytes read through it.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// StreamLayer returns a layer with a dpkg database followed by "filler" bytes
+// of other files. If "statusFirst" is set, the status file comes before the
+// info directory.
+func streamLayer(t testing.TB, filler int, statusFirst bool) []byte {
+	t.Helper()
+	const statusfile = `Package: bogus
+Status: install ok installed
+Priority: important
+Section: admin
+Installed-Size: 0
+Maintainer: Veryreal Developer <email@example.com>
+Architecture: all
+Version: 1
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: all
+Version: 2
+
+`
+	const sums = "d41d8cd98f00b204e9800998ecf8427e  usr/bin/bogus\n"
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	dir := func(n string) {
+		if err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: n, Mode: 0o755}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(n string, b []byte) {
+		if err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: n, Size: int64(len(b)), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir("var/")
+	dir("var/lib/")
+	dir("var/lib/dpkg/")
+	if statusFirst {
+		file("var/lib/dpkg/status", []byte(statusfile))
+	}
+	dir("var/lib/dpkg/info/")
+	file("var/lib/dpkg/info/bogus.md5sums", []byte(sums))
+	if !statusFirst {
+		file("var/lib/dpkg/status", []byte(statusfile))
+	}
+	dir("var/lib/filler/")
+	chunk := bytes.Repeat([]byte{'x'}, 1024*1024)
+	for i := 0; i*len(chunk) < filler; i++ {
+		file("var/lib/filler/"+hex.EncodeToString([]byte{byte(i)}), chunk)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScanStream(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	sum := md5.Sum([]byte("d41d8cd98f00b204e9800998ecf8427e  usr/bin/bogus\n"))
+	want := []*claircore.Package{
+		{
+			Name:           "bogus",
+			Version:        "1",
+			Kind:           claircore.BINARY,
+			Arch:           "all",
+			PackageDB:      "var/lib/dpkg/status",
+			RepositoryHint: hex.EncodeToString(sum[:]),
+		},
+	}
+	const filler = 4 * 1024 * 1024
+
+	for _, statusFirst := range []bool{false, true} {
+		name := "InfoFirst"
+		if statusFirst {
+			name = "StatusFirst"
+		}
+		t.Run(name, func(t *testing.T) {
+			in := streamLayer(t, filler, statusFirst)
+			var l claircore.Layer
+			if err := l.Init(ctx, &test.AnyDescription, bytes.NewReader(in)); err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			s := &Scanner{streaming: true}
+
+			cr := &countReader{r: bytes.NewReader(in)}
+			got, err := s.ScanStream(ctx, &l, tar.NewReader(cr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			if cr.n < filler {
+				t.Errorf("read %d bytes, expected the whole layer", cr.n)
+			}
+			t.Logf("read %d of %d bytes", cr.n, len(in))
+
+			// The FS-based scan should find the same packages.
+			fsgot, err := s.Scan(ctx, &l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(fsgot, want) {
+				t.Error(cmp.Diff(fsgot, want))
+			}
+		})
+	}
+}
+
+func TestScanStreamMultiple(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	status := func(name, version string) []byte {
+		return []byte("Package: " + name + "\n" +
+			"Status: install ok installed\n" +
+			"Architecture: all\n" +
+			"Version: " + version + "\n\n")
+	}
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	file := func(n string, b []byte) {
+		if err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: n, Size: int64(len(b)), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two databases, with the first's status file replaced later in the
+	// layer, and a status file without an info directory.
+	file("var/lib/dpkg/status", status("old", "1"))
+	file("var/lib/dpkg/info/new.md5sums", []byte("d41d8cd98f00b204e9800998ecf8427e  usr/bin/new\n"))
+	file("opt/extra/dpkg/status", status("extra", "2"))
+	file("opt/extra/dpkg/info/extra.md5sums", []byte("d41d8cd98f00b204e9800998ecf8427e  opt/bin/extra\n"))
+	file("srv/dpkg/status", status("stray", "3"))
+	file("var/lib/dpkg/status", status("new", "4"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	in := buf.Bytes()
+
+	var l claircore.Layer
+	if err := l.Init(ctx, &test.AnyDescription, bytes.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &Scanner{streaming: true}
+	// Scan reports databases in map order.
+	sortPkgs := func(ps []*claircore.Package) {
+		sort.Slice(ps, func(i, j int) bool {
+			return ps[i].PackageDB < ps[j].PackageDB
+		})
+	}
+
+	want, err := s.Scan(ctx, &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortPkgs(want)
+	got, err := s.ScanStream(ctx, &l, tar.NewReader(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortPkgs(got)
+	var names []string
+	for _, p := range want {
+		names = append(names, p.Name)
+	}
+	if got, want := strings.Join(names, ","), "extra,new"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
+
+func TestConfigureStreaming(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	for _, tc := range []struct {
+		In   string
+		Want bool
+	}{
+		{In: `{}`, Want: false},
+		{In: `{"streaming":true}`, Want: true},
+	} {
+		var s Scanner
+		if err := s.Configure(ctx, func(v interface{}) error {
+			return json.Unmarshal([]byte(tc.In), v)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := s.WantsStreaming(), tc.Want; got != want {
+			t.Errorf("%s: got: %v, want: %v", tc.In, got, want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/Masterminds/semver v1.5.0
+	github.com/andybalholm/brotli v1.0.6
 	github.com/doug-martin/goqu/v8 v8.6.0
 	github.com/golang/mock v1.6.0
 	github.com/google/go-cmp v0.6.0
@@ -15,6 +16,7 @@ require (
 	github.com/knqyf263/go-apk-version v0.0.0-20200609155635-041fdbb8563f
 	github.com/knqyf263/go-deb-version v0.0.0-20190517075300-09fca494f03d
 	github.com/knqyf263/go-rpm-version v0.0.0-20170716094938-74609b86c936
+	github.com/pierrec/lz4/v4 v4.1.18
 	github.com/prometheus/client_golang v1.17.0
 	github.com/quay/claircore/toolkit v1.1.1
 	github.com/quay/claircore/updater/driver v1.0.0
@@ -30,6 +30,16 @@ func fetchLayers(ctx context.Context, s *Controller) (State, error) {
 	// LayerDescription type is plumbed through the Indexer, this can be
 	// removed.
 	wart.CopyLayerPointers(s.manifest.Layers, toFetch)
+	for _, l := range toFetch {
+		c := l.Compression()
+		if c == "" {
+			continue
+		}
+		if s.report.LayerCompression == nil {
+			s.report.LayerCompression = make(map[string]string, len(toFetch))
+		}
+		s.report.LayerCompression[l.Hash.String()] = c
+	}
 	zlog.Info(ctx).Msg("layers fetch success")
 	return ScanLayers, nil
 }
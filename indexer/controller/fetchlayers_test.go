@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	indexer_mock "github.com/quay/claircore/test/mock/indexer"
+)
+
+func TestFetchLayersCompression(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	ctrl := gomock.NewController(t)
+
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+	const contents = "hello\n"
+	if err := tw.WriteHeader(&tar.Header{Name: "hello", Size: int64(len(contents)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	blob := buf.Bytes()
+
+	digest := `sha256:` + strings.Repeat(`beef`, 16)
+	layers := []*claircore.Layer{{Hash: claircore.MustParseDigest(digest)}}
+	store := indexer_mock.NewMockStore(ctrl)
+	store.EXPECT().LayerScanned(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	realizer := indexer_mock.NewMockRealizer(ctrl)
+	realizer.EXPECT().Realize(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, ls []*claircore.Layer) error {
+			for _, l := range ls {
+				l := l
+				desc := claircore.LayerDescription{
+					Digest:    l.Hash.String(),
+					MediaType: `application/vnd.oci.image.layer.v1.tar+zstd`,
+				}
+				if err := l.Init(ctx, &desc, bytes.NewReader(blob)); err != nil {
+					return err
+				}
+				t.Cleanup(func() { l.Close() })
+			}
+			return nil
+		})
+
+	c := New(&indexer.Options{
+		Store:  store,
+		Vscnrs: indexer.VersionedScanners{indexer.NewPackageScannerMock("mock", "1", "package")},
+	})
+	c.Realizer = realizer
+	c.manifest = &claircore.Manifest{Layers: layers}
+
+	state, err := fetchLayers(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := state, ScanLayers; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if got, want := c.report.LayerCompression[digest], "zstd"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	b, err := json.Marshal(c.report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		LayerCompression map[string]string `json:"layer_compression"`
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report.LayerCompression[digest], "zstd"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
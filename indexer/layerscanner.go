@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"archive/tar"
 	"context"
 	"errors"
 	"fmt"
@@ -227,7 +228,11 @@ func (r *result) Do(ctx context.Context, s VersionedScanner, l *claircore.Layer)
 	var err error
 	switch s := s.(type) {
 	case PackageScanner:
-		r.pkgs, err = s.Scan(ctx, l)
+		if ss, ok := s.(StreamingPackageScanner); ok && ss.WantsStreaming() {
+			r.pkgs, err = scanStream(ctx, ss, l)
+		} else {
+			r.pkgs, err = s.Scan(ctx, l)
+		}
 		if sc, ok := s.(DefaultRepoScanner); ok {
 			if len(r.pkgs) > 0 {
 				r.repos = append(r.repos, sc.DefaultRepository(ctx))
@@ -256,6 +261,18 @@ func (r *result) Do(ctx context.Context, s VersionedScanner, l *claircore.Layer)
 	return err
 }
 
+// ScanStream calls ScanStream on the scanner with a tar.Reader over the
+// contents of the layer. These are already decompressed by
+// [claircore.Layer.Init], so they're read as a plain tar.
+func scanStream(ctx context.Context, s StreamingPackageScanner, l *claircore.Layer) ([]*claircore.Package, error) {
+	rd, err := l.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return s.ScanStream(ctx, l, tar.NewReader(rd))
+}
+
 // Store calls the properly typed store method on whatever value was captured in
 // the result.
 func (r *result) Store(ctx context.Context, store Store, s VersionedScanner, l *claircore.Layer) error {
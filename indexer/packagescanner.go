@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"archive/tar"
 	"context"
 
 	"github.com/quay/claircore"
@@ -53,3 +54,25 @@ func NewPackageScannerMock(name, version, kind string) PackageScanner {
 type DefaultRepoScanner interface {
 	DefaultRepository(context.Context) *claircore.Repository
 }
+
+// StreamingPackageScanner is a PackageScanner that can also examine a layer
+// as a stream of tar entries, for scanners that only need a few specific
+// files and can stop reading once they're found.
+//
+// If WantsStreaming reports true, the LayerScanner calls ScanStream instead of
+// Scan. The tar.Reader is positioned at the start of the decompressed layer;
+// the scanner reads entries with Next as needed and may return at any point,
+// and the rest of the layer is left unread. Entries are presented in archive
+// order, so unlike the layer's fs.FS, a later entry for the same path is not
+// merged with earlier ones.
+//
+// The layer's fs.FS is only built when something asks for it, so if every
+// scanner run against a layer streams, the whole layer is never indexed.
+type StreamingPackageScanner interface {
+	PackageScanner
+	// WantsStreaming reports whether ScanStream should be used.
+	WantsStreaming() bool
+	// ScanStream performs a package scan over the entries of the given layer
+	// and returns all the found packages.
+	ScanStream(context.Context, *claircore.Layer, *tar.Reader) ([]*claircore.Package, error)
+}
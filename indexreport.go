@@ -35,6 +35,9 @@ type IndexReport struct {
 	Err string `json:"err"`
 	// Files doesn't end up in the json report but needs to be available at post-coalesce
 	Files map[string]File `json:"-"`
+	// the compression scheme of each layer blob key'd by layer digest, as reported by
+	// [Layer.Compression]. Only layers fetched while creating this report are present.
+	LayerCompression map[string]string `json:"layer_compression,omitempty"`
 }
 
 // IndexRecords returns a list of IndexRecords derived from the IndexReport
@@ -0,0 +1,539 @@
+package zreader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Bzip2 stream constants. The magic numbers are 48 bits long and not byte
+// aligned, except for the first block of a stream.
+const (
+	bzBlockMagic = 0x314159265359
+	bzEOSMagic   = 0x177245385090
+	bzMagicMask  = 1<<48 - 1
+)
+
+// NewBzip2Parallel returns an [io.ReadCloser] decoding the bzip2 stream "r"
+// with multiple goroutines.
+//
+// Bzip2 blocks are independent, so the stream is split at every block magic
+// number, and each block is reframed as a single-block stream and handed to
+// the standard library's decoder. The output is reassembled in order, and the
+// block and stream checksums are checked the same as the serial decoder.
+//
+// Block magic numbers are not escaped in the compressed data, so a block can
+// be split in the wrong place. Once a block fails to decode, the rest of its
+// stream is decoded serially from that block's start, so any number of
+// spurious splits are handled. Errors from the serial decoder are reported.
+func newBzip2Parallel(r io.Reader) *bzParallel {
+	n := runtime.GOMAXPROCS(0)
+	p := &bzParallel{
+		order: make(chan *bzJob, 2*n),
+		work:  make(chan *bzJob, n),
+		quit:  make(chan struct{}),
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	go p.split(br)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range p.work {
+				j.out, j.err = bzDecode(j.level, j.crc, j.seg)
+				close(j.done)
+			}
+		}()
+	}
+	return p
+}
+
+// BzParallel is the Reader returned by newBzip2Parallel.
+type bzParallel struct {
+	// Order has every job in stream order. Work has the same jobs, for the
+	// worker goroutines.
+	order, work chan *bzJob
+	quit        chan struct{}
+	closer      sync.Once
+
+	// These are only used by Read.
+	peek     *bzJob
+	cur      []byte
+	combined uint32
+	err      error
+	// Serial, if not nil, is decoding the rest of the current stream after
+	// a block failed to decode.
+	serial *bzSerial
+}
+
+var _ io.ReadCloser = (*bzParallel)(nil)
+
+// BzSeg is the run of bits [s, e) in b, counting from the most significant
+// bit of b[0].
+type bzSeg struct {
+	b    []byte
+	s, e int
+}
+
+// Bits returns the "n" bits starting "off" bits into the segment.
+func (s bzSeg) bits(off, n int) uint64 {
+	var v uint64
+	for i := s.s + off; i < s.s+off+n; i++ {
+		v = v<<1 | uint64(s.b[i/8]>>(7-i%8)&1)
+	}
+	return v
+}
+
+// BzJob is a single block.
+type bzJob struct {
+	seg   bzSeg
+	level byte
+	// Crc is the block checksum.
+	crc uint32
+	// Stream is the index of the stream the block is in. End is set if the
+	// block is the last in its stream, and streamCRC is the stream checksum.
+	stream    int
+	end       bool
+	streamCRC uint32
+
+	// Done is closed once out and err are populated.
+	done chan struct{}
+	out  []byte
+	err  error
+}
+
+// Split reads "br", sending a job for every block to the order and work
+// channels. Errors are sent as a job with only the err member populated.
+func (p *bzParallel) split(br *bufio.Reader) {
+	defer close(p.work)
+	defer close(p.order)
+	send := func(j *bzJob) bool {
+		select {
+		case p.order <- j:
+		case <-p.quit:
+			return false
+		}
+		if j.seg.b == nil {
+			return true
+		}
+		select {
+		case p.work <- j:
+		case <-p.quit:
+			return false
+		}
+		return true
+	}
+	fail := func(err error) {
+		j := &bzJob{err: err, done: make(chan struct{})}
+		close(j.done)
+		send(j)
+	}
+
+	for stream := 0; ; stream++ {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if stream > 0 && errors.Is(err, io.EOF) {
+				return
+			}
+			fail(unexpected(err))
+			return
+		}
+		if hdr[0] != 'B' || hdr[1] != 'Z' {
+			if stream > 0 {
+				fail(bzip2.StructuralError("bad magic value in continuation file"))
+			} else {
+				fail(bzip2.StructuralError("bad magic value"))
+			}
+			return
+		}
+		if hdr[2] != 'h' || hdr[3] < '1' || hdr[3] > '9' {
+			fail(bzip2.StructuralError("invalid compression level"))
+			return
+		}
+		level := hdr[3]
+
+		var (
+			buf []byte
+			// Start is the bit offset of the current block in buf, or -1
+			// before the first block.
+			start = -1
+			// Nbits is the number of bits of buf that have been examined.
+			nbits int
+			reg   uint64
+			// Last is the block ended by the end-of-stream magic, if any,
+			// which is sent once the stream checksum is read.
+			last    *bzJob
+			crcLeft int
+			crc     uint32
+		)
+		// Block returns a job for the block ending at "end".
+		block := func(end int) (*bzJob, bool) {
+			if end-start < 48+32 {
+				return nil, false
+			}
+			seg := bzSeg{b: buf, s: start, e: end}
+			return &bzJob{
+				seg:    seg,
+				level:  level,
+				crc:    uint32(seg.bits(48, 32)),
+				stream: stream,
+				done:   make(chan struct{}),
+			}, true
+		}
+	Stream:
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				fail(unexpected(err))
+				return
+			}
+			buf = append(buf, c)
+			for k := 7; k >= 0; k-- {
+				bit := uint64(c >> k & 1)
+				nbits++
+				if crcLeft > 0 {
+					crc = crc<<1 | uint32(bit)
+					if crcLeft--; crcLeft == 0 {
+						// The rest of this byte is padding.
+						break Stream
+					}
+					continue
+				}
+				reg = reg<<1 | bit
+				if nbits < 48 {
+					continue
+				}
+				m := reg & bzMagicMask
+				if m != bzBlockMagic && m != bzEOSMagic {
+					if start < 0 && nbits == 48 {
+						fail(bzip2.StructuralError("bad magic value found"))
+						return
+					}
+					continue
+				}
+				at := nbits - 48
+				if start >= 0 {
+					j, ok := block(at)
+					if !ok {
+						fail(bzip2.StructuralError("bad magic value found"))
+						return
+					}
+					if m == bzEOSMagic {
+						last = j
+					} else if !send(j) {
+						return
+					}
+				}
+				if m == bzEOSMagic {
+					crcLeft = 32
+					continue
+				}
+				// Start a new buffer for the new block, so that the
+				// previous block's bytes are left alone.
+				rm := at / 8 * 8
+				buf = append([]byte(nil), buf[at/8:]...)
+				start = at - rm
+				nbits -= rm
+			}
+		}
+		if last == nil {
+			// An empty stream.
+			if crc != 0 {
+				fail(bzip2.StructuralError("file checksum mismatch"))
+				return
+			}
+			continue
+		}
+		last.end, last.streamCRC = true, crc
+		if !send(last) {
+			return
+		}
+	}
+}
+
+// Next returns the next job in stream order.
+func (p *bzParallel) next() (*bzJob, bool) {
+	if j := p.peek; j != nil {
+		p.peek = nil
+		return j, true
+	}
+	j, ok := <-p.order
+	return j, ok
+}
+
+// Read implements [io.Reader].
+func (p *bzParallel) Read(b []byte) (int, error) {
+	for len(p.cur) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if p.serial != nil {
+			p.readSerial()
+			continue
+		}
+		j, ok := p.next()
+		if !ok {
+			p.err = io.EOF
+			continue
+		}
+		<-j.done
+		if j.seg.b == nil {
+			p.err = j.err
+			continue
+		}
+		if j.err != nil {
+			p.serial = newBzSerial(p, j)
+			continue
+		}
+		p.combined = (p.combined<<1 | p.combined>>31) ^ j.crc
+		if j.end {
+			p.endStream(j.streamCRC)
+		}
+		p.cur = j.out
+	}
+	n := copy(b, p.cur)
+	p.cur = p.cur[n:]
+	return n, nil
+}
+
+// EndStream checks the combined block checksums against the stream checksum
+// "crc" and starts over for the next stream.
+func (p *bzParallel) endStream(crc uint32) {
+	if p.combined != crc {
+		p.err = bzip2.StructuralError("file checksum mismatch")
+	}
+	p.combined = 0
+}
+
+// ReadSerial fills "cur" from the serial decoder.
+//
+// The standard library's decoder reads a whole block before returning any of
+// its output, and no Read returns output from more than one block. So the
+// number of bytes of the source consumed while a Read returns output marks
+// the end of a block, even if the splitter found spurious magic numbers inside
+// it. This is enough to know the true blocks and combine their checksums the
+// same as for blocks decoded in parallel.
+func (p *bzParallel) readSerial() {
+	s := p.serial
+	n, err := s.zr.Read(s.buf)
+	if n > 0 && s.src.pos != s.pos {
+		// A new block, starting where the previous one ended.
+		s.pos = s.src.pos
+		p.combined = (p.combined<<1 | p.combined>>31) ^ s.src.blocks[s.start].job.crc
+		s.start = len(s.src.blocks)
+		for i := s.start - 1; i >= 0 && (s.src.blocks[i].off+7)/8 >= s.pos; i-- {
+			if (s.src.blocks[i].off+7)/8 == s.pos {
+				s.start = i
+			}
+		}
+		// If the block doesn't end at a block start the source has seen, it
+		// ends where the next block will be added, or at the end of the
+		// stream.
+		s.last = s.start == len(s.src.blocks) && s.src.done
+	}
+	p.cur = s.buf[:n]
+	switch {
+	case err == nil:
+	case s.src.err != nil:
+		p.err = s.src.err
+	case errors.Is(err, io.ErrUnexpectedEOF) && s.last:
+		// The source doesn't have an end-of-stream marker, so running out
+		// after the last block is the expected end.
+		p.serial = nil
+		p.endStream(s.src.blocks[len(s.src.blocks)-1].job.streamCRC)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// A block read past the end of the stream's blocks, so it's
+		// corrupt, not truncated. Report the error from decoding it in
+		// parallel.
+		p.err = s.err
+	default:
+		p.err = err
+	}
+}
+
+// BzSerial decodes the rest of a stream with the standard library's decoder.
+type bzSerial struct {
+	src *bzRest
+	zr  io.Reader
+	buf []byte
+	// Err is the error from decoding the first block in parallel.
+	err error
+	// Pos is the source position while the current block was being output.
+	// Start is the index in src.blocks where the next block starts, and last
+	// is set if the current block is the last in the stream.
+	pos   int
+	start int
+	last  bool
+}
+
+// NewBzSerial returns a bzSerial decoding the stream from the block "j" on.
+func newBzSerial(p *bzParallel, j *bzJob) *bzSerial {
+	src := &bzRest{p: p, stream: j.stream}
+	src.w.b = []byte{'B', 'Z', 'h', j.level}
+	src.bits = 32
+	src.add(j)
+	return &bzSerial{
+		src: src,
+		zr:  bzip2.NewReader(src),
+		buf: make([]byte, 32*1024),
+		err: j.err,
+		pos: -1,
+	}
+}
+
+// BzRest is a single-stream bzip2 header followed by blocks of a stream, as
+// found by the splitter. Blocks are pulled from the parallel reader as needed,
+// so the decoder may still be working on them; only the segments are used.
+// There's no end-of-stream marker: the source returns [io.EOF] after the last
+// block.
+type bzRest struct {
+	p      *bzParallel
+	stream int
+	w      bitWriter
+	// Off is the number of bytes of w.b consumed, and pos is the total number
+	// of bytes consumed. Bits is the number of bits written to w.
+	off, pos int
+	bits     int
+	// Blocks has every block added, with its bit offset.
+	blocks []bzBlock
+	// Done is set once the last block of the stream has been added.
+	done bool
+	err  error
+}
+
+// BzBlock is a block added to a bzRest.
+type bzBlock struct {
+	off int
+	job *bzJob
+}
+
+var _ io.ByteReader = (*bzRest)(nil)
+
+// Add appends the block "j".
+func (r *bzRest) add(j *bzJob) {
+	r.blocks = append(r.blocks, bzBlock{off: r.bits, job: j})
+	r.w.copyBits(j.seg)
+	r.bits += j.seg.e - j.seg.s
+	r.done = j.end
+}
+
+// ReadByte implements [io.ByteReader].
+//
+// The decoder uses this instead of Read, so that the source isn't read ahead.
+func (r *bzRest) ReadByte() (byte, error) {
+	for r.off == len(r.w.b) {
+		switch {
+		case r.err != nil:
+			return 0, r.err
+		case r.done && r.w.n > 0:
+			r.w.flush()
+			continue
+		case r.done:
+			return 0, io.EOF
+		}
+		r.w.b, r.off = r.w.b[:0], 0
+		j, ok := r.p.next()
+		switch {
+		case !ok:
+			r.err = errClosed
+		case j.seg.b == nil:
+			r.err = j.err
+		case j.stream != r.stream:
+			// Shouldn't happen; the splitter always ends a stream with its
+			// last block.
+			r.p.peek = j
+			r.err = bzip2.StructuralError("missing end of stream")
+		default:
+			r.add(j)
+		}
+	}
+	b := r.w.b[r.off]
+	r.off++
+	r.pos++
+	return b, nil
+}
+
+// Read implements [io.Reader].
+func (r *bzRest) Read(b []byte) (int, error) {
+	for i := range b {
+		c, err := r.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		b[i] = c
+	}
+	return len(b), nil
+}
+
+// Close implements [io.Closer].
+//
+// The goroutine reading the source exits once its current Read returns.
+func (p *bzParallel) Close() error {
+	p.closer.Do(func() {
+		close(p.quit)
+		p.cur = nil
+		p.err = errClosed
+	})
+	return nil
+}
+
+// BzDecode decodes the block made of the segments "segs" by reframing it as a
+// single-block stream with the block checksum "crc".
+func bzDecode(level byte, crc uint32, segs ...bzSeg) ([]byte, error) {
+	w := bitWriter{b: []byte{'B', 'Z', 'h', level}}
+	for _, s := range segs {
+		w.copyBits(s)
+	}
+	w.write(bzEOSMagic, 48)
+	// The stream checksum of a single-block stream is the block checksum.
+	w.write(uint64(crc), 32)
+	return io.ReadAll(bzip2.NewReader(bytes.NewReader(w.flush())))
+}
+
+// BitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	b []byte
+	// Acc holds the "n" pending bits, right aligned. There are fewer than 8
+	// pending bits between calls.
+	acc uint64
+	n   uint
+}
+
+// Write appends the low "n" bits of "v". "N" must be at most 56.
+func (w *bitWriter) write(v uint64, n uint) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		w.b = append(w.b, byte(w.acc>>w.n))
+	}
+	w.acc &= 1<<w.n - 1
+}
+
+// CopyBits appends the bits of the segment.
+func (w *bitWriter) copyBits(s bzSeg) {
+	i := s.s
+	for ; i < s.e && i%8 != 0; i++ {
+		w.write(uint64(s.b[i/8]>>(7-i%8)&1), 1)
+	}
+	for ; i+8 <= s.e; i += 8 {
+		w.write(uint64(s.b[i/8]), 8)
+	}
+	for ; i < s.e; i++ {
+		w.write(uint64(s.b[i/8]>>(7-i%8)&1), 1)
+	}
+}
+
+// Flush pads the pending bits, if any, to a byte and returns the slice.
+func (w *bitWriter) flush() []byte {
+	if w.n > 0 {
+		w.b = append(w.b, byte(w.acc<<(8-w.n)))
+		w.acc, w.n = 0, 0
+	}
+	return w.b
+}
@@ -0,0 +1,229 @@
+package zreader
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestParallelBzip2(t *testing.T) {
+	blocks, err := os.ReadFile("testdata/blocks.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func(t *testing.T, in []byte) ([]byte, error) {
+		t.Helper()
+		return io.ReadAll(bzip2.NewReader(bytes.NewReader(in)))
+	}
+	parallel := func(t *testing.T, in []byte) ([]byte, error) {
+		t.Helper()
+		rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{ParallelBzip2: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindBzip2; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		return io.ReadAll(rc)
+	}
+
+	tt := []struct {
+		Name string
+		In   []byte
+	}{
+		{Name: "Single", In: compress(t, KindBzip2)},
+		{Name: "Blocks", In: blocks},
+		{Name: "Multistream", In: bytes.Join([][]byte{blocks, compress(t, KindBzip2), blocks}, nil)},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			want, err := serial(t, tc.In)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parallel(t, tc.In)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %d bytes, want %d identical bytes", len(got), len(want))
+			}
+		})
+	}
+	t.Run("Corrupt", func(t *testing.T) {
+		in := bytes.Clone(blocks)
+		in[len(in)/2] ^= 0xFF
+		if _, err := serial(t, in); err == nil {
+			t.Fatal("serial decoder: unexpected success")
+		}
+		_, err := parallel(t, in)
+		t.Logf("error: %v", err)
+		if err == nil {
+			t.Error("unexpected success")
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := parallel(t, blocks[:len(blocks)-8])
+		t.Logf("error: %v", err)
+		if err == nil {
+			t.Error("unexpected success")
+		}
+	})
+	t.Run("Rejoin", func(t *testing.T) {
+		// Split the only block of the payload at an arbitrary bit, as if a
+		// spurious magic number had been found, and check that the halves
+		// decode when joined.
+		in := compress(t, KindBzip2)
+		all := bzSeg{b: in, s: 0, e: len(in) * 8}
+		end := -1
+		for i := 32 + 48; i+48 <= all.e; i++ {
+			if all.bits(i, 48) == bzEOSMagic {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			t.Fatal("no end-of-stream magic found")
+		}
+		crc := uint32(all.bits(32+48, 32))
+		mid := 32 + (end-32)/2 + 3
+		got, err := bzDecode(in[3], crc, bzSeg{b: in, s: 32, e: mid}, bzSeg{b: in, s: mid, e: end})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Spurious", func(t *testing.T) {
+		want, err := serial(t, blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := func(t *testing.T, jobs []*bzJob) ([]byte, error) {
+			p := &bzParallel{
+				order: make(chan *bzJob, len(jobs)),
+				quit:  make(chan struct{}),
+			}
+			for _, j := range jobs {
+				p.order <- j
+			}
+			close(p.order)
+			defer p.Close()
+			return io.ReadAll(p)
+		}
+		// Two false splits in the first block, and one in the last. A second,
+		// intact stream checks that parallel decoding picks up again.
+		t.Run("Decode", func(t *testing.T) {
+			jobs := bzJobs(t, blocks, 0, 1, 3)
+			for _, j := range bzJobs(t, blocks) {
+				j.stream = 1
+				jobs = append(jobs, j)
+			}
+			got, err := read(t, jobs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := bytes.Repeat(want, 2); !bytes.Equal(got, want) {
+				t.Errorf("got %d bytes, want %d identical bytes", len(got), len(want))
+			}
+		})
+		t.Run("Checksum", func(t *testing.T) {
+			jobs := bzJobs(t, blocks, 0, 1, 3)
+			jobs[len(jobs)-1].streamCRC ^= 1
+			_, err := read(t, jobs)
+			t.Logf("error: %v", err)
+			if want := bzip2.StructuralError("file checksum mismatch"); !errors.Is(err, want) {
+				t.Errorf("got: %v, want: %v", err, want)
+			}
+		})
+	})
+}
+
+// BzJobs splits the single bzip2 stream "in" into decoded jobs, as the
+// splitter would. The blocks with the indexes in "split" are also split at
+// the offsets a third and two thirds of the way through (for the first
+// index) or halfway through (for the rest), as if a spurious magic number had
+// been found there.
+func bzJobs(t *testing.T, in []byte, split ...int) []*bzJob {
+	t.Helper()
+	all := bzSeg{b: in, s: 0, e: len(in) * 8}
+	var starts []int
+	end := -1
+	for i := 32; i+48 <= all.e; i++ {
+		m := all.bits(i, 48)
+		if m == bzBlockMagic {
+			starts = append(starts, i)
+		}
+		if m == bzEOSMagic {
+			end = i
+			break
+		}
+	}
+	if end < 0 || len(starts) == 0 {
+		t.Fatal("no blocks found")
+	}
+	bounds := append(starts, end)
+	var cuts []int
+	for n, i := range split {
+		s, e := bounds[i], bounds[i+1]
+		if n == 0 {
+			cuts = append(cuts, s+(e-s)/3, s+2*(e-s)/3)
+		} else {
+			cuts = append(cuts, s+(e-s)/2)
+		}
+	}
+	cuts = append(cuts, bounds...)
+	sort.Ints(cuts)
+
+	jobs := make([]*bzJob, len(cuts)-1)
+	for i := range jobs {
+		seg := bzSeg{b: in, s: cuts[i], e: cuts[i+1]}
+		j := &bzJob{
+			seg:   seg,
+			level: in[3],
+			crc:   uint32(seg.bits(48, 32)),
+			done:  make(chan struct{}),
+		}
+		j.out, j.err = bzDecode(j.level, j.crc, j.seg)
+		close(j.done)
+		jobs[i] = j
+	}
+	last := jobs[len(jobs)-1]
+	last.end, last.streamCRC = true, uint32(all.bits(end+48, 32))
+	return jobs
+}
+
+func BenchmarkBzip2(b *testing.B) {
+	blocks, err := os.ReadFile("testdata/blocks.bz2")
+	if err != nil {
+		b.Fatal(err)
+	}
+	in := bytes.Repeat(blocks, 8)
+	for _, bc := range []struct {
+		Name string
+		Opts ReaderOpts
+	}{
+		{Name: "Serial"},
+		{Name: "Parallel", Opts: ReaderOpts{ParallelBzip2: true}},
+	} {
+		b.Run(bc.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				rc, _, err := DetectOpts(bytes.NewReader(in), bc.Opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, rc); err != nil {
+					b.Fatal(err)
+				}
+				rc.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package zreader
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// Control is a representative small file: a dpkg status stanza, repeated.
+var control = bytes.Repeat([]byte(`Package: libc6
+Status: install ok installed
+Priority: optional
+Section: libs
+Installed-Size: 12985
+Maintainer: GNU Libc Maintainers <debian-glibc@lists.debian.org>
+Architecture: amd64
+Multi-Arch: same
+Source: glibc
+Version: 2.36-9+deb12u4
+Depends: libgcc-s1
+Description: GNU C Library: Shared libraries
+
+`), 32)
+
+// LayerFixture returns a representative large layer: a tar of files that are
+// partly random, so it compresses about as well as typical binaries.
+func layerFixture(b testing.TB) []byte {
+	b.Helper()
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < 64; i++ {
+		f := make([]byte, 256*1024)
+		rng.Read(f[:len(f)/4])
+		for j := len(f) / 4; j < len(f); j += len(control) {
+			copy(f[j:], control)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: strconv.Itoa(i), Size: int64(len(f)), Mode: 0o644}); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := tw.Write(f); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkCodec compares the codecs this package uses against the standard
+// library's. The standard library variants are only run when built with the
+// "zreader_stdlib" build tag:
+//
+//	go test -tags zreader_stdlib -run XXX -bench Codec ./internal/zreader
+func BenchmarkCodec(b *testing.B) {
+	fixtures := []struct {
+		Name string
+		In   []byte
+	}{
+		{Name: "Control", In: control},
+		{Name: "Layer", In: layerFixture(b)},
+	}
+	impls := []struct {
+		Name string
+		New  func(io.Reader, Compression) (io.ReadCloser, error)
+	}{
+		{Name: "Klauspost", New: ReaderWith},
+		{Name: "Stdlib", New: stdlibReader},
+	}
+	for _, c := range []Compression{KindGzip, KindZlib, KindDeflate} {
+		c := c
+		b.Run(c.String(), func(b *testing.B) {
+			for _, f := range fixtures {
+				f := f
+				var buf bytes.Buffer
+				w, err := Writer(&buf, c)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := w.Write(f.In); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					b.Fatal(err)
+				}
+				in := buf.Bytes()
+				b.Run(f.Name, func(b *testing.B) {
+					for _, impl := range impls {
+						impl := impl
+						b.Run(impl.Name, func(b *testing.B) {
+							if impl.New == nil {
+								b.Skip("built without the zreader_stdlib tag")
+							}
+							b.ReportAllocs()
+							b.SetBytes(int64(len(f.In)))
+							for i := 0; i < b.N; i++ {
+								rc, err := impl.New(bytes.NewReader(in), c)
+								if err != nil {
+									b.Fatal(err)
+								}
+								if _, err := io.Copy(io.Discard, rc); err != nil {
+									b.Fatal(err)
+								}
+								rc.Close()
+							}
+						})
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package zreader
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// The Compression constants are contiguous and end with [KindNone], so a value
+// is valid if it's in the range [0, KindNone] or was returned by
+// [RegisterDetector].
+func (c Compression) valid() bool {
+	return c >= 0 && c <= KindNone || c.Registered()
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (c Compression) MarshalText() ([]byte, error) {
+	if !c.valid() {
+		return nil, fmt.Errorf("zreader: invalid Compression value: %d", int(c))
+	}
+	return []byte(c.name()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+//
+// Only the exact names reported by [Compression.String] and the names of
+// registered detectors are accepted.
+func (c *Compression) UnmarshalText(b []byte) error {
+	for k := Compression(0); k.valid(); k++ {
+		if string(b) == k.name() {
+			*c = k
+			return nil
+		}
+	}
+	return fmt.Errorf("zreader: unknown Compression name: %q", string(b))
+}
+
+// ErrUnknownCompression is returned by [ParseCompression] for names that are
+// not recognized.
+var ErrUnknownCompression = errors.New("zreader: unknown compression")
+
+// Aliases are additional names accepted by [ParseCompression].
+var aliases = map[string]Compression{
+	"":    KindNone,
+	"gz":  KindGzip,
+	"zst": KindZstd,
+	"bz2": KindBzip2,
+	"br":  KindBrotli,
+	"sz":  KindSnappy,
+}
+
+// ParseCompression returns the Compression named by "s".
+//
+// The names reported by [Compression.String] and the names of registered
+// detectors are accepted, along with some
+// common aliases (such as "gz" for gzip). The empty string is an alias for
+// [KindNone]. Matching is case-insensitive.
+func ParseCompression(s string) (Compression, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := aliases[s]; ok {
+		return c, nil
+	}
+	var valid []string
+	for k := Compression(0); k.valid(); k++ {
+		n := k.name()
+		if s == n {
+			return k, nil
+		}
+		valid = append(valid, n)
+	}
+	return KindNone, fmt.Errorf("%w: %q (valid values: %s)", ErrUnknownCompression, s, strings.Join(valid, ", "))
+}
+
+// Extension returns the conventional file extension for the Compression,
+// including the leading dot.
+//
+// The empty string is returned for [KindNone], [KindDeflate] (which has no
+// conventional extension), and invalid values.
+func (c Compression) Extension() string {
+	switch c {
+	case KindGzip:
+		return ".gz"
+	case KindZstd:
+		return ".zst"
+	case KindBzip2:
+		return ".bz2"
+	case KindZlib:
+		return ".zz"
+	case KindXz:
+		return ".xz"
+	case KindLz4:
+		return ".lz4"
+	case KindBrotli:
+		return ".br"
+	case KindTar:
+		return ".tar"
+	case KindSnappy:
+		return ".sz"
+	case KindLzma:
+		return ".lzma"
+	}
+	return ""
+}
+
+// CompressionFromName returns the Compression indicated by the extension of
+// the file name "name", as reported by [Compression.Extension], without
+// examining any contents. Matching is case-insensitive, and only the last
+// extension is considered, so "layer.tar.gz" reports [KindGzip]. The ".tgz"
+// shorthand is also recognized.
+//
+// [KindNone] is returned for names with no recognized extension, and for
+// ".tar": a tar is not compressed, and [KindTar] is only reported by content
+// detection.
+func CompressionFromName(name string) Compression {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" || ext == "." {
+		return KindNone
+	}
+	if ext == ".tgz" {
+		return KindGzip
+	}
+	for c := Compression(0); c < KindNone; c++ {
+		if c != KindTar && c.Extension() == ext {
+			return c
+		}
+	}
+	return KindNone
+}
+
+// MediaType returns the media type for a stream compressed with the
+// Compression.
+//
+// The empty string is returned for [KindNone], [KindDeflate] (which has no
+// registered media type), and invalid values.
+func (c Compression) MediaType() string {
+	switch c {
+	case KindGzip:
+		return "application/gzip"
+	case KindZstd:
+		return "application/zstd"
+	case KindBzip2:
+		return "application/x-bzip2"
+	case KindZlib:
+		return "application/zlib"
+	case KindXz:
+		return "application/x-xz"
+	case KindLz4:
+		return "application/x-lz4"
+	case KindBrotli:
+		return "application/x-brotli"
+	case KindTar:
+		return "application/x-tar"
+	case KindSnappy:
+		return "application/x-snappy-framed"
+	case KindLzma:
+		return "application/x-lzma"
+	}
+	return ""
+}
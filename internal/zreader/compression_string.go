@@ -1,4 +1,4 @@
-// Code generated by "stringer -type Compression"; DO NOT EDIT.
+// Code generated by "stringer -type Compression -linecomment"; DO NOT EDIT.
 
 package zreader
 
@@ -12,16 +12,24 @@ func _() {
 	_ = x[KindZstd-1]
 	_ = x[KindBzip2-2]
 	_ = x[KindZlib-3]
-	_ = x[KindNone-4]
+	_ = x[KindXz-4]
+	_ = x[KindLz4-5]
+	_ = x[KindBrotli-6]
+	_ = x[KindTar-7]
+	_ = x[KindSnappy-8]
+	_ = x[KindLzma-9]
+	_ = x[KindDeflate-10]
+	_ = x[KindNone-11]
 }
 
-const _Compression_name = "KindGzipKindZstdKindBzip2KindZlibKindNone"
+const _Compression_name = "gzipzstdbzip2zlibxzlz4brotlitarsnappylzmadeflatenone"
 
-var _Compression_index = [...]uint8{0, 8, 16, 25, 33, 41}
+var _Compression_index = [...]uint8{0, 4, 8, 13, 17, 19, 22, 28, 31, 37, 41, 48, 52}
 
 func (i Compression) String() string {
-	if i < 0 || i >= Compression(len(_Compression_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_Compression_index)-1 {
 		return "Compression(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _Compression_name[_Compression_index[i]:_Compression_index[i+1]]
+	return _Compression_name[_Compression_index[idx]:_Compression_index[idx+1]]
 }
@@ -0,0 +1,179 @@
+package zreader
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompressionText(t *testing.T) {
+	type doc struct {
+		Compression Compression `json:"compression"`
+	}
+	tt := []struct {
+		In   Compression
+		Want string
+	}{
+		{In: KindGzip, Want: `{"compression":"gzip"}`},
+		{In: KindZstd, Want: `{"compression":"zstd"}`},
+		{In: KindBzip2, Want: `{"compression":"bzip2"}`},
+		{In: KindZlib, Want: `{"compression":"zlib"}`},
+		{In: KindXz, Want: `{"compression":"xz"}`},
+		{In: KindLz4, Want: `{"compression":"lz4"}`},
+		{In: KindBrotli, Want: `{"compression":"brotli"}`},
+		{In: KindTar, Want: `{"compression":"tar"}`},
+		{In: KindSnappy, Want: `{"compression":"snappy"}`},
+		{In: KindLzma, Want: `{"compression":"lzma"}`},
+		{In: KindNone, Want: `{"compression":"none"}`},
+	}
+	for _, tc := range tt {
+		t.Run(tc.In.String(), func(t *testing.T) {
+			b, err := json.Marshal(doc{Compression: tc.In})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), tc.Want; got != want {
+				t.Errorf("got: %s, want: %s", got, want)
+			}
+			// Use a non-zero starting value to make sure it's overwritten.
+			d := doc{Compression: -1}
+			if err := json.Unmarshal(b, &d); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := d.Compression, tc.In; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+
+	t.Run("InvalidName", func(t *testing.T) {
+		var d doc
+		err := json.Unmarshal([]byte(`{"compression":"lzip"}`), &d)
+		t.Log(err)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("InvalidValue", func(t *testing.T) {
+		_, err := json.Marshal(doc{Compression: Compression(100)})
+		t.Log(err)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestParseCompression(t *testing.T) {
+	tt := []struct {
+		In   string
+		Want Compression
+	}{
+		{In: "gzip", Want: KindGzip},
+		{In: "GZIP", Want: KindGzip},
+		{In: "gz", Want: KindGzip},
+		{In: "zstd", Want: KindZstd},
+		{In: "zst", Want: KindZstd},
+		{In: "bzip2", Want: KindBzip2},
+		{In: "bz2", Want: KindBzip2},
+		{In: "zlib", Want: KindZlib},
+		{In: "xz", Want: KindXz},
+		{In: "lz4", Want: KindLz4},
+		{In: "brotli", Want: KindBrotli},
+		{In: "br", Want: KindBrotli},
+		{In: "tar", Want: KindTar},
+		{In: "snappy", Want: KindSnappy},
+		{In: "sz", Want: KindSnappy},
+		{In: "lzma", Want: KindLzma},
+		{In: "none", Want: KindNone},
+		{In: "None", Want: KindNone},
+		{In: "", Want: KindNone},
+	}
+	for _, tc := range tt {
+		t.Run(tc.In, func(t *testing.T) {
+			got, err := ParseCompression(tc.In)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tc.Want; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := ParseCompression("lzip")
+		t.Log(err)
+		if !errors.Is(err, ErrUnknownCompression) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !strings.Contains(err.Error(), "gzip") {
+			t.Error("error does not list valid values")
+		}
+	})
+}
+
+func TestExtensionMediaType(t *testing.T) {
+	tt := []struct {
+		In        Compression
+		Extension string
+		MediaType string
+	}{
+		{In: KindGzip, Extension: ".gz", MediaType: "application/gzip"},
+		{In: KindZstd, Extension: ".zst", MediaType: "application/zstd"},
+		{In: KindBzip2, Extension: ".bz2", MediaType: "application/x-bzip2"},
+		{In: KindZlib, Extension: ".zz", MediaType: "application/zlib"},
+		{In: KindXz, Extension: ".xz", MediaType: "application/x-xz"},
+		{In: KindLz4, Extension: ".lz4", MediaType: "application/x-lz4"},
+		{In: KindBrotli, Extension: ".br", MediaType: "application/x-brotli"},
+		{In: KindTar, Extension: ".tar", MediaType: "application/x-tar"},
+		{In: KindSnappy, Extension: ".sz", MediaType: "application/x-snappy-framed"},
+		{In: KindLzma, Extension: ".lzma", MediaType: "application/x-lzma"},
+		{In: KindNone, Extension: "", MediaType: ""},
+		{In: Compression(-1), Extension: "", MediaType: ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.In.String(), func(t *testing.T) {
+			if got, want := tc.In.Extension(), tc.Extension; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := tc.In.MediaType(), tc.MediaType; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCompressionFromName(t *testing.T) {
+	tt := []struct {
+		In   string
+		Want Compression
+	}{
+		{In: "layer.gz", Want: KindGzip},
+		{In: "layer.zst", Want: KindZstd},
+		{In: "layer.bz2", Want: KindBzip2},
+		{In: "layer.zz", Want: KindZlib},
+		{In: "layer.xz", Want: KindXz},
+		{In: "layer.lz4", Want: KindLz4},
+		{In: "layer.br", Want: KindBrotli},
+		{In: "layer.sz", Want: KindSnappy},
+		{In: "layer.lzma", Want: KindLzma},
+		{In: "layer.tar", Want: KindNone},
+		{In: "layer.tar.gz", Want: KindGzip},
+		{In: "layer.tgz", Want: KindGzip},
+		{In: "LAYER.TAR.GZ", Want: KindGzip},
+		{In: "layer.Zst", Want: KindZstd},
+		{In: "dir.gz/layer", Want: KindNone},
+		{In: "some/dir/layer.bz2", Want: KindBzip2},
+		{In: "layer", Want: KindNone},
+		{In: "layer.", Want: KindNone},
+		{In: "layer.txt", Want: KindNone},
+		{In: "", Want: KindNone},
+	}
+	for _, tc := range tt {
+		t.Run(tc.In, func(t *testing.T) {
+			if got, want := CompressionFromName(tc.In), tc.Want; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package zreader
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// CountReader counts the bytes read from the underlying Reader.
+//
+// Some decoders read from their source in a separate goroutine, so the count
+// is updated atomically.
+type countReader struct {
+	io.Reader
+	n atomic.Int64
+}
+
+// Read implements [io.Reader].
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// CountingReadCloser is an [io.ReadCloser] that tracks the number of bytes
+// read from the compressed source and the number of decompressed bytes
+// returned.
+//
+// The methods reporting counts are safe to call concurrently with Read.
+type CountingReadCloser struct {
+	rc  Decoder
+	in  *countReader
+	out atomic.Int64
+}
+
+// Read implements [io.Reader].
+func (c *CountingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+// Close implements [io.Closer].
+func (c *CountingReadCloser) Close() error {
+	return c.rc.Close()
+}
+
+// Underlying implements [Decoder].
+func (c *CountingReadCloser) Underlying() any {
+	return c.rc.Underlying()
+}
+
+// Empty implements [Decoder].
+func (c *CountingReadCloser) Empty() bool {
+	return c.rc.Empty()
+}
+
+// GzipFlags implements [Decoder].
+func (c *CountingReadCloser) gzipFlags() (GzipFlag, bool) {
+	return c.rc.gzipFlags()
+}
+
+// CompressedBytes reports the number of bytes read from the source Reader.
+//
+// This includes bytes that have been buffered but not yet decompressed, so it
+// may be larger than the amount of input needed for the decompressed bytes
+// returned so far.
+func (c *CountingReadCloser) CompressedBytes() int64 {
+	return c.in.n.Load()
+}
+
+// DecompressedBytes reports the number of bytes returned from Read.
+func (c *CountingReadCloser) DecompressedBytes() int64 {
+	return c.out.Load()
+}
@@ -0,0 +1,47 @@
+package zreader
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDetectCounting(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			in := compress(t, c)
+			rc, kind, err := DetectCounting(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			// Detection reads at least the header, and the buffering reads
+			// more than that if available.
+			want := int64(peekSz())
+			if l := int64(len(in)); l < want {
+				want = l
+			}
+			if got := rc.CompressedBytes(); got < want {
+				t.Errorf("got: %d, want: >=%d", got, want)
+			}
+			if got, want := rc.DecompressedBytes(), int64(0); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := rc.CompressedBytes(), int64(len(in)); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := rc.DecompressedBytes(), int64(len(payload)); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
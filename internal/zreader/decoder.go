@@ -0,0 +1,383 @@
+package zreader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// Decoder is implemented by every [io.ReadCloser] returned by this package.
+//
+// Close may be called more than once; only the first call does anything, and
+// later calls return nil. Reading after Close returns an error, as the
+// Decoder's buffer may have been reused.
+//
+// The Decoders returned by [Detect] and related functions also implement
+// [io.ByteReader].
+type Decoder interface {
+	io.ReadCloser
+	// Underlying returns the concrete decoder for the detected scheme, for
+	// callers that need codec-specific features.
+	//
+	// The returned values are:
+	//
+	//   - gzip: *gzip.Reader (github.com/klauspost/compress/gzip)
+	//   - zstd: *zstd.Decoder (github.com/klauspost/compress/zstd)
+	//   - bzip2: the io.Reader returned by compress/bzip2.NewReader
+	//   - zlib: the io.ReadCloser returned by
+	//     github.com/klauspost/compress/zlib.NewReader
+	//   - xz: *xz.Reader (github.com/ulikunitz/xz)
+	//   - lz4: *lz4.Reader (github.com/pierrec/lz4/v4)
+	//   - brotli: *brotli.Reader (github.com/andybalholm/brotli)
+	//
+	// For streams that are passed through without decoding, nil is returned.
+	// Nil is also returned while ReadByte has buffered bytes that haven't been
+	// read yet, as the concrete decoder is past them.
+	//
+	// The returned value must not be used after the Decoder is closed. In
+	// particular, zstd decoders are pooled and reused.
+	Underlying() any
+	// Empty reports whether the stream has no decompressed content. The
+	// reported Compression distinguishes a valid but empty compressed stream
+	// from an empty source.
+	//
+	// This reads ahead by one byte, which is returned by the next Read.
+	Empty() bool
+
+	// GzipFlags backs the package-level GzipFlags. Wrappers embedding a
+	// Decoder get it promoted from the Decoder they wrap.
+	gzipFlags() (GzipFlag, bool)
+}
+
+// Decoder (unexported) is the concrete type constructed for every scheme.
+type decoder struct {
+	r io.Reader
+	// C is called on Close, if not nil.
+	c     io.Closer
+	under any
+	// Checked and empty record the result of Empty.
+	checked, empty bool
+	closer         sync.Once
+	closed         bool
+	// Buf is returned to the pool on Close, if not nil.
+	buf *bufio.Reader
+	// Ahead is the buffer added by ReadByte, if it's been needed. It's also
+	// returned to the pool on Close.
+	ahead *bufio.Reader
+	// Gzflags is the FLG byte of a gzip stream's first member, if gzok is
+	// set.
+	gzflags GzipFlag
+	gzok    bool
+}
+
+var _ Decoder = (*decoder)(nil)
+
+// PassThrough returns a decoder with no underlying decoder that reads from
+// "r".
+func passThrough(r io.Reader) *decoder {
+	return &decoder{r: r}
+}
+
+// EmptyReader is an [io.Reader] that is always at EOF. It's used in place of a
+// nil Reader.
+type emptyReader struct{}
+
+// Read implements [io.Reader].
+func (emptyReader) Read(_ []byte) (int, error) { return 0, io.EOF }
+
+// Read implements [io.Reader].
+func (d *decoder) Read(p []byte) (int, error) {
+	if d.closed {
+		return 0, errClosed
+	}
+	return d.r.Read(p)
+}
+
+// ReadByte implements [io.ByteReader].
+//
+// The Reader's ReadByte method is used if it has one, such as for streams
+// passed through from a [bufio.Reader]. Otherwise, the Reader is buffered on
+// the first call. A Reader that keeps returning no bytes and no error is
+// reported as [io.ErrNoProgress].
+func (d *decoder) ReadByte() (byte, error) {
+	if d.closed {
+		return 0, errClosed
+	}
+	br, ok := d.r.(io.ByteReader)
+	if !ok {
+		d.ahead = getBufio(d.r, defaultBufferSize)
+		d.r, br = d.ahead, d.ahead
+	}
+	return br.ReadByte()
+}
+
+// WriteTo implements [io.WriterTo].
+//
+// The Reader's WriteTo method is used if it has one, otherwise the bytes are
+// copied as with [io.Copy].
+func (d *decoder) WriteTo(w io.Writer) (int64, error) {
+	if d.closed {
+		return 0, errClosed
+	}
+	if wt, ok := d.r.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	// Hide any methods but Read, so that io.Copy doesn't recurse.
+	return io.Copy(w, struct{ io.Reader }{d.r})
+}
+
+// Close implements [io.Closer].
+func (d *decoder) Close() error {
+	var err error
+	d.closer.Do(func() {
+		d.closed = true
+		if d.c != nil {
+			err = d.c.Close()
+		}
+		if d.buf != nil {
+			putBufio(d.buf)
+			d.buf = nil
+		}
+		if d.ahead != nil {
+			putBufio(d.ahead)
+			d.ahead = nil
+		}
+	})
+	return err
+}
+
+// Underlying implements [Decoder].
+func (d *decoder) Underlying() any {
+	if d.ahead != nil && d.ahead.Buffered() > 0 {
+		return nil
+	}
+	return d.under
+}
+
+// Empty implements [Decoder].
+func (d *decoder) Empty() bool {
+	if d.closed {
+		return false
+	}
+	if !d.checked {
+		d.checked = true
+		d.r, d.empty = peekEmpty(d.r)
+	}
+	return d.empty
+}
+
+// PeekEmpty reads one byte from "r" to determine if it's empty, returning a
+// Reader equivalent to "r" before the read.
+//
+// Errors other than io.EOF are not reported as empty, and are left to be
+// returned by the next Read.
+func peekEmpty(r io.Reader) (io.Reader, bool) {
+	// A buffer added by ReadByte can be peeked in place.
+	if br, ok := r.(*bufio.Reader); ok {
+		_, err := br.Peek(1)
+		return r, errors.Is(err, io.EOF)
+	}
+	var b [1]byte
+	n, err := io.ReadFull(r, b[:])
+	if n == 0 {
+		return r, errors.Is(err, io.EOF)
+	}
+	return io.MultiReader(bytes.NewReader(b[:n]), r), false
+}
+
+// GzipHeader reports the gzip header of a stream returned by one of the
+// functions in this package, if the detected scheme is [KindGzip].
+//
+// The header is parsed when the decoder is constructed, so it's available
+// before the first Read. When reading multiple concatenated gzip members, the
+// header is that of the current member.
+func GzipHeader(rc io.Reader) (gzip.Header, bool) {
+	d, ok := rc.(Decoder)
+	if !ok {
+		return gzip.Header{}, false
+	}
+	z, ok := d.Underlying().(*gzip.Reader)
+	if !ok {
+		return gzip.Header{}, false
+	}
+	return z.Header, true
+}
+
+// GzipFlag is the FLG byte of a gzip member header, as described in [RFC 1952]
+// section 2.3.1.
+//
+// [RFC 1952]: https://www.rfc-editor.org/rfc/rfc1952#section-2.3.1
+type GzipFlag uint8
+
+// The defined gzip header flags.
+const (
+	// GzipFText indicates the content is probably text. It's a hint, and
+	// changes nothing about how the stream is decoded.
+	GzipFText GzipFlag = 1 << iota
+	// GzipFHCRC indicates the header is followed by a CRC-16 of the header.
+	GzipFHCRC
+	// GzipFExtra indicates the header has an "extra" field.
+	GzipFExtra
+	// GzipFName indicates the header has an original file name.
+	GzipFName
+	// GzipFComment indicates the header has a comment.
+	GzipFComment
+)
+
+// GzipFlags reports the header flags of a stream returned by one of the
+// functions in this package, if the detected scheme is [KindGzip].
+//
+// The flags are those of the first member, read from the bytes examined
+// during detection. They're informational only. Streams constructed without
+// detection, such as with [ReaderWith], report false.
+func GzipFlags(rc io.Reader) (GzipFlag, bool) {
+	d, ok := rc.(Decoder)
+	if !ok {
+		return 0, false
+	}
+	return d.gzipFlags()
+}
+
+// GzipFlags implements [Decoder].
+func (d *decoder) gzipFlags() (GzipFlag, bool) {
+	return d.gzflags, d.gzok
+}
+
+// ParseGzipFlags returns the FLG byte from the gzip header at the start of
+// "b", if there is one.
+func parseGzipFlags(b []byte) (GzipFlag, bool) {
+	if len(b) < 4 || b[0] != 0x1f || b[1] != 0x8b {
+		return 0, false
+	}
+	return GzipFlag(b[3]), true
+}
+
+// Verify reads the rest of the stream returned by one of the functions in
+// this package, reporting any error encountered.
+//
+// Decoders check the integrity of a stream once they reach its end, so a
+// caller that stops reading early never learns if the stream was truncated or
+// corrupt. For gzip, this is the trailing CRC-32 and length of every member;
+// for zlib, the trailing Adler-32. Other schemes are checked as far as their
+// formats and decoders allow.
+//
+// The decompressed bytes are discarded. Any limits configured via
+// [ReaderOpts] still apply.
+func Verify(rc io.Reader) error {
+	_, err := io.Copy(io.Discard, rc)
+	return err
+}
+
+// ErrTruncated is returned by the Readers constructed by [Detect] and related
+// functions when the compressed stream ends early. The decoder's error is
+// wrapped along with it.
+//
+// A truncated stream may succeed if fetched again, where a corrupt one won't.
+var ErrTruncated = errors.New("zreader: stream truncated")
+
+// ErrCorrupt is returned by the Readers constructed by [Detect] and related
+// functions when the decoder reports that the compressed stream is
+// malformed, for example a bad checksum or invalid block. The decoder's error
+// is wrapped along with it.
+var ErrCorrupt = errors.New("zreader: stream corrupt")
+
+// SrcReader records whether the source for a decoder has returned an error.
+//
+// Some decoders read from the source in other goroutines, so the flag is
+// atomic.
+type srcReader struct {
+	io.Reader
+	failed atomic.Bool
+}
+
+// Read implements [io.Reader].
+func (s *srcReader) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.failed.Store(true)
+	}
+	return n, err
+}
+
+// WriteTo implements [io.WriterTo], so that a [bufio.Reader] reading from
+// the source can still use the source's WriteTo method. Errors returned this
+// way are not recorded, as they may come from "w".
+func (s *srcReader) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := s.Reader.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, struct{ io.Reader }{s})
+}
+
+// ClassifyReader wraps the errors returned from a decoder in [ErrTruncated]
+// or [ErrCorrupt].
+//
+// Errors are only classified as corrupt if the source hasn't failed, so that
+// an error from the source is returned as-is. If "src" is nil, the source
+// can't be observed and only truncation is reported.
+type classifyReader struct {
+	r   io.Reader
+	src *srcReader
+}
+
+// Read implements [io.Reader].
+func (c *classifyReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	return n, c.classify(err, false)
+}
+
+// WriteTo implements [io.WriterTo].
+func (c *classifyReader) WriteTo(w io.Writer) (int64, error) {
+	wt, ok := c.r.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, struct{ io.Reader }{c})
+	}
+	ew := &errWriter{w: w}
+	n, err := wt.WriteTo(ew)
+	return n, c.classify(err, ew.failed)
+}
+
+// Classify wraps "err" as needed. If "passthrough" is set, the error is
+// known to be from elsewhere and returned unchanged.
+func (c *classifyReader) classify(err error, passthrough bool) error {
+	switch {
+	case err == nil, errors.Is(err, io.EOF), passthrough:
+		return err
+	case errors.Is(err, ErrTruncated), errors.Is(err, ErrCorrupt):
+		return err
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: %w", ErrTruncated, err)
+	case c.src == nil, c.src.failed.Load():
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrCorrupt, err)
+}
+
+// ErrWriter records whether writes to the underlying Writer have failed.
+type errWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+// Write implements [io.Writer].
+func (e *errWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil {
+		e.failed = true
+	}
+	return n, err
+}
+
+// Unexpected converts io.EOF to io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// ErrDigestMismatch is returned by the [io.ReadCloser] returned by
+// [DetectVerify] when the digest of the source does not match the expected
+// value.
+var ErrDigestMismatch = errors.New("zreader: digest mismatch")
+
+// DetectVerify follows the same procedure as [Detect], but also checks that the
+// digest of the bytes of "r", as computed by "h", is "want".
+//
+// Every byte of "r" is written to "h" exactly once as it's consumed, including
+// the header examined during detection. "H" should be freshly created or
+// Reset.
+//
+// The digest is checked once the decoder reaches the end of its stream: the
+// remainder of "r" is read into "h", and the Read that would return [io.EOF]
+// returns an error wrapping [ErrDigestMismatch] instead if the digests don't
+// match. If Close is called before then, the remainder of "r" is read and
+// checked by Close. This means Close may block on "r".
+//
+// As with [Detect], an [*UnsupportedError] is returned along with a ReadCloser
+// of the original stream, which still checks the digest.
+func DetectVerify(r io.Reader, h hash.Hash, want []byte) (io.ReadCloser, Compression, error) {
+	if r == nil {
+		r = emptyReader{}
+	}
+	src := io.TeeReader(r, h)
+	d, c, err := detect(src, &ReaderOpts{})
+	if d == nil {
+		return nil, c, err
+	}
+	return &verifyReader{
+		Decoder: d,
+		src:     src,
+		h:       h,
+		want:    bytes.Clone(want),
+	}, c, err
+}
+
+// VerifyReader checks the digest of "src" once the Decoder is exhausted.
+type verifyReader struct {
+	Decoder
+	src  io.Reader
+	h    hash.Hash
+	want []byte
+	// Done is set once the digest has been checked, and err is the result.
+	done   bool
+	err    error
+	closer sync.Once
+}
+
+// Read implements [io.Reader].
+func (v *verifyReader) Read(p []byte) (int, error) {
+	if v.done {
+		return 0, v.err
+	}
+	n, err := v.Decoder.Read(p)
+	if errors.Is(err, io.EOF) {
+		v.check()
+		return n, v.err
+	}
+	return n, err
+}
+
+// Check reads the rest of the source and compares the digests, recording the
+// result. The error is io.EOF if the digests match.
+func (v *verifyReader) check() {
+	v.done = true
+	if _, err := io.Copy(io.Discard, v.src); err != nil {
+		v.err = err
+		return
+	}
+	if got := v.h.Sum(nil); !bytes.Equal(got, v.want) {
+		v.err = fmt.Errorf("%w: got %x, want %x", ErrDigestMismatch, got, v.want)
+		return
+	}
+	v.err = io.EOF
+}
+
+// Close implements [io.Closer].
+func (v *verifyReader) Close() error {
+	var err error
+	v.closer.Do(func() {
+		if !v.done {
+			v.check()
+		}
+		if !errors.Is(v.err, io.EOF) {
+			err = v.err
+		}
+		err = errors.Join(err, v.Decoder.Close())
+	})
+	return err
+}
+
+// DetectHashed follows the same procedure as [Detect], but also writes every
+// decompressed byte to "h" as it's read. Once the returned [io.ReadCloser] has
+// been read to EOF, "h" holds the digest of the decompressed contents.
+//
+// For uncompressed sources, this is the digest of the source. This includes
+// sources reported with an [*UnsupportedError], which come with a ReadCloser
+// of the original stream as with [Detect].
+func DetectHashed(r io.Reader, h hash.Hash) (io.ReadCloser, Compression, error) {
+	d, c, err := detect(r, &ReaderOpts{})
+	if d == nil {
+		return nil, c, err
+	}
+	return &hashReader{Decoder: d, h: h}, c, err
+}
+
+// HashReader writes everything read from the Decoder to a hash.
+type hashReader struct {
+	Decoder
+	h hash.Hash
+}
+
+// Read implements [io.Reader].
+func (r *hashReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	// Hash writes never return an error.
+	r.h.Write(p[:n])
+	return n, err
+}
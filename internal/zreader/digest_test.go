@@ -0,0 +1,135 @@
+package zreader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDetectVerify(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			in := compress(t, c)
+			want := sha256.Sum256(in)
+			rc, kind, err := DetectVerify(bytes.NewReader(in), sha256.New(), want[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+			if err := rc.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	t.Run("Mismatch", func(t *testing.T) {
+		in := compress(t, KindNone)
+		want := sha256.Sum256(in)
+		in[len(in)/2] ^= 0xFF
+		rc, _, err := DetectVerify(bytes.NewReader(in), sha256.New(), want[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		_, err = io.ReadAll(rc)
+		if got, want := err, ErrDigestMismatch; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Close", func(t *testing.T) {
+		in := compress(t, KindGzip)
+		want := sha256.Sum256(in)
+		in[len(in)-1] ^= 0xFF // Corrupt the trailer.
+		rc, _, err := DetectVerify(bytes.NewReader(in), sha256.New(), want[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rc.Read(make([]byte, 16)); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := rc.Close(), ErrDigestMismatch; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		in := pbzx(t)
+		want := sha256.Sum256(in)
+		rc, _, err := DetectVerify(bytes.NewReader(in), sha256.New(), want[:])
+		var uerr *UnsupportedError
+		if !errors.As(err, &uerr) {
+			t.Errorf("got: %v, want: %T", err, uerr)
+		}
+		if rc == nil {
+			t.Fatal("no ReadCloser returned with unsupported error")
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Error("contents mismatch")
+		}
+		if err := rc.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestDetectHashed(t *testing.T) {
+	want := sha256.Sum256(payload)
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			h := sha256.New()
+			rc, kind, err := DetectHashed(bytes.NewReader(compress(t, c)), h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				t.Fatal(err)
+			}
+			if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+				t.Errorf("got: %x, want: %x", got, want)
+			}
+		})
+	}
+	t.Run("Unsupported", func(t *testing.T) {
+		in := pbzx(t)
+		want := sha256.Sum256(in)
+		h := sha256.New()
+		rc, _, err := DetectHashed(bytes.NewReader(in), h)
+		var uerr *UnsupportedError
+		if !errors.As(err, &uerr) {
+			t.Errorf("got: %v, want: %T", err, uerr)
+		}
+		if rc == nil {
+			t.Fatal("no ReadCloser returned with unsupported error")
+		}
+		defer rc.Close()
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			t.Fatal(err)
+		}
+		if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("got: %x, want: %x", got, want)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FramedMagic starts every stream written by [WriteFramed]. The high bit in
+// the first byte keeps it from being mistaken for text, as with PNG.
+const framedMagic = "\x89zrf"
+
+// FramedHeaderSz is the size of the frame header: the magic and one byte for
+// the Compression.
+const framedHeaderSz = len(framedMagic) + 1
+
+// FramedIDs is the byte written to the frame header for each scheme.
+//
+// Framed streams are meant to be stored, so these must never change: the
+// Compression constants are not stable across versions of this package. New
+// schemes get the next unused ID, and IDs of removed schemes are not reused.
+var framedIDs = map[Compression]byte{
+	KindNone:    0,
+	KindGzip:    1,
+	KindZstd:    2,
+	KindBzip2:   3,
+	KindZlib:    4,
+	KindXz:      5,
+	KindLz4:     6,
+	KindBrotli:  7,
+	KindTar:     8,
+	KindSnappy:  9,
+	KindLzma:    10,
+	KindDeflate: 11,
+}
+
+// FramedKind returns the scheme with the frame header ID "id".
+func framedKind(id byte) (Compression, bool) {
+	for c, v := range framedIDs {
+		if v == id {
+			return c, true
+		}
+	}
+	return KindNone, false
+}
+
+// ErrNotFramed is returned by [ReadFramed] when the stream does not start
+// with a frame header written by [WriteFramed].
+var ErrNotFramed = errors.New("zreader: not a framed stream")
+
+// WriteFramed is like [Writer], but the compressed stream is preceded by a
+// small header recording "c", so that [ReadFramed] can read it back without
+// detection. This is meant for data written and read by the same program,
+// such as cache entries, where a detector's false positive or a scheme with
+// no magic number (like [KindBrotli] or [KindDeflate]) would make the stored
+// bytes ambiguous.
+//
+// The header is written on the first write to "w", or on Close if the
+// compressor hasn't written anything by then. Close does not close "w".
+//
+// Only built-in schemes can be framed, as the values of schemes added with
+// [RegisterDetector] and related functions depend on registration order.
+func WriteFramed(w io.Writer, c Compression) (io.WriteCloser, error) {
+	id, ok := framedIDs[c]
+	if !ok {
+		return nil, fmt.Errorf("zreader: unable to frame compression type %v", c)
+	}
+	fw := &framedWriter{w: w}
+	copy(fw.hdr[:], framedMagic)
+	fw.hdr[len(framedMagic)] = id
+	zw, err := Writer(fw, c)
+	if err != nil {
+		return nil, err
+	}
+	return &framedCloser{WriteCloser: zw, fw: fw}, nil
+}
+
+// ReadFramed returns an [io.ReadCloser] that decompresses a stream written by
+// [WriteFramed], along with the scheme recorded in its header. The same
+// cleanup rules as for [Reader] apply.
+//
+// [ErrNotFramed] is returned if the header is missing or malformed.
+func ReadFramed(r io.Reader) (io.ReadCloser, Compression, error) {
+	var hdr [framedHeaderSz]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, KindNone, fmt.Errorf("%w: %w", ErrNotFramed, err)
+	}
+	if !bytes.Equal(hdr[:len(framedMagic)], []byte(framedMagic)) {
+		return nil, KindNone, ErrNotFramed
+	}
+	id := hdr[len(framedMagic)]
+	c, ok := framedKind(id)
+	if !ok {
+		return nil, KindNone, fmt.Errorf("%w: unknown compression ID %d", ErrNotFramed, id)
+	}
+	rc, err := ReaderWith(r, c)
+	if err != nil {
+		return nil, c, err
+	}
+	return rc, c, nil
+}
+
+// FramedWriter writes the frame header before the first write to the
+// underlying Writer.
+type framedWriter struct {
+	w       io.Writer
+	hdr     [framedHeaderSz]byte
+	written bool
+}
+
+// Write implements [io.Writer].
+func (f *framedWriter) Write(p []byte) (int, error) {
+	if err := f.flush(); err != nil {
+		return 0, err
+	}
+	return f.w.Write(p)
+}
+
+// Flush writes the header, if it hasn't been written.
+func (f *framedWriter) flush() error {
+	if f.written {
+		return nil
+	}
+	if _, err := f.w.Write(f.hdr[:]); err != nil {
+		return err
+	}
+	f.written = true
+	return nil
+}
+
+// FramedCloser makes sure the frame header is written for streams the
+// compressor writes nothing for.
+type framedCloser struct {
+	io.WriteCloser
+	fw *framedWriter
+}
+
+// Close implements [io.Closer].
+func (f *framedCloser) Close() error {
+	if err := f.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return f.fw.flush()
+}
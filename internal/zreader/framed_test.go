@@ -0,0 +1,141 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFramed(t *testing.T) {
+	for _, c := range allKinds {
+		c := c
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := WriteFramed(&buf, c)
+			if c == KindBzip2 {
+				if !errors.Is(err, ErrNoCompressor) {
+					t.Errorf("got: %v, want: %v", err, ErrNoCompressor)
+				}
+				if buf.Len() != 0 {
+					t.Errorf("wrote %d bytes for unsupported scheme", buf.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			rc, kind, err := ReadFramed(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+	t.Run("Empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := WriteFramed(&buf, KindNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.Len(), framedHeaderSz; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+		rc, kind, err := ReadFramed(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if b, err := io.ReadAll(rc); err != nil || len(b) != 0 {
+			t.Errorf("got: %q, %v, want: empty", b, err)
+		}
+	})
+	t.Run("NotFramed", func(t *testing.T) {
+		for name, b := range map[string][]byte{
+			"Unframed": compress(t, KindGzip),
+			"Short":    []byte(framedMagic),
+			"Unknown":  append([]byte(framedMagic), 0xFF),
+		} {
+			if _, _, err := ReadFramed(bytes.NewReader(b)); !errors.Is(err, ErrNotFramed) {
+				t.Errorf("%s: got: %v, want: %v", name, err, ErrNotFramed)
+			}
+		}
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := WriteFramed(io.Discard, Compression(-1)); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+// TestFramedIDs checks that the frame header IDs don't change. Streams
+// written by earlier versions must keep reading the same way, so this test
+// should only ever have entries added.
+func TestFramedIDs(t *testing.T) {
+	want := map[string]byte{
+		"none":    0,
+		"gzip":    1,
+		"zstd":    2,
+		"bzip2":   3,
+		"zlib":    4,
+		"xz":      5,
+		"lz4":     6,
+		"brotli":  7,
+		"tar":     8,
+		"snappy":  9,
+		"lzma":    10,
+		"deflate": 11,
+	}
+	seen := make(map[byte]Compression, len(framedIDs))
+	for c, id := range framedIDs {
+		if prev, ok := seen[id]; ok {
+			t.Errorf("ID %d used by both %v and %v", id, prev, c)
+		}
+		seen[id] = c
+	}
+	for k := Compression(0); k <= KindNone; k++ {
+		w, ok := want[k.String()]
+		if !ok {
+			t.Errorf("%v: no expected ID; add one to this test", k)
+			continue
+		}
+		if got, ok := framedIDs[k]; !ok || got != w {
+			t.Errorf("%v: got: %d, %v, want: %d", k, got, ok, w)
+		}
+	}
+	// The header for a known stream, byte for byte.
+	var buf bytes.Buffer
+	w, err := WriteFramed(&buf, KindZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Bytes()[:framedHeaderSz], []byte("\x89zrf\x02"); !bytes.Equal(got, want) {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
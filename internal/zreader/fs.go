@@ -0,0 +1,262 @@
+package zreader
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DetectFile opens the named file and follows the same procedure as [Detect].
+//
+// Unlike [Detect], which never closes its source, the returned ReadCloser owns
+// its source: closing it also closes the file. If no ReadCloser is returned,
+// the file has already been closed. As with Detect, an [*UnsupportedError] is
+// returned along with a ReadCloser, which must still be closed.
+func DetectFile(name string) (io.ReadCloser, Compression, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, KindNone, err
+	}
+	d, c, err := detect(f, &ReaderOpts{})
+	if d == nil {
+		f.Close()
+		return nil, c, err
+	}
+	return &fileDecoder{Decoder: d, f: f}, c, err
+}
+
+// FileDecoder is a Decoder that closes its source file on Close.
+type fileDecoder struct {
+	Decoder
+	f      *os.File
+	closer sync.Once
+}
+
+// ReadByte implements [io.ByteReader].
+func (d *fileDecoder) ReadByte() (byte, error) {
+	return d.Decoder.(io.ByteReader).ReadByte()
+}
+
+// Close implements [io.Closer].
+func (d *fileDecoder) Close() error {
+	var err error
+	d.closer.Do(func() {
+		err = errors.Join(d.Decoder.Close(), d.f.Close())
+	})
+	return err
+}
+
+// FS returns an [fs.FS] presenting the files in "base" decompressed.
+//
+// Every regular file opened is run through [Detect], and a regular file named
+// with the extension of a detectable scheme (see [Compression.Extension]) is
+// presented without it: "x.txt.gz" in "base" is served as "x.txt". If "base"
+// has both "x.txt" and "x.txt.gz", the former is the one served. Brotli
+// streams can't be detected, so ".br" files are presented as-is. Directories
+// and other non-regular files are passed through unchanged, except that
+// directory listings have their names rewritten. Files in formats that are
+// detected but can't be decoded, such as zip and jar archives, are served
+// unchanged.
+//
+// The Size reported for a compressed file is its compressed size, because
+// the decompressed size is not known without reading the whole file.
+func FS(base fs.FS) fs.FS {
+	return &zfs{base: base}
+}
+
+// Zfs is the fs.FS returned by FS.
+type zfs struct {
+	base fs.FS
+}
+
+var (
+	_ fs.FS        = (*zfs)(nil)
+	_ fs.ReadDirFS = (*zfs)(nil)
+)
+
+// FsExts returns the extensions stripped by FS, in the order they're tried.
+func fsExts() []string {
+	exts := make([]string, 0, int(KindNone))
+	for c := Compression(0); c < KindNone; c++ {
+		switch c {
+		case KindTar, KindBrotli, KindDeflate:
+			continue
+		}
+		exts = append(exts, c.Extension())
+	}
+	return exts
+}
+
+// StripExt returns "name" without the extension of a detectable scheme, and
+// whether there was one.
+func stripExt(name string) (string, bool) {
+	for _, ext := range fsExts() {
+		if n, ok := strings.CutSuffix(name, ext); ok && n != "" {
+			return n, true
+		}
+	}
+	return name, false
+}
+
+// Open implements [fs.FS].
+func (z *zfs) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := z.base.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		for _, ext := range fsExts() {
+			cf, cerr := z.base.Open(name + ext)
+			if cerr != nil {
+				continue
+			}
+			fi, cerr := cf.Stat()
+			if cerr != nil || !fi.Mode().IsRegular() {
+				cf.Close()
+				continue
+			}
+			f, err = cf, nil
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	switch {
+	case fi.Mode().IsRegular():
+	case fi.IsDir():
+		if d, ok := f.(fs.ReadDirFile); ok {
+			return &zdir{ReadDirFile: d}, nil
+		}
+		return f, nil
+	default:
+		return f, nil
+	}
+	rc, _, err := Detect(f)
+	var uerr *UnsupportedError
+	switch {
+	case err == nil:
+	case errors.As(err, &uerr) && rc != nil:
+		// Archives like zip and jar files aren't decompressed, but are still
+		// files: serve them as-is.
+	default:
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &zfile{ReadCloser: rc, f: f, name: path.Base(name)}, nil
+}
+
+// ReadDir implements [fs.ReadDirFS].
+func (z *zfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	ents, err := fs.ReadDir(z.base, name)
+	if err != nil {
+		return nil, err
+	}
+	return renameEntries(ents), nil
+}
+
+// RenameEntries rewrites the names of the regular files in "ents", dropping
+// any that would collide with an existing name. The result is sorted by name.
+func renameEntries(ents []fs.DirEntry) []fs.DirEntry {
+	seen := make(map[string]struct{}, len(ents))
+	for _, e := range ents {
+		seen[e.Name()] = struct{}{}
+	}
+	out := make([]fs.DirEntry, 0, len(ents))
+	for _, e := range ents {
+		if !e.Type().IsRegular() {
+			out = append(out, e)
+			continue
+		}
+		n, ok := stripExt(e.Name())
+		if !ok {
+			out = append(out, e)
+			continue
+		}
+		if _, dup := seen[n]; dup {
+			continue
+		}
+		seen[n] = struct{}{}
+		out = append(out, &zent{DirEntry: e, name: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
+
+// Zfile is a decompressed regular file.
+type zfile struct {
+	io.ReadCloser
+	f      fs.File
+	name   string
+	closer sync.Once
+}
+
+var _ fs.File = (*zfile)(nil)
+
+// Stat implements [fs.File].
+func (f *zfile) Stat() (fs.FileInfo, error) {
+	fi, err := f.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &zinfo{FileInfo: fi, name: f.name}, nil
+}
+
+// Close implements [fs.File].
+func (f *zfile) Close() error {
+	var err error
+	f.closer.Do(func() {
+		err = errors.Join(f.ReadCloser.Close(), f.f.Close())
+	})
+	return err
+}
+
+// Zdir is a directory whose listing has rewritten names.
+type zdir struct {
+	fs.ReadDirFile
+}
+
+// ReadDir implements [fs.ReadDirFile].
+//
+// Names are only deduplicated within a single call.
+func (d *zdir) ReadDir(n int) ([]fs.DirEntry, error) {
+	ents, err := d.ReadDirFile.ReadDir(n)
+	return renameEntries(ents), err
+}
+
+// Zent is a renamed fs.DirEntry.
+type zent struct {
+	fs.DirEntry
+	name string
+}
+
+// Name implements [fs.DirEntry].
+func (e *zent) Name() string { return e.name }
+
+// Info implements [fs.DirEntry].
+func (e *zent) Info() (fs.FileInfo, error) {
+	fi, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return &zinfo{FileInfo: fi, name: e.name}, nil
+}
+
+// Zinfo is a renamed fs.FileInfo.
+type zinfo struct {
+	fs.FileInfo
+	name string
+}
+
+// Name implements [fs.FileInfo].
+func (i *zinfo) Name() string { return i.name }
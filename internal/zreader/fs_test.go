@@ -0,0 +1,154 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	jar := append([]byte{'P', 'K', 0x03, 0x04}, payload...)
+	sys := FS(fstest.MapFS{
+		"a/x.txt.gz": &fstest.MapFile{Data: compress(t, KindGzip)},
+		"a/y.txt":    &fstest.MapFile{Data: []byte("plain\n")},
+		"a/app.jar":  &fstest.MapFile{Data: jar},
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		tt := []struct {
+			Name string
+			Want []byte
+		}{
+			{Name: "a/x.txt", Want: payload},
+			{Name: "a/y.txt", Want: []byte("plain\n")},
+			// Archives aren't decompressed, but are served as-is.
+			{Name: "a/app.jar", Want: jar},
+		}
+		for _, tc := range tt {
+			got, err := fs.ReadFile(sys, tc.Name)
+			if err != nil {
+				t.Errorf("%s: %v", tc.Name, err)
+				continue
+			}
+			if !bytes.Equal(got, tc.Want) {
+				t.Errorf("%s: payload mismatch", tc.Name)
+			}
+		}
+	})
+	t.Run("Stat", func(t *testing.T) {
+		fi, err := fs.Stat(sys, "a/x.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fi.Name(), "x.txt"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+	t.Run("ReadDir", func(t *testing.T) {
+		ents, err := fs.ReadDir(sys, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range ents {
+			got = append(got, e.Name())
+		}
+		if want := []string{"app.jar", "x.txt", "y.txt"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+	t.Run("NotExist", func(t *testing.T) {
+		_, err := sys.Open("a/z.txt")
+		if got, want := err, fs.ErrNotExist; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("TestFS", func(t *testing.T) {
+		if err := fstest.TestFS(sys, "a/app.jar", "a/x.txt", "a/y.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("DoubleClose", func(t *testing.T) {
+		// An *os.File reports a second Close, unlike a MapFS file.
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "x.txt.gz"), compress(t, KindGzip), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := FS(os.DirFS(dir)).Open("x.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("second Close: %v", err)
+		}
+	})
+}
+
+func TestDetectFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		c := c
+		t.Run(c.String(), func(t *testing.T) {
+			name := filepath.Join(dir, "payload"+c.Extension())
+			if err := os.WriteFile(name, compress(t, c), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rc, kind, err := DetectFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+			if err := rc.Close(); err != nil {
+				t.Error(err)
+			}
+			// Close closes the file, and only once.
+			f := rc.(*fileDecoder).f
+			if _, err := f.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+				t.Errorf("got: %v, want: %v", err, os.ErrClosed)
+			}
+			if err := rc.Close(); err != nil {
+				t.Errorf("second Close: %v", err)
+			}
+		})
+	}
+	t.Run("NotExist", func(t *testing.T) {
+		_, _, err := DetectFile(filepath.Join(dir, "missing"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got: %v, want: %v", err, fs.ErrNotExist)
+		}
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		name := filepath.Join(dir, "payload.lz")
+		if err := os.WriteFile(name, []byte("LZIP\x01\x0c"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rc, _, err := DetectFile(name)
+		if !errors.Is(err, ErrUnsupportedScheme) {
+			t.Errorf("got: %v, want: %v", err, ErrUnsupportedScheme)
+		}
+		if err := rc.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func FuzzDetect(f *testing.F) {
+	// Seed with every scheme's header, whole and cut short.
+	for _, c := range allKinds {
+		b := compress(f, c)
+		f.Add(b)
+		if len(b) > 16 {
+			f.Add(b[:16])
+		}
+		if m := HeaderMask(c); m != nil && len(b) >= len(m) {
+			f.Add(b[:len(m)])
+		}
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x78, 0x9c})
+	f.Add([]byte("BZh9"))
+
+	// Limit is the decompressed size any input is allowed to produce.
+	const limit = 4 << 20
+	f.Fuzz(func(t *testing.T, b []byte) {
+		rc, c, err := DetectOpts(bytes.NewReader(b), ReaderOpts{MaxSize: limit})
+		if err != nil {
+			return
+		}
+		defer rc.Close()
+		if got, want := DetectBytes(b), c; got != want {
+			t.Errorf("DetectBytes: got: %v, want: %v", got, want)
+		}
+		n, err := io.Copy(io.Discard, rc)
+		switch {
+		case errors.Is(err, nil):
+		case errors.Is(err, ErrSizeLimit):
+		default:
+			t.Logf("%v: read %d bytes: %v", c, n, err)
+			return
+		}
+		if n > limit {
+			t.Errorf("%v: read %d bytes, more than the limit", c, n)
+		}
+		// A reader at EOF stays there.
+		if m, err := rc.Read(make([]byte, 1)); m != 0 || err == nil {
+			t.Errorf("%v: read %d, %v after EOF", c, m, err)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package zreader
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPBody returns an [io.ReadCloser] that decodes the body of "resp".
+//
+// If the response has a "Content-Encoding" header, the listed encodings are
+// removed in the reverse of the order they're listed, as described in RFC
+// 9110, section 8.4. The "identity" encoding is ignored, "x-gzip" is an alias
+// for "gzip", and "deflate" is decoded as zlib. Other encodings are mapped
+// with [ParseCompression]; an encoding that can't be mapped is reported as an
+// [*UnsupportedError]. If there's no header or only "identity" is listed, the
+// scheme is detected as with [Detect].
+//
+// Note that [net/http.Transport] transparently decodes gzip and removes the
+// header when it requested the encoding itself.
+//
+// Closing the returned ReadCloser also closes the response body. If an error
+// is returned, the caller is still responsible for closing the response body.
+func HTTPBody(resp *http.Response) (io.ReadCloser, error) {
+	var encs []Compression
+	for _, v := range resp.Header.Values("Content-Encoding") {
+		for _, tok := range strings.Split(v, ",") {
+			tok = strings.ToLower(strings.TrimSpace(tok))
+			var c Compression
+			switch tok {
+			case "", "identity":
+				continue
+			case "x-gzip":
+				c = KindGzip
+			case "deflate":
+				c = KindZlib
+			default:
+				var err error
+				c, err = ParseCompression(tok)
+				if err != nil {
+					return nil, &UnsupportedError{Format: tok}
+				}
+			}
+			encs = append(encs, c)
+		}
+	}
+
+	b := &httpBody{cs: []io.Closer{resp.Body}}
+	if len(encs) == 0 {
+		rc, _, err := Detect(resp.Body)
+		if err != nil {
+			// An *UnsupportedError comes with a Reader that's not used here.
+			// Closing it doesn't close the response body.
+			if rc != nil {
+				rc.Close()
+			}
+			return nil, err
+		}
+		b.Reader = rc
+		b.cs = append(b.cs, rc)
+		return b, nil
+	}
+	var r io.Reader = resp.Body
+	for i := len(encs) - 1; i >= 0; i-- {
+		d, err := newReader(r, encs[i], &ReaderOpts{})
+		if err != nil {
+			// Leave the response body for the caller.
+			b.cs = b.cs[1:]
+			return nil, errors.Join(err, b.Close())
+		}
+		r = d
+		b.cs = append(b.cs, d)
+	}
+	b.Reader = r
+	return b, nil
+}
+
+// HttpBody is the io.ReadCloser returned by HTTPBody.
+type httpBody struct {
+	io.Reader
+	// Cs is closed in reverse order, so that the response body is last.
+	cs     []io.Closer
+	closer sync.Once
+}
+
+// Close implements [io.Closer].
+func (b *httpBody) Close() error {
+	var err error
+	b.closer.Do(func() {
+		errs := make([]error, len(b.cs))
+		for i := range b.cs {
+			errs[i] = b.cs[len(b.cs)-1-i].Close()
+		}
+		err = errors.Join(errs...)
+	})
+	return err
+}
+
+// Transport returns an [http.RoundTripper] that asks for gzip or zstd
+// content-coding and removes it from responses with [HTTPBody]. If "next" is
+// nil, [http.DefaultTransport] is used.
+//
+// Because the returned RoundTripper sets the "Accept-Encoding" header itself,
+// an [http.Transport] underneath it never decodes gzip on its own, whatever
+// its DisableCompression setting, so bodies are not decoded twice. Requests
+// that already have an "Accept-Encoding" or "Range" header are passed through
+// untouched.
+//
+// Only the content-coding is removed: a body that is itself, say, a gzipped
+// file is returned as-is.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{next: next}
+}
+
+// AcceptEncoding is the "Accept-Encoding" value sent by Transport.
+const acceptEncoding = "gzip, zstd"
+
+// Transport is the RoundTripper returned by Transport.
+type transport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return t.next.RoundTrip(req)
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	if resp.Header.Get("Content-Encoding") == "" ||
+		req.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	rc, err := HTTPBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body = rc
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
@@ -0,0 +1,187 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// CloseCounter counts calls to Close.
+type closeCounter struct {
+	io.Reader
+	n int
+}
+
+func (c *closeCounter) Close() error {
+	c.n++
+	return nil
+}
+
+func TestHTTPBody(t *testing.T) {
+	// The payload compressed with zstd, then gzip.
+	var buf bytes.Buffer
+	w, err := Writer(&buf, KindGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(compress(t, KindZstd)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gzZst := buf.Bytes()
+
+	tt := []struct {
+		Name     string
+		Encoding []string
+		Body     []byte
+	}{
+		{Name: "Gzip", Encoding: []string{"gzip"}, Body: compress(t, KindGzip)},
+		{Name: "Sniff", Body: compress(t, KindZstd)},
+		{Name: "Identity", Encoding: []string{"identity"}, Body: compress(t, KindZstd)},
+		{Name: "List", Encoding: []string{"zstd, identity, GZIP"}, Body: gzZst},
+		{Name: "Headers", Encoding: []string{"zstd", "x-gzip"}, Body: gzZst},
+		{Name: "Deflate", Encoding: []string{"deflate"}, Body: compress(t, KindZlib)},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			body := &closeCounter{Reader: bytes.NewReader(tc.Body)}
+			resp := &http.Response{
+				Header: http.Header{},
+				Body:   body,
+			}
+			for _, v := range tc.Encoding {
+				resp.Header.Add("Content-Encoding", v)
+			}
+			rc, err := HTTPBody(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+			if err := rc.Close(); err != nil {
+				t.Error(err)
+			}
+			if got, want := body.n, 1; got != want {
+				t.Errorf("body closed: got: %d, want: %d", got, want)
+			}
+		})
+	}
+	t.Run("Unsupported", func(t *testing.T) {
+		resp := &http.Response{
+			Header: http.Header{"Content-Encoding": {"compress"}},
+			Body:   io.NopCloser(bytes.NewReader(nil)),
+		}
+		_, err := HTTPBody(resp)
+		if got, want := err, ErrUnsupportedScheme; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("UnsupportedSniff", func(t *testing.T) {
+		body := &closeCounter{Reader: bytes.NewReader(pbzx(t))}
+		resp := &http.Response{
+			Header: http.Header{},
+			Body:   body,
+		}
+		rc, err := HTTPBody(resp)
+		var uerr *UnsupportedError
+		if !errors.As(err, &uerr) {
+			t.Errorf("got: %v, want: %T", err, uerr)
+		}
+		if rc != nil {
+			t.Error("unexpected ReadCloser on error")
+		}
+		// The response body is left for the caller.
+		if got, want := body.n, 0; got != want {
+			t.Errorf("body closed: got: %d, want: %d", got, want)
+		}
+	})
+}
+
+func TestTransport(t *testing.T) {
+	tt := []struct {
+		Name     string
+		Encoding string
+		Body     []byte
+		Want     []byte
+		Accept   string
+	}{
+		{Name: "Zstd", Encoding: "zstd", Body: compress(t, KindZstd), Want: payload},
+		{Name: "Gzip", Encoding: "gzip", Body: compress(t, KindGzip), Want: payload},
+		{Name: "None", Body: payload, Want: payload},
+		// A compressed file served without content-coding is left alone.
+		{Name: "File", Body: compress(t, KindZstd), Want: compress(t, KindZstd)},
+		// The caller asked for an encoding, so it gets the encoded body.
+		{Name: "Passthrough", Encoding: "zstd", Body: compress(t, KindZstd), Want: compress(t, KindZstd), Accept: "zstd"},
+	}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accept := r.Header.Get("Accept-Encoding")
+				want := tc.Accept
+				if want == "" {
+					want = acceptEncoding
+				}
+				if got := accept; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+				if tc.Encoding != "" {
+					w.Header().Set("Content-Encoding", tc.Encoding)
+				}
+				w.Write(tc.Body)
+			}))
+			defer srv.Close()
+			c := srv.Client()
+			c.Transport = Transport(c.Transport)
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.Accept != "" {
+				req.Header.Set("Accept-Encoding", tc.Accept)
+			}
+			res, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			got, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.Want) {
+				t.Errorf("got: %d bytes, want: %d bytes", len(got), len(tc.Want))
+			}
+			if tc.Accept == "" && res.Header.Get("Content-Encoding") != "" {
+				t.Errorf("unexpected Content-Encoding: %q", res.Header.Get("Content-Encoding"))
+			}
+		})
+	}
+
+	t.Run("Head", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "zstd")
+		}))
+		defer srv.Close()
+		c := &http.Client{Transport: Transport(srv.Client().Transport)}
+		res, err := c.Head(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got, want := res.StatusCode, http.StatusOK; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package zreader
+
+import (
+	"bufio"
+	"io"
+)
+
+// Buffer sizes for [LineScanner].
+const (
+	lineBufSz = 64 * 1024
+	// LineMax is the longest line LineScanner returns. Package metadata
+	// can have long lines, such as a dpkg "Description" or a file list, so
+	// this is well past bufio's default of 64 KiB.
+	lineMax = 16 * 1024 * 1024
+)
+
+// LineScanner returns a [bufio.Scanner] splitting the decompressed contents of
+// "r" into lines, as with [Detect], along with the scheme detected.
+//
+// The Scanner's buffer allows lines of up to 16 MiB. The returned
+// [io.Closer] must be called once the caller is done scanning, as with the
+// ReadCloser returned by [Detect]; it does not close "r".
+//
+// If detection returns an error, including an [*UnsupportedError], no Scanner
+// is returned.
+func LineScanner(r io.Reader) (*bufio.Scanner, io.Closer, Compression, error) {
+	rc, c, err := detect(r, &ReaderOpts{})
+	if err != nil {
+		if rc != nil {
+			rc.Close()
+		}
+		return nil, nil, c, err
+	}
+	s := bufio.NewScanner(rc)
+	s.Buffer(make([]byte, 0, lineBufSz), lineMax)
+	return s, rc, c, nil
+}
@@ -0,0 +1,89 @@
+package zreader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestLineScanner(t *testing.T) {
+	long := strings.Repeat("x", 256*1024)
+	lines := []string{
+		"Package: libc6",
+		"Status: install ok installed",
+		"",
+		"Description: " + long,
+		"Package: zlib1g",
+	}
+	text := []byte(strings.Join(lines, "\n") + "\n")
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want Compression
+	}{
+		{"Plain", text, KindNone},
+		{"Gzip", gz.Bytes(), KindGzip},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			s, cl, kind, err := LineScanner(bytes.NewReader(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cl.Close()
+			if got, want := kind, tc.want; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			var got []string
+			for s.Scan() {
+				got = append(got, s.Text())
+			}
+			if err := s.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(lines) {
+				t.Fatalf("got: %d lines, want: %d", len(got), len(lines))
+			}
+			for i := range lines {
+				if got[i] != lines[i] {
+					t.Errorf("line %d: got: %.40q, want: %.40q", i, got[i], lines[i])
+				}
+			}
+		})
+	}
+	t.Run("TooLong", func(t *testing.T) {
+		s, cl, _, err := LineScanner(strings.NewReader(strings.Repeat("x", lineMax+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cl.Close()
+		for s.Scan() {
+		}
+		if got, want := s.Err(), bufio.ErrTooLong; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		s, cl, _, err := LineScanner(bytes.NewReader(pbzx(t)))
+		var uerr *UnsupportedError
+		if !errors.As(err, &uerr) {
+			t.Errorf("got: %v, want: %T", err, uerr)
+		}
+		if s != nil || cl != nil {
+			t.Error("unexpected Scanner on error")
+		}
+	})
+}
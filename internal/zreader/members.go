@@ -0,0 +1,284 @@
+//go:build go1.23
+
+package zreader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// MemberReader decompresses a single member of a stream, as returned by
+// [Members].
+type MemberReader interface {
+	io.Reader
+	// Offset reports the offset of the start of the member in the compressed
+	// stream.
+	Offset() int64
+	// DecompressedBytes reports the number of bytes returned from Read. Once
+	// Read has returned io.EOF, this is the decompressed length of the member.
+	DecompressedBytes() int64
+}
+
+// Members returns an iterator over the members of the stream "r", which may be
+// a concatenation of multiple compressed streams of the same scheme.
+//
+// Each MemberReader decompresses exactly one member, and is only valid until
+// the iterator advances. Any bytes of a member not read by the caller are
+// read and discarded when advancing. If an error is yielded, iteration stops.
+//
+// Gzip and zstd streams are split into their members and frames,
+// respectively; zstd skippable frames are skipped. Every other scheme,
+// including bzip2 (which has no byte-aligned member boundaries), is reported
+// as a single member.
+func Members(r io.Reader) iter.Seq2[MemberReader, error] {
+	if r == nil {
+		r = emptyReader{}
+	}
+	return func(yield func(MemberReader, error) bool) {
+		src := &countReader{Reader: r}
+		br := bufio.NewReaderSize(src, (&ReaderOpts{}).bufferSize())
+		offset := func() int64 {
+			return src.n.Load() - int64(br.Buffered())
+		}
+		b, err := br.Peek(peekSz())
+		switch {
+		case errors.Is(err, nil):
+		case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		default:
+			yield(nil, err)
+			return
+		}
+
+		// Next returns the Reader for the next member and its offset, or
+		// io.EOF if there are no more members.
+		var next func() (io.Reader, int64, error)
+		var done func()
+		switch c := detectCompression(b); c {
+		case KindGzip:
+			var z *gzip.Reader
+			next = func() (io.Reader, int64, error) {
+				if _, err := br.Peek(1); err != nil {
+					return nil, 0, err
+				}
+				off := offset()
+				var err error
+				if z == nil {
+					z, err = gzip.NewReader(br)
+				} else {
+					err = z.Reset(br)
+				}
+				if err != nil {
+					return nil, 0, err
+				}
+				z.Multistream(false)
+				return z, off, nil
+			}
+		case KindZstd:
+			z, err := getZstd(nil)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			done = func() { putZstd(z) }
+			var f *zstdFrame
+			next = func() (io.Reader, int64, error) {
+				if f != nil {
+					// Make sure the decoder is done with the previous frame
+					// and the whole frame is consumed.
+					if err := z.Reset(nil); err != nil {
+						return nil, 0, err
+					}
+					if _, err := io.Copy(io.Discard, f); err != nil {
+						return nil, 0, err
+					}
+				}
+				f = &zstdFrame{br: br}
+				if err := f.begin(); err != nil {
+					return nil, 0, err
+				}
+				// The frame header is only peeked by begin.
+				off := offset()
+				if err := z.Reset(f); err != nil {
+					return nil, 0, err
+				}
+				return z, off, nil
+			}
+		default:
+			var used bool
+			next = func() (io.Reader, int64, error) {
+				if used {
+					return nil, 0, io.EOF
+				}
+				used = true
+				d, err := newReader(br, c, &ReaderOpts{})
+				if err != nil {
+					return nil, 0, err
+				}
+				return d, 0, nil
+			}
+		}
+		if done != nil {
+			defer done()
+		}
+
+		for {
+			r, start, err := next()
+			switch {
+			case errors.Is(err, nil):
+			case errors.Is(err, io.EOF):
+				return
+			default:
+				yield(nil, err)
+				return
+			}
+			m := &member{r: r, off: start}
+			if !yield(m, nil) {
+				return
+			}
+			if _, err := io.Copy(io.Discard, m); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
+
+// Member is the concrete MemberReader.
+type member struct {
+	r   io.Reader
+	off int64
+	n   int64
+}
+
+var _ MemberReader = (*member)(nil)
+
+// Read implements [io.Reader].
+func (m *member) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	return n, err
+}
+
+// Offset implements [MemberReader].
+func (m *member) Offset() int64 { return m.off }
+
+// DecompressedBytes implements [MemberReader].
+func (m *member) DecompressedBytes() int64 { return m.n }
+
+// ZstdFrame reads exactly one zstd frame from the underlying Reader, by
+// walking the frame and block headers.
+//
+// See RFC 8878 for the format.
+type zstdFrame struct {
+	br *bufio.Reader
+	// Rem is the number of bytes left in the current section.
+	rem int64
+	// Last is set once the last block's header has been read.
+	last bool
+	// Sum is set if the frame has a trailing checksum that has not been read.
+	sum bool
+}
+
+// Zstd frame constants.
+const (
+	zstdMagic     = 0xFD2FB528
+	zstdSkipMagic = 0x184D2A50
+)
+
+// Begin skips any skippable frames and reads the frame header, returning
+// io.EOF if the underlying Reader has no more frames.
+func (f *zstdFrame) begin() error {
+	for {
+		h, err := f.br.Peek(8)
+		switch {
+		case len(h) == 0 && errors.Is(err, io.EOF):
+			return io.EOF
+		case len(h) < 5:
+			return io.ErrUnexpectedEOF
+		}
+		m := binary.LittleEndian.Uint32(h)
+		if m&^0xF == zstdSkipMagic {
+			if len(h) < 8 {
+				return io.ErrUnexpectedEOF
+			}
+			sz := int(binary.LittleEndian.Uint32(h[4:]))
+			if _, err := f.br.Discard(8 + sz); err != nil {
+				return unexpected(err)
+			}
+			continue
+		}
+		if m != zstdMagic {
+			return fmt.Errorf("zreader: bad zstd frame magic: %08x", m)
+		}
+		fhd := h[4]
+		l := 4 + 1
+		single := fhd&(1<<5) != 0
+		if !single {
+			l++ // Window descriptor.
+		}
+		// Dictionary ID.
+		l += [...]int{0, 1, 2, 4}[fhd&0x3]
+		// Frame content size.
+		switch fhd >> 6 {
+		case 0:
+			if single {
+				l++
+			}
+		case 1:
+			l += 2
+		case 2:
+			l += 4
+		case 3:
+			l += 8
+		}
+		f.sum = fhd&(1<<2) != 0
+		f.rem = int64(l)
+		return nil
+	}
+}
+
+// Read implements [io.Reader].
+func (f *zstdFrame) Read(p []byte) (int, error) {
+	for f.rem == 0 {
+		switch {
+		case f.last && f.sum:
+			f.sum = false
+			f.rem = 4
+			continue
+		case f.last:
+			return 0, io.EOF
+		}
+		h, err := f.br.Peek(3)
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		v := uint32(h[0]) | uint32(h[1])<<8 | uint32(h[2])<<16
+		f.last = v&1 != 0
+		switch (v >> 1) & 0x3 {
+		case 0, 2: // Raw, compressed.
+			f.rem = 3 + int64(v>>3)
+		case 1: // RLE.
+			f.rem = 3 + 1
+		default:
+			return 0, errors.New("zreader: reserved zstd block type")
+		}
+	}
+	if int64(len(p)) > f.rem {
+		p = p[:f.rem]
+	}
+	n, err := f.br.Read(p)
+	f.rem -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = nil
+		if f.rem > 0 || !f.last {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,125 @@
+//go:build go1.23
+
+package zreader
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestMembers(t *testing.T) {
+	parts := [][]byte{
+		payload,
+		bytes.ToUpper(payload[:1024]),
+	}
+	enc := map[Compression]func(t *testing.T, b []byte) []byte{
+		KindGzip: func(t *testing.T, b []byte) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		},
+		KindZstd: func(t *testing.T, b []byte) []byte {
+			var buf bytes.Buffer
+			w, err := zstd.NewWriter(&buf, zstd.WithEncoderCRC(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		},
+	}
+	for _, c := range []Compression{KindGzip, KindZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			var in []byte
+			var offsets []int64
+			for _, p := range parts {
+				offsets = append(offsets, int64(len(in)))
+				in = append(in, enc[c](t, p)...)
+			}
+			if c == KindZstd {
+				// Tack a skippable frame in between the members and make
+				// sure it's ignored.
+				skip := []byte{0x50, 0x2A, 0x4D, 0x18, 0x03, 0x00, 0x00, 0x00, 'a', 'b', 'c'}
+				in = append(in[:offsets[1]:offsets[1]], append(skip, in[offsets[1]:]...)...)
+				offsets[1] += int64(len(skip))
+			}
+
+			var i int
+			for m, err := range Members(bytes.NewReader(in)) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i >= len(parts) {
+					t.Fatalf("too many members: %d", i+1)
+				}
+				if got, want := m.Offset(), offsets[i]; got != want {
+					t.Errorf("%d: offset: got: %d, want: %d", i, got, want)
+				}
+				got, err := io.ReadAll(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, parts[i]) {
+					t.Errorf("%d: payload mismatch", i)
+				}
+				if got, want := m.DecompressedBytes(), int64(len(parts[i])); got != want {
+					t.Errorf("%d: length: got: %d, want: %d", i, got, want)
+				}
+				i++
+			}
+			if got, want := i, len(parts); got != want {
+				t.Errorf("got: %d members, want: %d", got, want)
+			}
+		})
+	}
+	t.Run("PartialRead", func(t *testing.T) {
+		in := append(enc[KindZstd](t, parts[0]), enc[KindZstd](t, parts[1])...)
+		var n int
+		for m, err := range Members(bytes.NewReader(in)) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(m, make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		if got, want := n, 2; got != want {
+			t.Errorf("got: %d members, want: %d", got, want)
+		}
+	})
+	t.Run("Single", func(t *testing.T) {
+		var n int
+		for m, err := range Members(bytes.NewReader(compress(t, KindXz))) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+			n++
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("got: %d members, want: %d", got, want)
+		}
+	})
+}
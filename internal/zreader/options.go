@@ -0,0 +1,611 @@
+package zreader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"math"
+	"runtime/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// ReaderOpts modifies the behavior of the [io.ReadCloser] returned by
+// [DetectOpts] and [NewSeekerOpts].
+//
+// All decoder and detection settings are fields here, so that new settings
+// don't need new functions. The zero value is the same behavior as [Detect],
+// and every field documents what its zero value means. Fields that don't
+// apply to the detected scheme are ignored, and fields that do apply
+// compose.
+type ReaderOpts struct {
+	// MaxSize is the maximum number of decompressed bytes that can be read.
+	// Reading more than this returns [ErrSizeLimit].
+	//
+	// A value less than or equal to zero means no limit.
+	MaxSize int64
+	// MaxRatio is the maximum ratio of decompressed bytes to compressed bytes.
+	// Exceeding this returns a [*RatioError].
+	//
+	// The ratio is only checked once 64 KiB of input has been consumed, so that
+	// a highly compressible prefix doesn't cause spurious errors. A stream that
+	// produces more than MaxRatio × 64 KiB of output before then is always
+	// reported.
+	//
+	// A value less than or equal to zero means no limit.
+	MaxRatio float64
+	// BufferSize is the size of the buffer used for reading from the source
+	// Reader. Values smaller than the number of bytes needed for detection
+	// are increased.
+	//
+	// A value less than or equal to zero means the [bufio] default.
+	BufferSize int
+	// NoMultistream causes only the first member of a gzip stream to be read.
+	//
+	// By default, concatenated members are read as one stream.
+	NoMultistream bool
+	// FallbackOnError causes a failure to construct a decoder for a detected
+	// scheme to return the original stream, reported as [KindNone], instead of
+	// an error.
+	//
+	// This has some overhead, as bytes consumed by the decoder's constructor
+	// need to be saved and some decoders lose access to a fast path.
+	//
+	// By default, the constructor's error is returned.
+	FallbackOnError bool
+	// ZlibDict is a preset dictionary for zlib streams, provided out-of-band.
+	//
+	// A zlib stream that requires a preset dictionary is only detected if
+	// this is set. If the stream's dictionary ID does not match the Adler-32
+	// checksum of ZlibDict, [ErrZlibDict] is returned.
+	//
+	// By default, zlib streams that require a dictionary are not detected.
+	ZlibDict []byte
+	// ZlibDictProvider looks up a preset dictionary for zlib streams by the
+	// Adler-32 checksum in the stream's header, for callers with a set of
+	// known dictionaries. It's consulted if ZlibDict is not set or doesn't
+	// match. If it returns an error, a nil dictionary, or a dictionary
+	// that doesn't match the checksum, [ErrZlibDict] is returned.
+	//
+	// By default, only ZlibDict is used.
+	ZlibDictProvider func(dictID uint32) ([]byte, error)
+	// RequireFullHeader causes a source too short for every detector to be
+	// reported as KindNone with the error from reading it, usually
+	// [io.ErrUnexpectedEOF] or [io.EOF]. The returned Reader still contains
+	// all the bytes of the source.
+	//
+	// By default, short sources are checked with the detectors that fit and
+	// no error is reported.
+	RequireFullHeader bool
+	// DetectTimeout is the longest detection will wait for enough bytes from
+	// the source Reader, after which [ErrDetectTimeout] is returned. This
+	// guards against sources that stall without returning an error.
+	//
+	// When the timeout is hit, a Read call on the source is still
+	// outstanding and the source can't be used again; the caller should
+	// arrange for it to be closed. If a Context is provided, its cancellation
+	// is also observed while waiting.
+	//
+	// A value less than or equal to zero means no timeout.
+	DetectTimeout time.Duration
+	// ZstdDicts are dictionaries for zstd streams, in the format produced by
+	// "zstd --train". The dictionary to use is selected by the ID embedded in
+	// the stream.
+	//
+	// Decoders with dictionaries are not pooled.
+	//
+	// By default, zstd streams that require a dictionary fail to decode.
+	ZstdDicts [][]byte
+	// ZstdMaxWindow is the largest window size, in bytes, a zstd stream may
+	// declare. Streams compressed with long-distance matching (for example,
+	// "zstd --long=30") can need windows larger than the default, and fail
+	// with a "window size exceeded" error without this. The decoder allocates
+	// memory proportional to the window size, so this bounds the memory a
+	// hostile stream can make the decoder use.
+	//
+	// Decoders with a non-default window limit are not pooled.
+	//
+	// A value less than or equal to zero means the decoder's default of
+	// 512 MiB, which is enough for streams produced with "--long" up to 29.
+	ZstdMaxWindow int
+	// ZstdConcurrency is the number of blocks a zstd decoder works on at
+	// once. Each in-flight block holds its own buffers, and any value other
+	// than 1 starts goroutines for every stream, so a low value trades
+	// throughput for memory when many streams are decoded concurrently. A
+	// value of 1 decodes synchronously.
+	//
+	// Decoders with a non-default concurrency are not pooled.
+	//
+	// A value less than or equal to zero means the decoder's default, the
+	// lesser of 4 and GOMAXPROCS.
+	ZstdConcurrency int
+	// ParallelBzip2 causes bzip2 streams to be decoded by multiple
+	// goroutines, one block at a time. Blocks are at most 900 kB, so this
+	// only helps with large streams. Up to 2×GOMAXPROCS blocks are held in
+	// memory at once.
+	//
+	// If a block fails to decode, which may be because the stream was split
+	// in the wrong place, that part of the stream is decoded serially before
+	// an error is reported. The returned Decoder's Underlying method does not
+	// report a "compress/bzip2" Reader.
+	ParallelBzip2 bool
+	// SeekMemory is the number of decompressed bytes kept in memory by
+	// [NewSeekerOpts] before spilling to a temporary file.
+	//
+	// A value less than or equal to zero means [DefaultSeekMemory].
+	SeekMemory int64
+	// DrainOnClose is the number of bytes of the source Reader to read and
+	// discard on Close, if the Decoder hasn't consumed them. A source such
+	// as an HTTP response body can only be reused, for example by keeping
+	// the connection alive, once it has been read to the end, but a caller
+	// that stops reading early leaves the rest unread.
+	//
+	// Draining stops after this many bytes, so a huge or endless stream
+	// doesn't hold up Close; the rest is left unread and no error is
+	// reported. Errors from the source other than io.EOF are returned by
+	// Close. This is ignored if ParallelBzip2 is set, as the decoder may be
+	// reading from the source concurrently.
+	//
+	// A value less than or equal to zero means the source is not drained.
+	DrainOnClose int64
+	// Known is the scheme of the source, if the caller already knows it, for
+	// example from an earlier detection of the same content. The detectors
+	// are not run and the source is decoded as *Known, which is the reported
+	// scheme.
+	//
+	// By default, the scheme is detected.
+	Known *Compression
+	// Timeout is how long the stream may be read for, starting from the first
+	// Read. Once it's elapsed, Read returns [ErrTimeout]. This is for callers
+	// that have no Context to cancel; if there is one, whichever is done
+	// first is reported.
+	//
+	// The timeout is checked before every Read, so a Read that's blocked on
+	// the source Reader is not interrupted.
+	//
+	// A value less than or equal to zero means no timeout.
+	Timeout time.Duration
+
+	// Ctx is checked before every Read, if set.
+	ctx context.Context
+	// Tee is written every byte read from the source Reader, if set.
+	tee io.Writer
+	// Count forces "in" to be populated.
+	count bool
+	// In counts bytes read from the source Reader, if needed.
+	in *countReader
+	// Src observes errors from the source Reader.
+	src *srcReader
+	// Header is a copy of the bytes examined during detection.
+	header []byte
+	// Drain is the buffered source, for DrainOnClose.
+	drain *bufio.Reader
+}
+
+// Source arranges for the options to observe the source [io.Reader], if
+// needed. The returned Reader should be used in place of the passed one.
+func (o *ReaderOpts) source(r io.Reader) io.Reader {
+	if o.tee != nil {
+		r = io.TeeReader(r, o.tee)
+	}
+	if o.ctx != nil {
+		r = &ctxReader{Reader: r, ctx: o.ctx}
+	}
+	if o.count || o.MaxRatio > 0 {
+		o.in = &countReader{Reader: r}
+		r = o.in
+	}
+	o.src = &srcReader{Reader: r}
+	return o.src
+}
+
+// Detect reports the compression scheme indicated by the header "b", taking
+// into account any options that affect detection.
+func (o *ReaderOpts) detect(b []byte) (Compression, error) {
+	if o.Known != nil {
+		return *o.Known, nil
+	}
+	// The result of every detector is only recorded in an execution trace;
+	// the log gets one event per stream.
+	var tr func(Compression, []byte, bool)
+	if o.ctx != nil && trace.IsEnabled() {
+		tr = func(c Compression, masked []byte, ok bool) {
+			trace.Logf(o.ctx, "zreader", "detector %s: header %x, match %t", c.name(), masked, ok)
+		}
+	}
+	c := detectTrace(b, tr)
+	if c == KindNone && (o.ZlibDict != nil || o.ZlibDictProvider != nil) {
+		// The detector can't know about a sideband dictionary, so check for
+		// a zlib stream that wants one here.
+		if id, dict, ok := zlibHeader(b); ok && dict {
+			if err := o.zlibDict(id); err != nil {
+				return KindNone, err
+			}
+			c = KindZlib
+		}
+	}
+	if o.ctx != nil {
+		zlog.Debug(o.ctx).
+			Str("compression", c.name()).
+			Float64("confidence", c.Confidence()).
+			Int("peeked", len(b)).
+			Msg("detected compression")
+	}
+	return c, nil
+}
+
+// ZlibDict arranges for ZlibDict to be the dictionary with the Adler-32
+// checksum "id", using ZlibDictProvider if needed.
+func (o *ReaderOpts) zlibDict(id uint32) error {
+	if o.ZlibDict != nil {
+		have := adler32.Checksum(o.ZlibDict)
+		switch {
+		case have == id:
+			return nil
+		case o.ZlibDictProvider == nil:
+			return fmt.Errorf("%w: stream wants %08x, have %08x", ErrZlibDict, id, have)
+		}
+	}
+	d, err := o.ZlibDictProvider(id)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w: looking up dictionary %08x: %w", ErrZlibDict, id, err)
+	case d == nil:
+		return fmt.Errorf("%w: no dictionary for %08x", ErrZlibDict, id)
+	}
+	if have := adler32.Checksum(d); have != id {
+		return fmt.Errorf("%w: stream wants %08x, provider returned %08x", ErrZlibDict, id, have)
+	}
+	o.ZlibDict = d
+	return nil
+}
+
+// ErrDetectTimeout is returned when the source Reader doesn't provide enough
+// bytes for detection within [ReaderOpts.DetectTimeout].
+var ErrDetectTimeout = errors.New("zreader: timed out waiting for header")
+
+// Peek reads the bytes needed for detection from "br", observing the
+// configured timeout.
+func (o *ReaderOpts) peek(br *bufio.Reader) ([]byte, error) {
+	if o.DetectTimeout <= 0 {
+		return br.Peek(peekSz())
+	}
+	type result struct {
+		b   []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		b, err := br.Peek(peekSz())
+		ch <- result{b, err}
+	}()
+	var done <-chan struct{}
+	if o.ctx != nil {
+		done = o.ctx.Done()
+	}
+	t := time.NewTimer(o.DetectTimeout)
+	defer t.Stop()
+	select {
+	case res := <-ch:
+		return res.b, res.err
+	case <-t.C:
+		return nil, ErrDetectTimeout
+	case <-done:
+		return nil, fmt.Errorf("%w: %w", ErrDetectTimeout, o.ctx.Err())
+	}
+}
+
+// DefaultBufferSize is the size of read buffers that aren't configured by
+// [ReaderOpts.BufferSize]. It's the same as bufio's.
+const defaultBufferSize = 4096
+
+// BufferSize reports the size to use for the read buffer.
+func (o *ReaderOpts) bufferSize() int {
+	switch sz := o.BufferSize; {
+	case sz <= 0:
+		return defaultBufferSize
+	case sz < peekSz():
+		return peekSz()
+	default:
+		return sz
+	}
+}
+
+// Wrap applies the options to the [Decoder].
+//
+// The wrapping types embed the Decoder and only override Read or Close, so
+// that the other methods are available on the result.
+func (o *ReaderOpts) wrap(d Decoder) Decoder {
+	if o.DrainOnClose > 0 && o.drain != nil {
+		d = &drainCloser{Decoder: d, src: o.drain, max: o.DrainOnClose}
+	}
+	if o.MaxRatio > 0 {
+		d = &ratioReader{Decoder: d, in: o.in, max: o.MaxRatio}
+	}
+	if o.MaxSize > 0 {
+		d = &limitReader{Decoder: d, rem: o.MaxSize}
+	}
+	if o.Timeout > 0 {
+		d = &timeoutDecoder{Decoder: d, timeout: o.Timeout}
+	}
+	if o.ctx != nil {
+		d = &ctxDecoder{Decoder: d, ctx: o.ctx}
+	}
+	if _, ok := d.(io.ByteReader); !ok {
+		d = &byteDecoder{Decoder: d}
+	}
+	return d
+}
+
+// ErrSizeLimit is returned when a decompressed stream is larger than the
+// configured limit.
+var ErrSizeLimit = errors.New("zreader: size limit exceeded")
+
+// ErrZlibDict is returned when a zlib stream's preset dictionary does not
+// match the one provided in [ReaderOpts], or can't be found by the
+// configured provider.
+var ErrZlibDict = errors.New("zreader: zlib dictionary mismatch")
+
+// LimitReader returns [ErrSizeLimit] if more than the configured number of
+// bytes are read from the underlying Decoder.
+type limitReader struct {
+	Decoder
+	// Rem is the number of bytes that can still be read. It's set to -1 once
+	// the limit has been exceeded.
+	rem int64
+}
+
+// Read implements [io.Reader].
+//
+// One byte more than the limit is read from the underlying Reader, so that a
+// stream of exactly the limit is not reported as an error. A limit of
+// [math.MaxInt64] can't be exceeded by a slice, and adding one would overflow.
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.rem < 0 {
+		return 0, ErrSizeLimit
+	}
+	if l.rem < math.MaxInt64 && int64(len(p)) > l.rem+1 {
+		p = p[:l.rem+1]
+	}
+	n, err := l.Decoder.Read(p)
+	if int64(n) > l.rem {
+		n = int(l.rem)
+		l.rem = -1
+		return n, ErrSizeLimit
+	}
+	l.rem -= int64(n)
+	return n, err
+}
+
+// RatioWarmup is the number of compressed bytes that need to be read before
+// the ratio is checked.
+const ratioWarmup = 64 * 1024
+
+// ErrRatioExceeded is returned when a stream's compression ratio is larger
+// than the configured limit.
+//
+// The concrete type is [*RatioError], which carries the observed ratio.
+var ErrRatioExceeded = errors.New("zreader: compression ratio exceeded")
+
+// RatioError is the concrete type backing [ErrRatioExceeded].
+type RatioError struct {
+	// Ratio is the observed ratio of decompressed bytes to compressed bytes.
+	Ratio float64
+	// Limit is the configured limit.
+	Limit float64
+}
+
+// Error implements error.
+func (e *RatioError) Error() string {
+	return fmt.Sprintf("zreader: compression ratio exceeded: %.1f > %.1f", e.Ratio, e.Limit)
+}
+
+// Is enables errors.Is.
+func (e *RatioError) Is(target error) bool {
+	return target == ErrRatioExceeded || target == e
+}
+
+// RatioReader returns a [*RatioError] if the ratio of bytes read from the
+// underlying Decoder to the bytes read from the source exceeds the configured
+// limit.
+type ratioReader struct {
+	Decoder
+	in  *countReader
+	out int64
+	max float64
+}
+
+// Read implements [io.Reader].
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	r.out += int64(n)
+	in, out := float64(r.in.n.Load()), float64(r.out)
+	if in >= ratioWarmup || out > r.max*ratioWarmup {
+		if ratio := out / in; ratio > r.max {
+			return n, &RatioError{Ratio: ratio, Limit: r.max}
+		}
+	}
+	return n, err
+}
+
+// DrainCloser reads and discards up to "max" bytes of the source before
+// closing the underlying Decoder.
+type drainCloser struct {
+	Decoder
+	src    *bufio.Reader
+	max    int64
+	closer sync.Once
+}
+
+// Close implements [io.Closer].
+func (d *drainCloser) Close() error {
+	var err error
+	d.closer.Do(func() {
+		// The Decoder may return the buffer to a pool on Close, so drain
+		// first.
+		_, err = io.CopyN(io.Discard, d.src, d.max)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		err = errors.Join(err, d.Decoder.Close())
+	})
+	return err
+}
+
+// CtxReader returns the Context's error instead of calling Read on the
+// underlying Reader once the Context is done.
+type ctxReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+// Read implements [io.Reader].
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.Reader.Read(p)
+}
+
+// CtxDecoder returns the Context's error instead of calling Read on the
+// underlying Decoder once the Context is done. The Close method ignores the
+// Context.
+type ctxDecoder struct {
+	Decoder
+	ctx context.Context
+}
+
+// Read implements [io.Reader].
+func (c *ctxDecoder) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.Decoder.Read(p)
+}
+
+// ErrTimeout is returned when a stream is read for longer than
+// [ReaderOpts.Timeout].
+var ErrTimeout = errors.New("zreader: read timeout exceeded")
+
+// TimeoutDecoder returns [ErrTimeout] instead of calling Read on the
+// underlying Decoder once the timeout has elapsed since the first Read.
+type timeoutDecoder struct {
+	Decoder
+	timeout time.Duration
+	// Deadline is set by the first Read.
+	deadline time.Time
+}
+
+// Read implements [io.Reader].
+func (t *timeoutDecoder) Read(p []byte) (int, error) {
+	switch {
+	case t.deadline.IsZero():
+		t.deadline = time.Now().Add(t.timeout)
+	case !time.Now().Before(t.deadline):
+		return 0, ErrTimeout
+	}
+	return t.Decoder.Read(p)
+}
+
+// ByteDecoder implements [io.ByteReader] for a Decoder wrapped by the types
+// added by [ReaderOpts]. It's the outermost wrapper, so the bytes it buffers
+// have already been seen by the others.
+type byteDecoder struct {
+	Decoder
+	// Br is created by the first call to ReadByte, and is drained by Read
+	// before the Decoder is read again.
+	br     *bufio.Reader
+	closer sync.Once
+	closed bool
+}
+
+// Read implements [io.Reader].
+func (d *byteDecoder) Read(p []byte) (int, error) {
+	if d.br != nil && d.br.Buffered() > 0 {
+		return d.br.Read(p)
+	}
+	return d.Decoder.Read(p)
+}
+
+// ReadByte implements [io.ByteReader].
+//
+// The Decoder is buffered on the first call. A Decoder that keeps returning
+// no bytes and no error is reported as [io.ErrNoProgress].
+func (d *byteDecoder) ReadByte() (byte, error) {
+	if d.closed {
+		return 0, errClosed
+	}
+	if d.br == nil {
+		d.br = getBufio(d.Decoder, defaultBufferSize)
+	}
+	return d.br.ReadByte()
+}
+
+// Underlying implements [Decoder].
+//
+// Nil is reported while there are buffered bytes, as the concrete decoder has
+// read past them.
+func (d *byteDecoder) Underlying() any {
+	if d.br != nil && d.br.Buffered() > 0 {
+		return nil
+	}
+	return d.Decoder.Underlying()
+}
+
+// Empty implements [Decoder].
+func (d *byteDecoder) Empty() bool {
+	if d.br != nil && d.br.Buffered() > 0 {
+		return false
+	}
+	return d.Decoder.Empty()
+}
+
+// Close implements [io.Closer].
+func (d *byteDecoder) Close() error {
+	var err error
+	d.closer.Do(func() {
+		d.closed = true
+		err = d.Decoder.Close()
+		if d.br != nil {
+			putBufio(d.br)
+			d.br = nil
+		}
+	})
+	return err
+}
+
+// Recorder saves the bytes read through it while on.
+//
+// It implements [io.ByteReader] so that decoders don't add their own
+// buffering, which would consume bytes that the recorder wouldn't see.
+type recorder struct {
+	br  *bufio.Reader
+	buf bytes.Buffer
+	// On is read by the decoder's goroutine, if it has one.
+	on atomic.Bool
+}
+
+// Read implements [io.Reader].
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.br.Read(p)
+	if r.on.Load() {
+		r.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// ReadByte implements [io.ByteReader].
+func (r *recorder) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil && r.on.Load() {
+		r.buf.WriteByte(b)
+	}
+	return b, err
+}
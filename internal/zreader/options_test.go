@@ -0,0 +1,1033 @@
+package zreader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestMaxSize(t *testing.T) {
+	const (
+		limit = 1024 * 1024
+		size  = 10 * limit
+	)
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.CopyN(w, zeroes{}, size); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("compressed %d bytes to %d bytes", size, buf.Len())
+
+	t.Run("Trip", func(t *testing.T) {
+		rc, kind, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{MaxSize: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		n, err := io.Copy(io.Discard, rc)
+		if !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if got, want := n, int64(limit); got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Exact", func(t *testing.T) {
+		rc, _, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{MaxSize: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("MaxInt64", func(t *testing.T) {
+		rc, _, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{MaxSize: math.MaxInt64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		n, err := io.Copy(io.Discard, rc)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if got, want := n, int64(size); got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Small", func(t *testing.T) {
+		for _, c := range allKinds {
+			if c == KindBrotli {
+				continue
+			}
+			t.Run(c.String(), func(t *testing.T) {
+				rc, _, err := DetectOpts(bytes.NewReader(compress(t, c)), ReaderOpts{MaxSize: limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rc.Close()
+				got, err := io.ReadAll(rc)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Error("payload mismatch")
+				}
+			})
+		}
+	})
+}
+
+func TestMaxRatio(t *testing.T) {
+	t.Run("Bomb", func(t *testing.T) {
+		// Needs to be larger than MaxRatio * 64 KiB.
+		const size = 128 * 1024 * 1024
+		var buf bytes.Buffer
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(w, zeroes{}, size); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("ratio: %d:1", size/buf.Len())
+		if size/buf.Len() < 1000 {
+			t.Fatal("test payload not compressible enough")
+		}
+
+		rc, _, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{MaxRatio: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		n, err := io.Copy(io.Discard, rc)
+		t.Logf("read %d bytes", n)
+		if !errors.Is(err, ErrRatioExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var re *RatioError
+		if !errors.As(err, &re) {
+			t.Fatalf("unexpected error type: %T", err)
+		}
+		t.Logf("observed ratio: %v", re.Ratio)
+		if re.Ratio <= 1000 {
+			t.Errorf("reported ratio too low: %v", re.Ratio)
+		}
+		if n >= size {
+			t.Error("read entire payload")
+		}
+	})
+	t.Run("Normal", func(t *testing.T) {
+		// Generate some text that compresses at roughly 3:1.
+		words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+		rng := rand.New(rand.NewSource(0))
+		var in bytes.Buffer
+		for in.Len() < 1024*1024 {
+			in.WriteString(words[rng.Intn(len(words))])
+			in.WriteByte(" \n"[rng.Intn(2)])
+			in.WriteString(strings.Repeat("x", rng.Intn(4)))
+		}
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(in.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("ratio: %.1f:1", float64(in.Len())/float64(buf.Len()))
+
+		rc, _, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{MaxRatio: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, in.Bytes()) {
+			t.Error("payload mismatch")
+		}
+	})
+}
+
+// Zeroes is an infinite Reader of zero bytes.
+type zeroes struct{}
+
+func (zeroes) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestContext(t *testing.T) {
+	t.Run("MidStream", func(t *testing.T) {
+		for _, c := range []Compression{KindGzip, KindZstd, KindNone} {
+			t.Run(c.String(), func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				rc, kind, err := DetectContext(ctx, bytes.NewReader(compress(t, c)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := kind, c; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				if _, err := io.ReadFull(rc, make([]byte, 512)); err != nil {
+					t.Fatal(err)
+				}
+				cancel()
+				_, err = io.Copy(io.Discard, rc)
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if err := rc.Close(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+	})
+	t.Run("Detect", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ReaderContext(ctx, bytes.NewReader(compress(t, KindGzip)))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBufferSize(t *testing.T) {
+	tt := []struct {
+		In   int
+		Want int
+	}{
+		{In: 0, Want: 4096},
+		{In: -1, Want: 4096},
+		{In: 1, Want: peekSz()},
+		{In: 1 << 20, Want: 1 << 20},
+	}
+	for _, tc := range tt {
+		opts := ReaderOpts{BufferSize: tc.In}
+		if got, want := opts.bufferSize(), tc.Want; got != want {
+			t.Errorf("%d: got: %d, want: %d", tc.In, got, want)
+		}
+	}
+
+	for _, c := range []Compression{KindGzip, KindZstd, KindZlib, KindNone} {
+		t.Run(c.String(), func(t *testing.T) {
+			rc, kind, err := DetectOpts(bytes.NewReader(compress(t, c)), ReaderOpts{BufferSize: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+}
+
+func TestGzipMultistream(t *testing.T) {
+	first, second := payload, bytes.ToUpper(payload)
+	var buf bytes.Buffer
+	for _, b := range [][]byte{first, second} {
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		rc, err := Reader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := append(bytes.Clone(first), second...); !bytes.Equal(got, want) {
+			t.Errorf("got %d bytes, want %d bytes", len(got), len(want))
+		}
+	})
+	t.Run("NoMultistream", func(t *testing.T) {
+		rc, _, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{NoMultistream: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := first; !bytes.Equal(got, want) {
+			t.Errorf("got %d bytes, want %d bytes", len(got), len(want))
+		}
+	})
+}
+
+func TestFallbackOnError(t *testing.T) {
+	// This is a gzip header with the FHCRC flag set, but an incorrect CRC.
+	in := []byte{
+		0x1F, 0x8B, 0x08, 0x02,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0xFF,
+		0xDE, 0xAD,
+	}
+	in = append(in, payload...)
+
+	t.Run("Default", func(t *testing.T) {
+		_, _, err := Detect(bytes.NewReader(in))
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{FallbackOnError: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Error("content mismatch")
+		}
+	})
+	t.Run("Valid", func(t *testing.T) {
+		for _, c := range []Compression{KindGzip, KindZstd, KindZlib, KindXz} {
+			t.Run(c.String(), func(t *testing.T) {
+				rc, kind, err := DetectOpts(bytes.NewReader(compress(t, c)), ReaderOpts{FallbackOnError: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rc.Close()
+				if got, want := kind, c; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				got, err := io.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Error("payload mismatch")
+				}
+			})
+		}
+	})
+}
+
+func TestZlibDict(t *testing.T) {
+	dict := []byte("the quick brown fox jumps over the lazy dog")
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevelDict(&buf, zlib.DefaultCompression, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	in := buf.Bytes()
+
+	t.Run("NoDict", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Dict", func(t *testing.T) {
+		rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{ZlibDict: dict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindZlib; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("WrongDict", func(t *testing.T) {
+		_, _, err := DetectOpts(bytes.NewReader(in), ReaderOpts{ZlibDict: []byte("wrong")})
+		if got, want := err, ErrZlibDict; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Provider", func(t *testing.T) {
+		other := []byte("lorem ipsum dolor sit amet")
+		dicts := map[uint32][]byte{
+			adler32.Checksum(dict):  dict,
+			adler32.Checksum(other): other,
+		}
+		for _, tc := range []struct {
+			Name     string
+			ZlibDict []byte
+			Provider func(uint32) ([]byte, error)
+			Err      bool
+		}{
+			{
+				Name: "Found",
+				Provider: func(id uint32) ([]byte, error) {
+					return dicts[id], nil
+				},
+			},
+			{
+				Name:     "Fallback",
+				ZlibDict: other,
+				Provider: func(id uint32) ([]byte, error) {
+					return dicts[id], nil
+				},
+			},
+			{
+				Name: "Wrong",
+				Provider: func(uint32) ([]byte, error) {
+					return other, nil
+				},
+				Err: true,
+			},
+			{
+				Name: "Missing",
+				Provider: func(uint32) ([]byte, error) {
+					return nil, nil
+				},
+				Err: true,
+			},
+			{
+				Name: "Error",
+				Provider: func(uint32) ([]byte, error) {
+					return nil, errors.New("no dictionaries")
+				},
+				Err: true,
+			},
+		} {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{
+					ZlibDict:         tc.ZlibDict,
+					ZlibDictProvider: tc.Provider,
+				})
+				if tc.Err {
+					if got, want := err, ErrZlibDict; !errors.Is(got, want) {
+						t.Errorf("got: %v, want: %v", got, want)
+					}
+					t.Log(err)
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rc.Close()
+				if got, want := kind, KindZlib; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				got, err := io.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Error("payload mismatch")
+				}
+			})
+		}
+	})
+	t.Run("Unused", func(t *testing.T) {
+		rc, kind, err := DetectOpts(bytes.NewReader(compress(t, KindZlib)), ReaderOpts{ZlibDict: dict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindZlib; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+}
+
+func TestRequireFullHeader(t *testing.T) {
+	for _, in := range []string{"a", "abc"} {
+		t.Run(fmt.Sprintf("%dByte", len(in)), func(t *testing.T) {
+			for _, require := range []bool{false, true} {
+				rc, kind, err := DetectOpts(strings.NewReader(in), ReaderOpts{RequireFullHeader: require})
+				switch {
+				case require && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF):
+					t.Errorf("require=%v: got: %v, want: short read error", require, err)
+				case !require && err != nil:
+					t.Errorf("require=%v: got: %v, want: <nil>", require, err)
+				}
+				if got, want := kind, KindNone; got != want {
+					t.Errorf("require=%v: got: %v, want: %v", require, got, want)
+				}
+				got, err := io.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(got), in; got != want {
+					t.Errorf("require=%v: got: %q, want: %q", require, got, want)
+				}
+				rc.Close()
+			}
+		})
+	}
+}
+
+func TestKnown(t *testing.T) {
+	for _, tc := range []struct {
+		Name  string
+		In    Compression
+		Known Compression
+		Want  []byte
+	}{
+		{Name: "Gzip", In: KindGzip, Known: KindGzip, Want: payload},
+		// Brotli can't be detected, so it's only decoded if known.
+		{Name: "Brotli", In: KindBrotli, Known: KindBrotli, Want: payload},
+		{Name: "None", In: KindGzip, Known: KindNone},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			in := compress(t, tc.In)
+			want := tc.Want
+			if want == nil {
+				want = in
+			}
+			rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{Known: &tc.Known})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, tc.Known; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+}
+
+func TestDetectorLogging(t *testing.T) {
+	var buf bytes.Buffer
+	l := zerolog.New(&buf).Level(zerolog.DebugLevel)
+	zlog.Set(&l)
+	t.Cleanup(func() { zlog.Set(&log.Logger) })
+
+	rc, kind, err := DetectContext(context.Background(), bytes.NewReader(compress(t, KindGzip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, want := kind, KindGzip; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	// There's one event for the stream, not one per detector.
+	type record struct {
+		Message     string  `json:"message"`
+		Compression string  `json:"compression"`
+		Confidence  float64 `json:"confidence"`
+		Peeked      int     `json:"peeked"`
+	}
+	var recs []record
+	dec := json.NewDecoder(&buf)
+	for {
+		var rec record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Message == "detected compression" {
+			recs = append(recs, rec)
+		}
+	}
+	if got, want := len(recs), 1; got != want {
+		t.Fatalf("got: %d records, want: %d", got, want)
+	}
+	rec := recs[0]
+	if got, want := rec.Compression, "gzip"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := rec.Confidence, KindGzip.Confidence(); got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if rec.Peeked == 0 {
+		t.Error("no bytes peeked")
+	}
+}
+
+// StallReader returns one byte, then blocks until "release" is closed.
+type stallReader struct {
+	sent    bool
+	release chan struct{}
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	if !s.sent {
+		s.sent = true
+		p[0] = 'x'
+		return 1, nil
+	}
+	<-s.release
+	return 0, io.EOF
+}
+
+func TestDetectTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	t.Run("Timeout", func(t *testing.T) {
+		r := &stallReader{release: make(chan struct{})}
+		t.Cleanup(func() { close(r.release) })
+
+		start := time.Now()
+		_, _, err := DetectOpts(r, ReaderOpts{DetectTimeout: timeout})
+		if got, want := err, ErrDetectTimeout; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if el := time.Since(start); el > 10*timeout {
+			t.Errorf("detection took %v", el)
+		}
+	})
+	t.Run("Context", func(t *testing.T) {
+		r := &stallReader{release: make(chan struct{})}
+		t.Cleanup(func() { close(r.release) })
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		opts := ReaderOpts{ctx: ctx, DetectTimeout: time.Hour}
+		_, _, err := detect(r, &opts)
+		if got, want := err, context.Canceled; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("OK", func(t *testing.T) {
+		rc, kind, err := DetectOpts(bytes.NewReader(compress(t, KindGzip)), ReaderOpts{DetectTimeout: timeout})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindGzip; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+// SlowReader returns at most 512 bytes per Read, after a delay.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	if len(p) > 512 {
+		p = p[:512]
+	}
+	return s.r.Read(p)
+}
+
+func TestTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	// Random bytes, so that the compressed stream takes many reads.
+	raw := make([]byte, 128*1024)
+	rand.New(rand.NewSource(0)).Read(raw)
+	var gz bytes.Buffer
+	w, err := Writer(&gz, KindGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ins := map[Compression][]byte{KindNone: raw, KindGzip: gz.Bytes()}
+
+	for _, c := range []Compression{KindNone, KindGzip} {
+		c := c
+		t.Run(c.String(), func(t *testing.T) {
+			r := &slowReader{r: bytes.NewReader(ins[c]), delay: time.Millisecond}
+			rc, kind, err := DetectOpts(r, ReaderOpts{Timeout: timeout})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			n, err := io.Copy(io.Discard, rc)
+			if got, want := err, ErrTimeout; !errors.Is(got, want) {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if n >= int64(len(raw)) {
+				t.Errorf("read all %d bytes", n)
+			}
+		})
+	}
+	t.Run("OK", func(t *testing.T) {
+		rc, _, err := DetectOpts(bytes.NewReader(ins[KindGzip]), ReaderOpts{Timeout: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, raw) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Context", func(t *testing.T) {
+		// The Context being done first is reported over the timeout.
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := ReaderOpts{ctx: ctx, Timeout: time.Hour}
+		rc, _, err := detect(bytes.NewReader(ins[KindGzip]), &opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		cancel()
+		_, err = io.Copy(io.Discard, rc)
+		if got, want := err, context.Canceled; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestZstdDicts(t *testing.T) {
+	// Build a dictionary from samples of random words.
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+	rng := rand.New(rand.NewSource(0))
+	sample := func() []byte {
+		var b bytes.Buffer
+		for b.Len() < 1024 {
+			b.WriteString(words[rng.Intn(len(words))])
+			b.WriteByte(' ')
+		}
+		return b.Bytes()
+	}
+	var samples [][]byte
+	for i := 0; i < 64; i++ {
+		samples = append(samples, sample())
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       0x1234,
+		Contents: samples,
+		History:  bytes.Join(samples[:8], nil),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := enc.EncodeAll(payload, nil)
+	enc.Close()
+
+	t.Run("Dict", func(t *testing.T) {
+		rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{ZstdDicts: [][]byte{dict}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Missing", func(t *testing.T) {
+		rc, _, err := Detect(bytes.NewReader(in))
+		if err == nil {
+			defer rc.Close()
+			_, err = io.ReadAll(rc)
+		}
+		if got, want := err, zstd.ErrUnknownDictionary; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestZstdMaxWindow(t *testing.T) {
+	// A frame declaring a 1 GiB window, like one produced by "zstd --long=30",
+	// holding a single raw block.
+	in := []byte{
+		0x28, 0xB5, 0x2F, 0xFD, // Magic.
+		0x00,             // Frame header descriptor: no content size, no checksum.
+		20 << 3,          // Window descriptor: 2^(10+20) bytes.
+		0x29, 0x00, 0x00, // Block header: last, raw, 5 bytes.
+		'h', 'e', 'l', 'l', 'o',
+	}
+	read := func(opts ReaderOpts) ([]byte, error) {
+		rc, kind, err := DetectOpts(bytes.NewReader(in), opts)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		return io.ReadAll(rc)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		_, err := read(ReaderOpts{})
+		if got, want := err, zstd.ErrWindowSizeExceeded; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Large", func(t *testing.T) {
+		got, err := read(ReaderOpts{ZstdMaxWindow: 1 << 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(got), "hello"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+	t.Run("Small", func(t *testing.T) {
+		_, err := read(ReaderOpts{ZstdMaxWindow: 1 << 20})
+		if got, want := err, zstd.ErrWindowSizeExceeded; !errors.Is(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestZstdConcurrency(t *testing.T) {
+	d, _, err := detect(bytes.NewReader(compress(t, KindZstd)), &ReaderOpts{ZstdConcurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	r := d.(*decoder).r
+	if c, ok := r.(*classifyReader); ok {
+		r = c.r
+	}
+	if zr, ok := r.(*zstdReader); !ok || !zr.unpooled {
+		t.Error("expected an unpooled zstd decoder")
+	}
+	got, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("payload mismatch")
+	}
+}
+
+func TestDrainOnClose(t *testing.T) {
+	open := func(t *testing.T, src io.Reader, opts ReaderOpts) {
+		t.Helper()
+		rc, _, err := DetectOpts(src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Abandon the stream early.
+		if _, err := io.ReadFull(rc, make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			// The compressed payloads are small enough to be consumed by
+			// detection, so add trailing bytes that are only read by a
+			// drain.
+			src := bytes.NewReader(append(compress(t, c), make([]byte, 64*1024)...))
+			open(t, src, ReaderOpts{DrainOnClose: 1 << 20})
+			if got, want := src.Len(), 0; got != want {
+				t.Errorf("got: %d bytes left, want: %d", got, want)
+			}
+		})
+	}
+	t.Run("Disabled", func(t *testing.T) {
+		src := bytes.NewReader(bytes.Repeat(payload, 16))
+		open(t, src, ReaderOpts{})
+		if src.Len() == 0 {
+			t.Error("source drained")
+		}
+	})
+	t.Run("Bound", func(t *testing.T) {
+		const max = 4096
+		in := bytes.Repeat(payload, 16)
+		src := bytes.NewReader(in)
+		open(t, src, ReaderOpts{DrainOnClose: max})
+		// At most one buffer's worth was read before the drain.
+		if got, want := len(in)-src.Len(), (&ReaderOpts{}).bufferSize()+max; got > want {
+			t.Errorf("got: %d bytes read, want at most: %d", got, want)
+		}
+		if src.Len() == 0 {
+			t.Error("source drained past the bound")
+		}
+	})
+	t.Run("Error", func(t *testing.T) {
+		want := errors.New("oops")
+		src := io.MultiReader(bytes.NewReader(payload), iotest.ErrReader(want))
+		rc, _, err := DetectOpts(src, ReaderOpts{DrainOnClose: 1 << 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Close(); !errors.Is(err, want) {
+			t.Errorf("got: %v, want: %v", err, want)
+		}
+	})
+}
+
+func TestOptionsCompose(t *testing.T) {
+	read := func(t *testing.T, in []byte, opts ReaderOpts, want Compression) ([]byte, error) {
+		t.Helper()
+		rc, kind, err := DetectOpts(bytes.NewReader(in), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := rc.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+		if got := kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		return io.ReadAll(rc)
+	}
+
+	t.Run("GzipMembersLimit", func(t *testing.T) {
+		in := append(compress(t, KindGzip), compress(t, KindGzip)...)
+		opts := ReaderOpts{
+			NoMultistream: true,
+			MaxSize:       int64(len(payload)),
+			BufferSize:    64 * 1024,
+			DrainOnClose:  1 << 20,
+		}
+		// Only the first member is read, so the limit isn't hit.
+		got, err := read(t, in, opts, KindGzip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+		// With both members, it is.
+		opts.NoMultistream = false
+		if _, err := read(t, in, opts, KindGzip); !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeLimit)
+		}
+	})
+	t.Run("ZstdLimit", func(t *testing.T) {
+		opts := ReaderOpts{
+			ZstdConcurrency: 1,
+			ZstdMaxWindow:   1 << 20,
+			MaxSize:         int64(len(payload) - 1),
+		}
+		if _, err := read(t, compress(t, KindZstd), opts, KindZstd); !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeLimit)
+		}
+		opts.MaxSize = 0
+		got, err := read(t, compress(t, KindZstd), opts, KindZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("FallbackLimit", func(t *testing.T) {
+		// A truncated gzip header, which the constructor rejects.
+		in := append(bytes.Clone(gzipHeader), 0x00, 0x00, 0x00)
+		opts := ReaderOpts{
+			FallbackOnError: true,
+			MaxSize:         3,
+		}
+		got, err := read(t, in, opts, KindNone)
+		if !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeLimit)
+		}
+		if want := in[:3]; !bytes.Equal(got, want) {
+			t.Errorf("got: %x, want: %x", got, want)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package zreader
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdPool holds idle *zstd.Decoder values.
+//
+// Decoders are Reset with a nil Reader before being returned to the pool, so
+// they hold no references to old streams and have no running goroutines.
+var zstdPool sync.Pool
+
+// GetZstd returns a *zstd.Decoder reading from "r", reusing a pooled Decoder
+// if possible.
+func getZstd(r io.Reader) (*zstd.Decoder, error) {
+	if z, ok := zstdPool.Get().(*zstd.Decoder); ok {
+		if err := z.Reset(r); err == nil {
+			return z, nil
+		}
+		// If the Reset failed, the Decoder is unusable. Drop it and make a
+		// new one.
+		z.Close()
+	}
+	return zstd.NewReader(r)
+}
+
+// PutZstd returns the Decoder to the pool.
+func putZstd(z *zstd.Decoder) {
+	// Reset with a nil Reader can't fail.
+	z.Reset(nil)
+	zstdPool.Put(z)
+}
+
+// NewZstdReader returns a zstdReader for "r" configured by "opts".
+//
+// Pooled decoders all have the default configuration, so a new, unpooled
+// decoder is constructed if "opts" has any zstd-specific settings.
+func newZstdReader(r io.Reader, opts *ReaderOpts) (*zstdReader, error) {
+	var dopts []zstd.DOption
+	if len(opts.ZstdDicts) != 0 {
+		dopts = append(dopts, zstd.WithDecoderDicts(opts.ZstdDicts...))
+	}
+	if opts.ZstdMaxWindow > 0 {
+		dopts = append(dopts, zstd.WithDecoderMaxWindow(uint64(opts.ZstdMaxWindow)))
+	}
+	if opts.ZstdConcurrency > 0 {
+		dopts = append(dopts, zstd.WithDecoderConcurrency(opts.ZstdConcurrency))
+	}
+	if len(dopts) == 0 {
+		z, err := getZstd(r)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{dec: z}, nil
+	}
+	z, err := zstd.NewReader(r, dopts...)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{dec: z, unpooled: true}, nil
+}
+
+// BufioPool holds idle *bufio.Reader values of the default size, for
+// [detect].
+//
+// Readers are Reset with a nil Reader before being returned to the pool, so
+// they hold no references to old streams.
+var bufioPool sync.Pool
+
+// GetBufio returns a *bufio.Reader of at least "sz" bytes reading from "r",
+// reusing a pooled Reader if possible.
+func getBufio(r io.Reader, sz int) *bufio.Reader {
+	if br, ok := bufioPool.Get().(*bufio.Reader); ok && br.Size() >= sz {
+		br.Reset(r)
+		return br
+	}
+	return bufio.NewReaderSize(r, sz)
+}
+
+// PutBufio returns the Reader to the pool.
+func putBufio(br *bufio.Reader) {
+	br.Reset(nil)
+	bufioPool.Put(br)
+}
+
+// ErrClosed is returned when reading from a pooled decoder after Close.
+var errClosed = errors.New("zreader: read after close")
+
+// ZstdReader is an [io.ReadCloser] that returns its Decoder to the pool on
+// Close, if it came from the pool.
+type zstdReader struct {
+	once sync.Once
+	dec  *zstd.Decoder
+	// Unpooled is set if the Decoder has a non-default configuration and
+	// should be closed instead of returned to the pool.
+	unpooled bool
+}
+
+// Read implements [io.Reader].
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.dec == nil {
+		return 0, errClosed
+	}
+	return z.dec.Read(p)
+}
+
+// WriteTo implements [io.WriterTo].
+func (z *zstdReader) WriteTo(w io.Writer) (int64, error) {
+	if z.dec == nil {
+		return 0, errClosed
+	}
+	return z.dec.WriteTo(w)
+}
+
+// Close implements [io.Closer].
+//
+// The Decoder is returned to the pool exactly once, no matter how many times
+// Close is called.
+func (z *zstdReader) Close() error {
+	z.once.Do(func() {
+		if z.unpooled {
+			z.dec.Close()
+		} else {
+			putZstd(z.dec)
+		}
+		z.dec = nil
+	})
+	return nil
+}
@@ -0,0 +1,84 @@
+package zreader
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdPool(t *testing.T) {
+	other := bytes.ToUpper(payload)
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		In   []byte
+		Want []byte
+	}{
+		{In: compress(t, KindZstd), Want: payload},
+		{In: buf.Bytes(), Want: other},
+		{In: compress(t, KindZstd), Want: payload},
+	} {
+		rc, err := Reader(bytes.NewReader(tc.In))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.Equal(got, tc.Want) {
+			t.Errorf("%d: payload mismatch", i)
+		}
+		if err := rc.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := rc.Close(); err != nil {
+			t.Error(err)
+		}
+		if _, err := rc.Read(make([]byte, 1)); err == nil {
+			t.Error("expected error reading after close")
+		}
+	}
+}
+
+func BenchmarkZstd(b *testing.B) {
+	in := compress(b, KindZstd)
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rc, err := Reader(bytes.NewReader(in))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				b.Fatal(err)
+			}
+			rc.Close()
+		}
+	})
+	b.Run("Unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			z, err := zstd.NewReader(bytes.NewReader(in))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, z); err != nil {
+				b.Fatal(err)
+			}
+			z.Close()
+		}
+	})
+}
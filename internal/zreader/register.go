@@ -0,0 +1,160 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxMaskSize is the longest mask accepted by [RegisterDetector], and the
+// furthest into a stream a mask registered with [RegisterDetectorAt] may
+// end.
+const MaxMaskSize = 1024
+
+// ErrInvalidDetector is returned by [RegisterDetector] when the detector can't
+// be registered.
+var ErrInvalidDetector = errors.New("zreader: invalid detector")
+
+// Registry holds detectors added by RegisterDetector and RegisterDecoder. The
+// Compression for the detector at index i is KindNone+1+i.
+//
+// A registry is never modified once published; registering makes a copy.
+type registry struct {
+	names     []string
+	detectors []detector
+	// Decoders has an entry for every detector; it's nil if the scheme has
+	// no decoder.
+	decoders []func(io.Reader) (io.ReadCloser, error)
+	// MaxSz is the furthest end of a mask of any detector, built-in or
+	// registered.
+	maxSz int
+}
+
+var (
+	// RegisterMu serializes registration.
+	registerMu sync.Mutex
+	// Registered is the current registry.
+	registered atomic.Pointer[registry]
+)
+
+// Reg returns the current registry.
+func reg() *registry {
+	if r := registered.Load(); r != nil {
+		return r
+	}
+	return &registry{maxSz: builtinSz}
+}
+
+// PeekSz is the number of bytes needed to check all compression headers.
+func peekSz() int {
+	return reg().maxSz
+}
+
+// RegisterDetector adds a detector for a scheme not built into this package,
+// returning a new Compression value for it.
+//
+// The "check" function is passed the first len(mask) bytes of the stream,
+// ANDed pairwise with "mask", and reports whether they're the header for the
+// scheme. Registered detectors are run after the built-in ones, in the order
+// they were registered.
+//
+// This package has no decoder for a scheme registered this way, so matching
+// streams are reported with the returned Compression but are otherwise passed
+// through unmodified, the same as [KindNone]. Use [RegisterDecoder] to also
+// provide a decoder. [Compression.String] does not know about registered
+// names, but [Compression.MarshalText] and [ParseCompression] do.
+//
+// The name must be non-empty, lower case, and not the name of another scheme.
+// The mask must be non-empty and at most [MaxMaskSize] bytes.
+//
+// RegisterDetector is meant to be called from an init func, but is safe to
+// call concurrently with any other function in this package. Detection that's
+// already started does not see the new scheme.
+func RegisterDetector(name string, mask []byte, check func([]byte) bool) (Compression, error) {
+	return register(name, 0, mask, check, nil)
+}
+
+// RegisterDetectorAt is like [RegisterDetector], but for formats whose magic
+// isn't at the start of the stream. The "check" function is passed the
+// len(mask) bytes starting at "offset", ANDed pairwise with "mask".
+//
+// The offset must not be negative, and offset+len(mask) must be at most
+// [MaxMaskSize]. Detection reads enough of every stream to cover the mask.
+func RegisterDetectorAt(name string, offset int, mask []byte, check func([]byte) bool) (Compression, error) {
+	return register(name, offset, mask, check, nil)
+}
+
+// RegisterDecoder is like [RegisterDetector], but also registers a function
+// to construct a decoder for the scheme. Streams detected as the returned
+// Compression are decoded with a ReadCloser returned by "open", which is
+// closed along with the Reader returned by this package.
+//
+// Limits and other options in [ReaderOpts] are applied to registered decoders
+// the same as the built-in ones.
+func RegisterDecoder(name string, mask []byte, check func([]byte) bool, open func(io.Reader) (io.ReadCloser, error)) (Compression, error) {
+	if open == nil {
+		return KindNone, fmt.Errorf("%w: %q: nil decoder", ErrInvalidDetector, name)
+	}
+	return register(name, 0, mask, check, open)
+}
+
+// Register implements RegisterDetector, RegisterDetectorAt, and
+// RegisterDecoder.
+func register(name string, offset int, mask []byte, check func([]byte) bool, open func(io.Reader) (io.ReadCloser, error)) (Compression, error) {
+	switch {
+	case name == "" || name != strings.ToLower(strings.TrimSpace(name)):
+		return KindNone, fmt.Errorf("%w: bad name %q", ErrInvalidDetector, name)
+	case len(mask) == 0:
+		return KindNone, fmt.Errorf("%w: %q: empty mask", ErrInvalidDetector, name)
+	case offset < 0:
+		return KindNone, fmt.Errorf("%w: %q: negative offset", ErrInvalidDetector, name)
+	case offset+len(mask) > MaxMaskSize:
+		return KindNone, fmt.Errorf("%w: %q: mask too long (%d > %d)", ErrInvalidDetector, name, offset+len(mask), MaxMaskSize)
+	case check == nil:
+		return KindNone, fmt.Errorf("%w: %q: nil check", ErrInvalidDetector, name)
+	}
+
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	// This catches built-in names, aliases, and registered names.
+	if _, err := ParseCompression(name); err == nil {
+		return KindNone, fmt.Errorf("%w: %q: duplicate name", ErrInvalidDetector, name)
+	}
+	d := detector{
+		Offset:     offset,
+		Mask:       bytes.Clone(mask),
+		Check:      check,
+		Confidence: 1,
+	}
+	d.identity = bytes.Count(d.Mask, []byte{0xFF}) == len(d.Mask)
+	cur := reg()
+	next := &registry{
+		names:     append(cur.names[:len(cur.names):len(cur.names)], name),
+		detectors: append(cur.detectors[:len(cur.detectors):len(cur.detectors)], d),
+		decoders:  append(cur.decoders[:len(cur.decoders):len(cur.decoders)], open),
+		maxSz:     cur.maxSz,
+	}
+	if l := offset + len(mask); l > next.maxSz {
+		next.maxSz = l
+	}
+	registered.Store(next)
+	return KindNone + Compression(len(next.detectors)), nil
+}
+
+// Registered reports whether the Compression was returned by
+// [RegisterDetector] or [RegisterDecoder].
+func (c Compression) Registered() bool {
+	return c > KindNone && int(c-KindNone) <= len(reg().detectors)
+}
+
+// Name is like String, but also knows the names of registered detectors.
+func (c Compression) name() string {
+	if r := reg(); c > KindNone && int(c-KindNone) <= len(r.detectors) {
+		return r.names[c-KindNone-1]
+	}
+	return c.String()
+}
@@ -0,0 +1,245 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRegisterDetector(t *testing.T) {
+	magic := []byte{'A', 'C', 'M', 'E'}
+	kind, err := RegisterDetector("acme", bytes.Repeat([]byte{0xFF}, len(magic)), func(b []byte) bool {
+		return bytes.Equal(b, magic)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kind; got <= KindNone {
+		t.Errorf("got: %v, want: value after %v", got, KindNone)
+	}
+
+	t.Run("Detect", func(t *testing.T) {
+		in := append(bytes.Clone(magic), payload...)
+		rc, got, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if want := kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, in) {
+			t.Error("stream modified")
+		}
+	})
+	t.Run("BuiltinsFirst", func(t *testing.T) {
+		if got, want := DetectBytes(compress(t, KindGzip)), KindGzip; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Names", func(t *testing.T) {
+		b, err := kind.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "acme"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+		got, err := ParseCompression("ACME")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		check := func([]byte) bool { return false }
+		tt := []struct {
+			Name string
+			Mask []byte
+		}{
+			{Name: "acme", Mask: []byte{0xFF}},
+			{Name: "gzip", Mask: []byte{0xFF}},
+			{Name: "gz", Mask: []byte{0xFF}},
+			{Name: "Upper", Mask: []byte{0xFF}},
+			{Name: "", Mask: []byte{0xFF}},
+			{Name: "empty", Mask: nil},
+			{Name: "huge", Mask: make([]byte, MaxMaskSize+1)},
+		}
+		for _, tc := range tt {
+			if _, err := RegisterDetector(tc.Name, tc.Mask, check); !errors.Is(err, ErrInvalidDetector) {
+				t.Errorf("%q: got: %v, want: %v", tc.Name, err, ErrInvalidDetector)
+			}
+		}
+	})
+}
+
+// XorReader undoes a toy "compression" that XORs every byte with 0x5A.
+type xorReader struct {
+	io.Reader
+	closed bool
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+	n, err := x.Reader.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0x5A
+	}
+	return n, err
+}
+
+func (x *xorReader) Close() error {
+	x.closed = true
+	return nil
+}
+
+func TestRegisterDetectorAt(t *testing.T) {
+	const offset = 600
+	magic := []byte("OFFS")
+	kind, err := RegisterDetectorAt("offs", offset, bytes.Repeat([]byte{0xFF}, len(magic)), func(b []byte) bool {
+		return bytes.Equal(b, magic)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := peekSz(), offset+len(magic); got < want {
+		t.Errorf("peek size: got: %d, want: >= %d", got, want)
+	}
+	in := append(append(bytes.Repeat([]byte{'x'}, offset), magic...), payload...)
+
+	t.Run("Detect", func(t *testing.T) {
+		rc, got, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if want := kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, in) {
+			t.Error("stream modified")
+		}
+	})
+	t.Run("AtStart", func(t *testing.T) {
+		if got := DetectBytes(append(bytes.Clone(magic), payload...)); got == kind {
+			t.Errorf("got: %v, want: not %v", got, kind)
+		}
+	})
+	t.Run("Short", func(t *testing.T) {
+		got, ok := Classify(in[:offset+len(magic)-1])
+		if got == kind || ok {
+			t.Errorf("got: %v, %v, want: not %v, false", got, ok, kind)
+		}
+		if got, ok := Classify(in[:offset+len(magic)]); got != kind || !ok {
+			t.Errorf("got: %v, %v, want: %v, true", got, ok, kind)
+		}
+	})
+	t.Run("HeaderMask", func(t *testing.T) {
+		want := append(make([]byte, offset), bytes.Repeat([]byte{0xFF}, len(magic))...)
+		if got := HeaderMask(kind); !bytes.Equal(got, want) {
+			t.Errorf("got: %x, want: %x", got, want)
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		check := func([]byte) bool { return false }
+		for _, tc := range []struct {
+			Name   string
+			Offset int
+		}{
+			{Name: "negative", Offset: -1},
+			{Name: "far", Offset: MaxMaskSize},
+		} {
+			if _, err := RegisterDetectorAt(tc.Name, tc.Offset, []byte{0xFF}, check); !errors.Is(err, ErrInvalidDetector) {
+				t.Errorf("%q: got: %v, want: %v", tc.Name, err, ErrInvalidDetector)
+			}
+		}
+	})
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	magic := []byte{'X', 'O', 'R', 'Z'}
+	var last *xorReader
+	open := func(r io.Reader) (io.ReadCloser, error) {
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		last = &xorReader{Reader: r}
+		return last, nil
+	}
+	kind, err := RegisterDecoder("xorz", bytes.Repeat([]byte{0xFF}, len(magic)), func(b []byte) bool {
+		return bytes.Equal(b, magic)
+	}, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kind.Registered() {
+		t.Errorf("%v not registered", kind)
+	}
+	in := bytes.Clone(magic)
+	for _, b := range payload {
+		in = append(in, b^0x5A)
+	}
+
+	rc, got, err := Detect(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := kind; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, payload) {
+		t.Error("stream not decoded")
+	}
+	if err := rc.Close(); err != nil {
+		t.Error(err)
+	}
+	if !last.closed {
+		t.Error("decoder not closed")
+	}
+
+	if _, err := RegisterDecoder("nilopen", []byte{0xFF}, func([]byte) bool { return false }, nil); !errors.Is(err, ErrInvalidDetector) {
+		t.Errorf("got: %v, want: %v", err, ErrInvalidDetector)
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	// Run with the race detector to be useful.
+	gz := compress(t, KindGzip)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			name := "concurrent" + string(rune('a'+i))
+			if _, err := RegisterDetector(name, make([]byte, 300+i), func([]byte) bool { return false }); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		rc, c, err := Detect(bytes.NewReader(gz))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != KindGzip {
+			t.Errorf("got: %v, want: %v", c, KindGzip)
+		}
+		rc.Close()
+		SupportedKinds()
+	}
+	<-done
+}
@@ -0,0 +1,225 @@
+package zreader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// ReusableReader is a decompressing [io.ReadCloser] that can be pointed at a
+// new source with Reset, reusing its buffer and decoders where the scheme
+// allows it.
+//
+// The gzip, zstd, zlib, and lz4 decoders are reused. Other schemes construct a
+// new decoder on every Reset.
+//
+// The zero value is ready to use once Reset is called. A ReusableReader is not
+// safe for concurrent use.
+type ReusableReader struct {
+	br  *bufio.Reader
+	cur io.Reader
+	// Under is the decoder backing "cur", as reported by Underlying.
+	under any
+	// Err is returned by Read if the last Reset failed.
+	err error
+	// Checked and empty record the result of Empty.
+	checked, empty bool
+	// Gzflags is the FLG byte of the current gzip source, if gzok is set.
+	gzflags GzipFlag
+	gzok    bool
+
+	gzip *gzip.Reader
+	zstd *zstd.Decoder
+	zlib io.ReadCloser
+	lz4  *lz4.Reader
+	// Dec is the decoder constructed for a scheme that isn't reused, closed
+	// on the next Reset or Close.
+	dec *decoder
+}
+
+var _ Decoder = (*ReusableReader)(nil)
+
+// Reset detects the compression scheme of "r" and prepares to read the
+// decompressed contents, discarding any state from the previous source.
+//
+// Detection is the same as [Detect]. If Reset returns an error, Read returns
+// the same error until the next successful Reset.
+func (z *ReusableReader) Reset(r io.Reader) (Compression, error) {
+	if r == nil {
+		r = emptyReader{}
+	}
+	if z.br == nil {
+		z.br = bufio.NewReaderSize(r, (&ReaderOpts{}).bufferSize())
+	} else {
+		z.br.Reset(r)
+	}
+	z.cur, z.under = nil, nil
+	z.checked, z.empty = false, false
+	if z.dec != nil {
+		z.dec.Close()
+		z.dec = nil
+	}
+
+	var c Compression
+	b, err := z.br.Peek(peekSz())
+	switch {
+	case errors.Is(err, nil):
+		c = detectCompression(b)
+	case errors.Is(err, io.ErrNoProgress):
+		c = KindNone
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		// See detect.
+		c = detectCompression(b)
+	default:
+		z.err = err
+		return KindNone, err
+	}
+	z.gzflags, z.gzok = 0, false
+	if c == KindGzip {
+		z.gzflags, z.gzok = parseGzipFlags(b)
+	}
+	if err := z.reset(c); err != nil {
+		z.cur, z.under = nil, nil
+		z.err = err
+		return KindNone, err
+	}
+	z.err = nil
+	return c, nil
+}
+
+// Reset (unexported) arranges for "cur" to decode the buffered source with the
+// scheme "c".
+func (z *ReusableReader) reset(c Compression) error {
+	switch c {
+	case KindGzip:
+		if z.gzip == nil {
+			g, err := gzip.NewReader(z.br)
+			if err != nil {
+				return err
+			}
+			z.gzip = g
+		} else if err := z.gzip.Reset(z.br); err != nil {
+			return err
+		}
+		z.gzip.Multistream(true)
+		z.cur, z.under = z.gzip, z.gzip
+	case KindZstd:
+		if z.zstd == nil {
+			d, err := getZstd(z.br)
+			if err != nil {
+				return err
+			}
+			z.zstd = d
+		} else if err := z.zstd.Reset(z.br); err != nil {
+			return err
+		}
+		z.cur, z.under = z.zstd, z.zstd
+	case KindZlib:
+		if z.zlib == nil {
+			d, err := zlib.NewReader(z.br)
+			if err != nil {
+				return err
+			}
+			z.zlib = d
+		} else if err := z.zlib.(zlib.Resetter).Reset(z.br, nil); err != nil {
+			return err
+		}
+		z.cur, z.under = z.zlib, z.zlib
+	case KindLz4:
+		if z.lz4 == nil {
+			z.lz4 = lz4.NewReader(z.br)
+		} else {
+			z.lz4.Reset(z.br)
+		}
+		z.cur, z.under = z.lz4, z.lz4
+	case KindBzip2, KindXz, KindSnappy, KindLzma, KindDeflate:
+		return z.fresh(c)
+	case KindTar, KindNone:
+		z.cur = z.br
+	default:
+		if c.Registered() {
+			return z.fresh(c)
+		}
+		return fmt.Errorf("zreader: unknown compression type %v", c)
+	}
+	return nil
+}
+
+// Fresh arranges for "cur" to be a new decoder for the scheme "c", for
+// schemes whose decoders can't be reused.
+func (z *ReusableReader) fresh(c Compression) error {
+	d, err := newReader(z.br, c, &ReaderOpts{})
+	if err != nil {
+		return err
+	}
+	z.cur, z.under, z.dec = d, d.Underlying(), d
+	return nil
+}
+
+// Read implements [io.Reader].
+func (z *ReusableReader) Read(p []byte) (int, error) {
+	switch {
+	case z.err != nil:
+		return 0, z.err
+	case z.cur == nil:
+		return 0, io.EOF
+	}
+	return z.cur.Read(p)
+}
+
+// Empty implements [Decoder].
+func (z *ReusableReader) Empty() bool {
+	if z.err != nil || z.cur == nil {
+		return false
+	}
+	if !z.checked {
+		z.checked = true
+		z.cur, z.empty = peekEmpty(z.cur)
+	}
+	return z.empty
+}
+
+// Underlying implements [Decoder].
+//
+// The reported value is only valid until the next call to Reset or Close.
+func (z *ReusableReader) Underlying() any {
+	return z.under
+}
+
+// GzipFlags implements [Decoder].
+func (z *ReusableReader) gzipFlags() (GzipFlag, bool) {
+	return z.gzflags, z.gzok
+}
+
+// Close implements [io.Closer].
+//
+// Close releases the pooled decoders. The ReusableReader may be used again by
+// calling Reset.
+func (z *ReusableReader) Close() error {
+	var err error
+	if z.gzip != nil {
+		err = z.gzip.Close()
+		z.gzip = nil
+	}
+	if z.zstd != nil {
+		putZstd(z.zstd)
+		z.zstd = nil
+	}
+	z.zlib, z.lz4 = nil, nil
+	if z.dec != nil {
+		err = errors.Join(err, z.dec.Close())
+		z.dec = nil
+	}
+	if z.br != nil {
+		z.br.Reset(bytes.NewReader(nil))
+	}
+	z.cur, z.under = nil, nil
+	return err
+}
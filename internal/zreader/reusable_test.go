@@ -0,0 +1,155 @@
+package zreader
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestReusableReader(t *testing.T) {
+	order := []Compression{
+		KindGzip, KindZstd, KindNone, KindGzip, KindBzip2, KindZstd,
+		KindZlib, KindXz, KindLz4, KindZlib, KindGzip, KindLz4, KindNone,
+	}
+	var z ReusableReader
+	defer z.Close()
+	for i, c := range order {
+		kind, err := z.Reset(bytes.NewReader(compress(t, c)))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got, want := kind, c; got != want {
+			t.Errorf("%d: got: %v, want: %v", i, got, want)
+		}
+		got, err := io.ReadAll(&z)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%d: payload mismatch", i)
+		}
+		if i == 6 {
+			// Make sure the Reader is usable after Close.
+			if err := z.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		// Truncate the gzip header.
+		in := compress(t, KindGzip)[:8]
+		var z ReusableReader
+		defer z.Close()
+		if _, err := z.Reset(bytes.NewReader(in)); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := z.Read(make([]byte, 1)); err == nil {
+			t.Error("expected error")
+		}
+		if _, err := z.Reset(bytes.NewReader(compress(t, KindGzip))); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(&z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+}
+
+func TestReusableReaderRegistered(t *testing.T) {
+	magic := []byte{'R', 'X', 'O', 'R'}
+	var last *xorReader
+	kind, err := RegisterDecoder("reusable-xor", bytes.Repeat([]byte{0xFF}, len(magic)), func(b []byte) bool {
+		return bytes.Equal(b, magic)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		last = &xorReader{Reader: r}
+		return last, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := bytes.Clone(magic)
+	for _, b := range payload {
+		in = append(in, b^0x5A)
+	}
+
+	var z ReusableReader
+	defer z.Close()
+	for i, src := range [][]byte{compress(t, KindGzip), in, compress(t, KindZstd), in} {
+		c, err := z.Reset(bytes.NewReader(src))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		prev := last
+		got, err := io.ReadAll(&z)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%d: payload mismatch", i)
+		}
+		if c == kind {
+			if _, err := z.Reset(bytes.NewReader(nil)); err != nil {
+				t.Fatal(err)
+			}
+			if !prev.closed {
+				t.Errorf("%d: decoder not closed on Reset", i)
+			}
+		}
+	}
+}
+
+func BenchmarkReusableReader(b *testing.B) {
+	blobs := make([][]byte, 10000)
+	for i := range blobs {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(payload[:64+i%64])
+		w.Close()
+		blobs[i] = buf.Bytes()
+	}
+	var r bytes.Reader
+
+	b.Run("Detect", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, blob := range blobs {
+				r.Reset(blob)
+				rc, _, err := Detect(&r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, rc); err != nil {
+					b.Fatal(err)
+				}
+				rc.Close()
+			}
+		}
+	})
+	b.Run("Reset", func(b *testing.B) {
+		b.ReportAllocs()
+		var z ReusableReader
+		defer z.Close()
+		for i := 0; i < b.N; i++ {
+			for _, blob := range blobs {
+				r.Reset(blob)
+				if _, err := z.Reset(&r); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, &z); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
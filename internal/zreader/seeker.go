@@ -0,0 +1,227 @@
+package zreader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSeekMemory is the number of decompressed bytes a seeker returned by
+// [NewSeeker] keeps in memory before spilling to a temporary file.
+const DefaultSeekMemory = 16 * 1024 * 1024
+
+// NewSeeker returns an [io.ReadSeekCloser] over the decompressed contents of
+// "r", which is "size" bytes long.
+//
+// Uncompressed sources are read directly from "r". None of the supported
+// compression schemes allow random access, so for compressed sources the
+// decompressed bytes are cached as they're read: seeking backward reads from
+// the cache, and seeking forward decompresses up to the new offset. Seeking
+// relative to the end decompresses the entire stream.
+//
+// The cache is kept in memory until it grows past [DefaultSeekMemory], then
+// moves to a temporary file that's removed on Close. This means reading the
+// whole stream costs as much disk space as the decompressed size. See
+// [NewSeekerOpts] to change the threshold.
+//
+// Zstd streams in the seekable format are the exception: the seek table is
+// used to decode only the frame containing the current offset, so nothing is
+// cached and seeking relative to the end is free. If the seek table is
+// malformed or doesn't describe the stream, the stream is handled like any
+// other zstd stream.
+func NewSeeker(r io.ReaderAt, size int64) (io.ReadSeekCloser, Compression, error) {
+	return NewSeekerOpts(r, size, ReaderOpts{})
+}
+
+// NewSeekerOpts follows the same procedure as [NewSeeker], with the behavior
+// modified by the passed [ReaderOpts]. The options are only used for compressed
+// sources.
+func NewSeekerOpts(r io.ReaderAt, size int64, opts ReaderOpts) (io.ReadSeekCloser, Compression, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	c, err := DetectAt(sr)
+	if err != nil {
+		return nil, KindNone, err
+	}
+	switch c {
+	case KindNone, KindTar:
+		return nopSeekCloser{sr}, c, nil
+	}
+	if c == KindZstd {
+		if frames, err := readSeekTable(sr, size); err == nil && frames != nil {
+			s, err := newZstdSeeker(sr, frames, &opts)
+			if err != nil {
+				return nil, c, err
+			}
+			return s, c, nil
+		}
+	}
+	d, c, err := detect(sr, &opts)
+	if err != nil {
+		return nil, c, err
+	}
+	mem := opts.SeekMemory
+	if mem <= 0 {
+		mem = DefaultSeekMemory
+	}
+	return &seeker{dec: d, cache: spillBuffer{max: mem}}, c, nil
+}
+
+// NopSeekCloser is an [io.ReadSeeker] with a no-op Close method.
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+// Close implements [io.Closer].
+func (nopSeekCloser) Close() error { return nil }
+
+// ErrNegativeOffset is returned when seeking to before the start of the
+// stream.
+var ErrNegativeOffset = errors.New("zreader: seek to negative offset")
+
+// Seeker implements seeking over a [Decoder] by caching everything it has
+// decompressed.
+type seeker struct {
+	dec   Decoder
+	cache spillBuffer
+	pos   int64
+	// Err is the error returned by the decoder, if any. Reads past the end of
+	// the cache return it.
+	err error
+}
+
+var _ io.ReadSeekCloser = (*seeker)(nil)
+
+// Fill decompresses into the cache until it contains at least "n" bytes or
+// the decoder returns an error.
+func (s *seeker) fill(n int64) {
+	var buf [32 * 1024]byte
+	for s.err == nil && s.cache.Len() < n {
+		ct, err := s.dec.Read(buf[:])
+		if ct > 0 {
+			if _, werr := s.cache.Write(buf[:ct]); werr != nil {
+				err = werr
+			}
+		}
+		s.err = err
+	}
+}
+
+// Read implements [io.Reader].
+func (s *seeker) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	s.fill(s.pos + int64(len(p)))
+	if s.pos >= s.cache.Len() {
+		return 0, s.err
+	}
+	n, err := s.cache.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if errors.Is(err, io.EOF) {
+		// Short read from the cache; the caller will see the decoder's error
+		// on the next Read.
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements [io.Seeker].
+func (s *seeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = s.pos + offset
+	case io.SeekEnd:
+		for s.err == nil {
+			s.fill(s.cache.Len() + 1)
+		}
+		if !errors.Is(s.err, io.EOF) {
+			return s.pos, s.err
+		}
+		abs = s.cache.Len() + offset
+	default:
+		return s.pos, fmt.Errorf("zreader: invalid whence: %d", whence)
+	}
+	if abs < 0 {
+		return s.pos, ErrNegativeOffset
+	}
+	s.pos = abs
+	return abs, nil
+}
+
+// Close implements [io.Closer].
+func (s *seeker) Close() error {
+	return errors.Join(s.dec.Close(), s.cache.Close())
+}
+
+// SpillBuffer is an append-only buffer that moves to a temporary file once it
+// holds more than "max" bytes.
+type spillBuffer struct {
+	mem []byte
+	f   *os.File
+	n   int64
+	max int64
+}
+
+// Len reports the number of bytes written.
+func (b *spillBuffer) Len() int64 {
+	return b.n
+}
+
+// Write implements [io.Writer].
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.f == nil && b.n+int64(len(p)) > b.max {
+		f, err := os.CreateTemp("", "zreader.seek.")
+		if err != nil {
+			return 0, err
+		}
+		b.f = f
+		if _, err := b.f.Write(b.mem); err != nil {
+			return 0, err
+		}
+		b.mem = nil
+	}
+	if b.f == nil {
+		b.mem = append(b.mem, p...)
+		b.n += int64(len(p))
+		return len(p), nil
+	}
+	n, err := b.f.WriteAt(p, b.n)
+	b.n += int64(n)
+	return n, err
+}
+
+// ReadAt implements [io.ReaderAt].
+func (b *spillBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.n {
+		return 0, io.EOF
+	}
+	var err error
+	if rem := b.n - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	if b.f != nil {
+		n, ferr := b.f.ReadAt(p, off)
+		if ferr != nil {
+			err = ferr
+		}
+		return n, err
+	}
+	return copy(p, b.mem[off:]), err
+}
+
+// Close releases the buffer's resources, removing the temporary file if one
+// was created.
+func (b *spillBuffer) Close() error {
+	b.mem = nil
+	if b.f == nil {
+		return nil
+	}
+	f := b.f
+	b.f = nil
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
@@ -0,0 +1,230 @@
+package zreader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSeeker(t *testing.T) {
+	check := func(t *testing.T, s io.ReadSeeker, off int64, whence int, want int64) {
+		t.Helper()
+		pos, err := s.Seek(off, whence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pos; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+		b := make([]byte, 64)
+		n, err := io.ReadFull(s, b)
+		switch {
+		case errors.Is(err, nil):
+		case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		default:
+			t.Fatal(err)
+		}
+		end := want + int64(n)
+		if got, want := b[:n], payload[want:end]; !bytes.Equal(got, want) {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	}
+	run := func(t *testing.T, in []byte, opts ReaderOpts, want Compression) {
+		s, kind, err := NewSeekerOpts(bytes.NewReader(in), int64(len(in)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := s.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+		if got := kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		sz := int64(len(payload))
+		check(t, s, 1000, io.SeekStart, 1000)
+		check(t, s, 100, io.SeekCurrent, 1164)
+		check(t, s, 10, io.SeekStart, 10)
+		check(t, s, -64, io.SeekEnd, sz-64)
+		check(t, s, 0, io.SeekStart, 0)
+		check(t, s, sz/2, io.SeekCurrent, sz/2+64)
+
+		if _, err := s.Seek(-1, io.SeekStart); err == nil {
+			t.Error("expected error for negative offset")
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	}
+
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			run(t, compress(t, c), ReaderOpts{}, c)
+		})
+	}
+	t.Run("Spill", func(t *testing.T) {
+		run(t, compress(t, KindGzip), ReaderOpts{SeekMemory: 4096}, KindGzip)
+	})
+	t.Run("Seekable", func(t *testing.T) {
+		run(t, seekable(t, 1000, false), ReaderOpts{}, KindZstd)
+	})
+}
+
+// Seekable returns "payload" compressed in the zstd seekable format, with
+// frames of "frame" decompressed bytes.
+func seekable(t testing.TB, frame int, sums bool) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var out, tbl []byte
+	n := 0
+	for off := 0; off < len(payload); off += frame {
+		end := off + frame
+		if end > len(payload) {
+			end = len(payload)
+		}
+		sz := len(out)
+		out = enc.EncodeAll(payload[off:end], out)
+		tbl = binary.LittleEndian.AppendUint32(tbl, uint32(len(out)-sz))
+		tbl = binary.LittleEndian.AppendUint32(tbl, uint32(end-off))
+		if sums {
+			// Not checked, so any value will do.
+			tbl = binary.LittleEndian.AppendUint32(tbl, 0)
+		}
+		n++
+	}
+	var desc byte
+	if sums {
+		desc = 0x80
+	}
+	tbl = binary.LittleEndian.AppendUint32(tbl, uint32(n))
+	tbl = append(tbl, desc)
+	tbl = binary.LittleEndian.AppendUint32(tbl, zstdSeekableMagic)
+	out = binary.LittleEndian.AppendUint32(out, zstdSeekTableMagic)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(tbl)))
+	return append(out, tbl...)
+}
+
+func TestZstdSeekable(t *testing.T) {
+	open := func(t *testing.T, in []byte) io.ReadSeekCloser {
+		t.Helper()
+		s, kind, err := NewSeeker(bytes.NewReader(in), int64(len(in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		return s
+	}
+
+	for _, sums := range []bool{false, true} {
+		name := "NoChecksum"
+		if sums {
+			name = "Checksum"
+		}
+		t.Run(name, func(t *testing.T) {
+			s := open(t, seekable(t, 1000, sums))
+			if _, ok := s.(*zstdSeeker); !ok {
+				t.Fatalf("got: %T, want: %T", s, (*zstdSeeker)(nil))
+			}
+			rng := rand.New(rand.NewSource(1))
+			b := make([]byte, 3000)
+			for i := 0; i < 200; i++ {
+				off := rng.Int63n(int64(len(payload)))
+				pos, err := s.Seek(off, io.SeekStart)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := pos, off; got != want {
+					t.Fatalf("got: %d, want: %d", got, want)
+				}
+				n, err := io.ReadFull(s, b[:rng.Intn(len(b))+1])
+				switch {
+				case errors.Is(err, nil):
+				case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+				default:
+					t.Fatal(err)
+				}
+				if got, want := b[:n], payload[off:off+int64(n)]; !bytes.Equal(got, want) {
+					t.Fatalf("at %d: got: %q, want: %q", off, got, want)
+				}
+			}
+			end, err := s.Seek(0, io.SeekEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := end, int64(len(payload)); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if n, err := s.Read(b); n != 0 || !errors.Is(err, io.EOF) {
+				t.Errorf("got: %d, %v, want: 0, %v", n, err, io.EOF)
+			}
+		})
+	}
+	t.Run("BadTable", func(t *testing.T) {
+		in := seekable(t, 1000, false)
+		// Make the first entry's compressed size disagree with the stream.
+		tbl := len(in) - zstdSeekFooterSz - 8*((len(payload)+999)/1000)
+		binary.LittleEndian.PutUint32(in[tbl:], 1)
+		s := open(t, in)
+		if _, ok := s.(*zstdSeeker); ok {
+			t.Fatal("used malformed seek table")
+		}
+		got, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	// A seekable stream written by tools, rather than by this package; see
+	// TestZstdFixtures.
+	t.Run("Fixture", func(t *testing.T) {
+		in, err := os.ReadFile("testdata/seekable.zst")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := open(t, in)
+		if _, ok := s.(*zstdSeeker); !ok {
+			t.Fatalf("got: %T, want: %T", s, (*zstdSeeker)(nil))
+		}
+		off := int64(len(payload) * 5 / 8)
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload[off:]) {
+			t.Error("payload mismatch")
+		}
+	})
+}
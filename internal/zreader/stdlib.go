@@ -0,0 +1,29 @@
+//go:build zreader_stdlib
+
+package zreader
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// StdlibReader returns a decoder for "c" backed by the standard library's
+// implementation, for comparing against the codecs this package uses. Only
+// gzip, zlib, and deflate are available.
+//
+// This is only built with the "zreader_stdlib" build tag, and nothing in the
+// package uses it outside of benchmarks.
+var stdlibReader = func(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case KindGzip:
+		return gzip.NewReader(r)
+	case KindZlib:
+		return zlib.NewReader(r)
+	case KindDeflate:
+		return flate.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("zreader: no stdlib decoder for %v", c)
+}
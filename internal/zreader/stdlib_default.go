@@ -0,0 +1,8 @@
+//go:build !zreader_stdlib
+
+package zreader
+
+import "io"
+
+// StdlibReader is nil unless built with the "zreader_stdlib" build tag.
+var stdlibReader func(io.Reader, Compression) (io.ReadCloser, error)
@@ -0,0 +1,76 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrUnsupportedScheme is returned when a stream is recognized as a format
+// this package can't decode.
+//
+// The concrete type is [*UnsupportedError], which names the format.
+var ErrUnsupportedScheme = errors.New("zreader: unsupported scheme")
+
+// ErrUnsupportedArchive is returned when a stream is recognized as an archive
+// container, such as zip or 7z, instead of a compressed stream. These are
+// never decoded. It implies [ErrUnsupportedScheme].
+//
+// The concrete type is [*UnsupportedError], with the Archive member set.
+var ErrUnsupportedArchive = errors.New("zreader: unsupported archive format")
+
+// UnsupportedError is the concrete type backing [ErrUnsupportedScheme].
+type UnsupportedError struct {
+	// Format is the name of the detected format.
+	Format string
+	// Archive is set if the format is an archive container rather than a
+	// compression scheme.
+	Archive bool
+}
+
+// Error implements error.
+func (e *UnsupportedError) Error() string {
+	if e.Archive {
+		return "zreader: unsupported archive format: " + e.Format
+	}
+	return "zreader: unsupported scheme: " + e.Format
+}
+
+// Is enables errors.Is.
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupportedScheme ||
+		(e.Archive && target == ErrUnsupportedArchive) ||
+		target == e
+}
+
+// Unsupported is a list of magic numbers for formats that can be recognized
+// but not decoded. These are only checked once every detector has failed.
+//
+// Brotli, which is supported, has no magic number and so can't be listed.
+var unsupported = []struct {
+	Format  string
+	Magic   []byte
+	Archive bool
+}{
+	{Format: "7z", Magic: []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}, Archive: true},
+	// The local file header is the same for encrypted entries.
+	{Format: "zip", Magic: []byte{'P', 'K', 0x03, 0x04}, Archive: true},
+	{Format: "zip", Magic: []byte{'P', 'K', 0x05, 0x06}, Archive: true}, // Empty archive.
+	{Format: "rar", Magic: []byte{'R', 'a', 'r', '!', 0x1A, 0x07}, Archive: true},
+	{Format: "lzip", Magic: []byte{'L', 'Z', 'I', 'P'}},
+	{Format: "lzop", Magic: []byte{0x89, 'L', 'Z', 'O', 0x00, 0x0D, 0x0A, 0x1A, 0x0A}},
+	{Format: "compress", Magic: []byte{0x1F, 0x9D}},
+	// Apple's pbzx is a sequence of length-prefixed chunks, usually xz
+	// streams. The chunks aren't decoded; the xz magic is not at the start.
+	{Format: "pbzx", Magic: []byte{'p', 'b', 'z', 'x'}},
+}
+
+// UnsupportedErr returns an [*UnsupportedError] if "b" starts with the magic
+// number of a known but unsupported format, or nil otherwise.
+func unsupportedErr(b []byte) error {
+	for _, u := range unsupported {
+		if bytes.HasPrefix(b, u.Magic) {
+			return &UnsupportedError{Format: u.Format, Archive: u.Archive}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package zreader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// ErrNoCompressor is returned by [Writer] for schemes that can be read, but
+// not written.
+var ErrNoCompressor = errors.New("zreader: no compressor available")
+
+// Writer returns an [io.WriteCloser] that compresses bytes written to it with
+// the scheme indicated by "c" and writes them to "w". The same codec
+// implementations used for reading are used for writing.
+//
+// Close must be called to flush the compressed stream, but does not close
+// "w". For [KindNone] and [KindTar], the bytes are passed through unmodified.
+//
+// Bzip2 and schemes added with [RegisterDetector] are not supported and return
+// [ErrNoCompressor].
+func Writer(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case KindGzip:
+		return gzip.NewWriter(w), nil
+	case KindZstd:
+		return zstd.NewWriter(w)
+	case KindBzip2:
+		return nil, fmt.Errorf("%w: %v", ErrNoCompressor, c)
+	case KindZlib:
+		return zlib.NewWriter(w), nil
+	case KindXz:
+		return xz.NewWriter(w)
+	case KindLz4:
+		return lz4.NewWriter(w), nil
+	case KindBrotli:
+		return brotli.NewWriter(w), nil
+	case KindSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	case KindLzma:
+		return lzma.NewWriter(w)
+	case KindDeflate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	case KindTar, KindNone:
+		return nopWriteCloser{w}, nil
+	}
+	if c.Registered() {
+		return nil, fmt.Errorf("%w: %v", ErrNoCompressor, c.name())
+	}
+	return nil, fmt.Errorf("zreader: unknown compression type %v", c)
+}
+
+// Normalized compression levels for [WriterLevel].
+const (
+	LevelFastest = 0
+	LevelBest    = 9
+)
+
+// WriterLevel is like [Writer], but allows for selecting the speed/ratio
+// tradeoff via a normalized level in the range [LevelFastest, LevelBest].
+// Levels outside of that range are clamped.
+//
+// The level is mapped onto each codec's native settings:
+//
+//   - gzip, zlib, deflate: levels 1–9, with 0 mapped to 1
+//   - zstd: 0–2 is SpeedFastest, 3–5 is SpeedDefault, 6–7 is
+//     SpeedBetterCompression, and 8–9 is SpeedBestCompression
+//   - xz, lzma: dictionary sizes matching the xz tool's presets, 256 KiB–64 MiB
+//   - lz4: 0 is the "fast" mode, 1–9 are levels 1–9
+//   - brotli: scaled onto quality 0–11
+//
+// The level is ignored for [KindNone] and [KindTar].
+func WriterLevel(w io.Writer, c Compression, level int) (io.WriteCloser, error) {
+	switch {
+	case level < LevelFastest:
+		level = LevelFastest
+	case level > LevelBest:
+		level = LevelBest
+	}
+	switch c {
+	case KindGzip:
+		return gzip.NewWriterLevel(w, max(level, gzip.BestSpeed))
+	case KindZstd:
+		var l zstd.EncoderLevel
+		switch {
+		case level <= 2:
+			l = zstd.SpeedFastest
+		case level <= 5:
+			l = zstd.SpeedDefault
+		case level <= 7:
+			l = zstd.SpeedBetterCompression
+		default:
+			l = zstd.SpeedBestCompression
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(l))
+	case KindZlib:
+		return zlib.NewWriterLevel(w, max(level, zlib.BestSpeed))
+	case KindDeflate:
+		return flate.NewWriter(w, max(level, flate.BestSpeed))
+	case KindXz:
+		cfg := xz.WriterConfig{DictCap: xzDictCap[level]}
+		return cfg.NewWriter(w)
+	case KindLzma:
+		cfg := lzma.WriterConfig{DictCap: xzDictCap[level]}
+		return cfg.NewWriter(w)
+	case KindLz4:
+		l := lz4.Fast
+		if level > 0 {
+			l = lz4.CompressionLevel(1 << (8 + level))
+		}
+		z := lz4.NewWriter(w)
+		if err := z.Apply(lz4.CompressionLevelOption(l)); err != nil {
+			return nil, err
+		}
+		return z, nil
+	case KindBrotli:
+		return brotli.NewWriterLevel(w, (level*brotli.BestCompression+LevelBest/2)/LevelBest), nil
+	}
+	return Writer(w, c)
+}
+
+// WriterDict returns an [io.WriteCloser] that compresses bytes written to it
+// as zstd using the dictionary "dict" and writes them to "w".
+//
+// The dictionary must be in the format produced by "zstd --train" or
+// [zstd.BuildDict]. Its ID is recorded in the stream, so it can be read back
+// by passing the same dictionary in [ReaderOpts.ZstdDicts]. Dictionaries help
+// most with many small, similar inputs, which compress poorly on their own.
+func WriterDict(w io.Writer, dict []byte) (io.WriteCloser, error) {
+	if len(dict) == 0 {
+		return nil, errors.New("zreader: empty zstd dictionary")
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderDict(dict))
+}
+
+// XzDictCap is the dictionary size for every normalized level, taken from the
+// xz tool's presets.
+var xzDictCap = [...]int{
+	256 << 10,
+	1 << 20,
+	2 << 20,
+	4 << 20,
+	4 << 20,
+	8 << 20,
+	8 << 20,
+	16 << 20,
+	32 << 20,
+	64 << 20,
+}
+
+// Max is the larger of two ints.
+//
+// This can be removed in favor of the builtin once the module targets go1.21.
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// NopWriteCloser is an [io.WriteCloser] with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close implements [io.Closer].
+func (nopWriteCloser) Close() error { return nil }
+
+// Transcode decompresses "src" as with [Detect] and writes it to "dst"
+// compressed with the scheme "to", as with [Writer]. It reports the scheme
+// detected for "src".
+//
+// The contents are streamed, so only the decoder's and encoder's buffers are
+// held in memory. The writer is closed, flushing the compressed stream, but
+// "dst" is not. On error, a partially written stream may be left in "dst".
+func Transcode(dst io.Writer, src io.Reader, to Compression) (Compression, error) {
+	rc, c, err := Detect(src)
+	if err != nil {
+		return c, err
+	}
+	defer rc.Close()
+	w, err := Writer(dst, to)
+	if err != nil {
+		return c, err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Close()
+		return c, err
+	}
+	return c, w.Close()
+}
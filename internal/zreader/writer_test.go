@@ -0,0 +1,257 @@
+package zreader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestWriter(t *testing.T) {
+	for _, c := range allKinds {
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := Writer(&buf, c)
+			if c == KindBzip2 {
+				if !errors.Is(err, ErrNoCompressor) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var rc io.ReadCloser
+			if c == KindBrotli {
+				rc, err = ReaderWith(&buf, c)
+			} else {
+				var kind Compression
+				rc, kind, err = Detect(&buf)
+				if got, want := kind, c; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := Writer(io.Discard, Compression(-1)); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestWriterLevel(t *testing.T) {
+	// Generate something that's compressible, but not trivially so.
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+	rng := rand.New(rand.NewSource(0))
+	var in bytes.Buffer
+	for in.Len() < 256*1024 {
+		in.WriteString(words[rng.Intn(len(words))])
+		in.WriteByte(" \n"[rng.Intn(2)])
+	}
+	size := func(t *testing.T, c Compression, level int) int {
+		t.Helper()
+		var buf bytes.Buffer
+		w, err := WriterLevel(&buf, c, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(in.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+
+	for _, c := range []Compression{KindGzip, KindZstd, KindZlib, KindXz, KindLz4, KindBrotli} {
+		t.Run(c.String(), func(t *testing.T) {
+			fast, best := size(t, c, LevelFastest), size(t, c, LevelBest)
+			t.Logf("fastest: %d, best: %d", fast, best)
+			if best > fast {
+				t.Errorf("best level larger than fastest: %d > %d", best, fast)
+			}
+			if got, want := size(t, c, -10), fast; got != want {
+				t.Errorf("clamping: got: %d, want: %d", got, want)
+			}
+			if got, want := size(t, c, 100), best; got != want {
+				t.Errorf("clamping: got: %d, want: %d", got, want)
+			}
+		})
+	}
+	t.Run("Bzip2", func(t *testing.T) {
+		if _, err := WriterLevel(io.Discard, KindBzip2, LevelBest); !errors.Is(err, ErrNoCompressor) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+// DictSamples returns small JSON records, similar to what's stored per
+// package.
+func dictSamples(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = []byte(fmt.Sprintf(`{"id":"%d","name":"pkg-%x","version":"%d.%d.%d-%d","kind":"binary","arch":"x86_64","package_db":"var/lib/rpm/Packages","repository_hint":"hash:sha256:%016x"}`,
+			rng.Intn(1<<20), rng.Int63(), rng.Intn(10), rng.Intn(20), rng.Intn(100), rng.Intn(5), rng.Uint64()))
+	}
+	return out
+}
+
+func TestWriterDict(t *testing.T) {
+	samples := dictSamples(800)
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1,
+		Contents: samples[100:500],
+		History:  bytes.Join(samples[:100], nil),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := samples[500:]
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, in := range test[:10] {
+			var buf bytes.Buffer
+			w, err := WriterDict(&buf, dict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(in); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, c, err := DetectOpts(bytes.NewReader(buf.Bytes()), ReaderOpts{}); err != nil || c != KindZstd {
+				t.Errorf("got: %v, %v, want: %v, <nil>", c, err, KindZstd)
+			}
+			r, c, err := DetectOpts(&buf, ReaderOpts{ZstdDicts: [][]byte{dict}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := c, KindZstd; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+			if !bytes.Equal(got, in) {
+				t.Errorf("got: %q, want: %q", got, in)
+			}
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if _, err := WriterDict(io.Discard, nil); err == nil {
+			t.Error("expected error, got nil")
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		size := func(t *testing.T, mk func(io.Writer) (io.WriteCloser, error)) (n int) {
+			for _, in := range test {
+				var buf bytes.Buffer
+				w, err := mk(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(in); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				n += buf.Len()
+			}
+			return n
+		}
+		plain := size(t, func(w io.Writer) (io.WriteCloser, error) { return Writer(w, KindZstd) })
+		withDict := size(t, func(w io.Writer) (io.WriteCloser, error) { return WriterDict(w, dict) })
+		t.Logf("without dictionary: %d bytes, with dictionary: %d bytes", plain, withDict)
+		if withDict >= plain {
+			t.Errorf("dictionary did not help: %d >= %d", withDict, plain)
+		}
+	})
+}
+
+func TestTranscode(t *testing.T) {
+	for _, tc := range []struct {
+		From, To Compression
+	}{
+		{From: KindGzip, To: KindZstd},
+		{From: KindZstd, To: KindGzip},
+		{From: KindNone, To: KindZstd},
+		{From: KindGzip, To: KindNone},
+	} {
+		tc := tc
+		t.Run(tc.From.String()+"-"+tc.To.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			c, err := Transcode(&buf, bytes.NewReader(compress(t, tc.From)), tc.To)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := c, tc.From; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			rc, kind, err := Detect(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, tc.To; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+
+	t.Run("NoCompressor", func(t *testing.T) {
+		c, err := Transcode(io.Discard, bytes.NewReader(compress(t, KindGzip)), KindBzip2)
+		if !errors.Is(err, ErrNoCompressor) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if got, want := c, KindGzip; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Corrupt", func(t *testing.T) {
+		b := compress(t, KindGzip)
+		if _, err := Transcode(io.Discard, bytes.NewReader(b[:len(b)/2]), KindZstd); err == nil {
+			t.Error("expected error for truncated source")
+		}
+	})
+}
@@ -5,66 +5,116 @@ import (
 	"bufio"
 	"bytes"
 	"compress/bzip2"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/adler32"
 	"io"
+	"sync"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
 	"github.com/klauspost/compress/zlib"
-	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
-//go:generate go run golang.org/x/tools/cmd/stringer -type Compression
+//go:generate go run golang.org/x/tools/cmd/stringer -type Compression -linecomment
 
 // Compression marks the scheme that the original Reader contains.
 type Compression int
 
 // Compression constants.
 const (
-	KindGzip Compression = iota
-	KindZstd
-	KindBzip2
-	KindZlib
-	KindNone
+	KindGzip    Compression = iota // gzip
+	KindZstd                       // zstd
+	KindBzip2                      // bzip2
+	KindZlib                       // zlib
+	KindXz                         // xz
+	KindLz4                        // lz4
+	KindBrotli                     // brotli
+	KindTar                        // tar
+	KindSnappy                     // snappy
+	KindLzma                       // lzma
+	KindDeflate                    // deflate
+	KindNone                       // none
 )
 
-// Max number of bytes needed to check compression headers. Populated in this
-// package's init func to avoid needing to keep some constants manually updated.
-var maxSz int
+// Max number of bytes needed to check the built-in compression headers.
+// Populated in this package's init func to avoid needing to keep some
+// constants manually updated. Registered detectors may need more; see peekSz.
+var builtinSz int
 
 func init() {
-	for _, d := range detectors[:] {
-		l := len(d.Mask)
-		if l > maxSz {
-			maxSz = l
+	for i := range detectors {
+		d := &detectors[i]
+		if l := d.Offset + len(d.Mask); l > builtinSz {
+			builtinSz = l
 		}
+		d.identity = bytes.Count(d.Mask, []byte{0xFF}) == len(d.Mask)
 	}
 }
 
+// MaskPool holds buffers of size peekSz for applying masks.
+var maskPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, peekSz())
+		return &b
+	},
+}
+
 // Detector is the hook to determine if a Reader contains a certain compression
 // scheme.
 type detector struct {
+	// Offset is the position in the stream of the first byte passed to
+	// Check. Detection reads at least Offset+len(Mask) bytes.
+	Offset int
 	// Mask is a bytemask for the bytes passed to Check.
 	Mask []byte
 	// Check reports if the byte slice is the header for a given compression
 	// scheme.
 	//
-	// The passed byte size is sliced to the same size of Mask, and has been
-	// ANDed pairwise with Mask.
+	// The passed byte slice is the len(Mask) bytes starting at Offset, and
+	// has been ANDed pairwise with Mask.
 	Check func([]byte) bool
+	// Confidence is how likely a match is to be correct, in the range [0, 1].
+	// Magic numbers are 1; bit-packed or loosely structured headers that could
+	// plausibly appear in other data are lower.
+	Confidence float64
+
+	// Identity is set in init if every byte of Mask is 0xFF, meaning the bytes
+	// can be passed to Check without copying.
+	identity bool
 }
 
-// Detectors is the array of detection hooks.
+// Detectors is the array of detection hooks, indexed by the [Compression] they
+// detect.
+//
+// Some schemes have no header that can be detected; their entries are left
+// as the zero value and skipped.
 var detectors = [...]detector{
-	staticHeader(gzipHeader),
-	staticHeader(zstdHeader),
+	// The gzip magic and compression method are followed by the flags byte,
+	// whose top three bits are reserved and must be zero. Only those bits
+	// are kept by the mask.
+	KindGzip: {
+		Mask:       append(bytes.Repeat([]byte{0xFF}, len(gzipHeader)), 0xE0),
+		Confidence: 1,
+		Check: func(b []byte) bool {
+			l := len(gzipHeader)
+			return bytes.Equal(gzipHeader, b[:l]) && b[l] == 0
+		},
+	},
+	KindZstd: staticHeader(zstdHeader),
 	// Bzip2 header is technically 2 bytes, but the other valid value for byte 3
 	// is bzip1-compat format and the fourth byte is required to in a certain
 	// range.
-	{
-		Mask: bytes.Repeat([]byte{0xFF}, 4),
+	KindBzip2: {
+		Mask:       bytes.Repeat([]byte{0xFF}, 4),
+		Confidence: 1,
 		Check: func(b []byte) bool {
 			l := len(bzipHeader)
 			return bytes.Equal(bzipHeader, b[:l]) && (b[l] >= '1' && b[l] <= '9')
@@ -72,33 +122,85 @@ var detectors = [...]detector{
 	},
 	// The zlib header is bit-packed, so we need to do something more complex
 	// than bytes.Equal.
-	{
-		Mask: bytes.Repeat([]byte{0xFF}, 6),
+	//
+	// Only about 11 bits of the header are fixed, so matches are not
+	// especially trustworthy, and it's checked after every magic number.
+	KindZlib: {
+		Mask:       bytes.Repeat([]byte{0xFF}, 6),
+		Confidence: 0.25,
 		Check: func(b []byte) bool {
-			const (
-				magic     = 8
-				maxWindow = 7
-			)
-			x := binary.BigEndian.Uint16(b[:2])
-			if (b[0]&0x0f != magic) || (b[0]>>4 > maxWindow) || x%31 != 0 {
+			id, dict, ok := zlibHeader(b)
+			if !ok {
 				return false
 			}
-			if b[1]&0x20 != 0 {
-				ck := binary.BigEndian.Uint32(b[2:])
-				if ck != zlibChecksum {
-					return false
-				}
+			if dict && id != zlibChecksum {
+				return false
 			}
 			return true
 		},
 	},
+	KindXz: staticHeader(xzHeader),
+	// LZ4 has two frame types with data that may appear at the start of a
+	// stream: the current frame format and the legacy frame format.
+	//
+	// Skippable frames are shared with zstd, so they're looked past before
+	// any detector is run; see detectTrace.
+	KindLz4: {
+		Mask:       bytes.Repeat([]byte{0xFF}, 4),
+		Confidence: 1,
+		Check: func(b []byte) bool {
+			m := binary.LittleEndian.Uint32(b)
+			return m == lz4Magic || m == lz4LegacyMagic
+		},
+	},
+	// Tar isn't a compression scheme, but is common enough to be worth
+	// detecting. The magic is at a fixed offset in the header block.
+	KindTar: {
+		Offset:     tarMagicOffset,
+		Mask:       bytes.Repeat([]byte{0xFF}, 8),
+		Confidence: 1,
+		Check: func(b []byte) bool {
+			return bytes.Equal(b[:len(tarMagicPOSIX)], tarMagicPOSIX) ||
+				bytes.Equal(b[:len(tarMagicGNU)], tarMagicGNU)
+		},
+	},
+	// Only the snappy framing format has a header. Raw snappy blocks can't be
+	// detected.
+	KindSnappy: staticHeader(snappyHeader),
+	// The LZMA-alone format has no magic number, just the encoder properties
+	// and sizes. This is checked after every magic number, and the fields are
+	// held to what the lzma tool produces to keep false positives down.
+	KindLzma: {
+		Mask:       bytes.Repeat([]byte{0xFF}, 13),
+		Confidence: 0.75,
+		Check: func(b []byte) bool {
+			// The properties byte packs lc, lp, and pb, which have maximums
+			// of 8, 4, and 4.
+			if b[0] >= 9*5*5 {
+				return false
+			}
+			// The dictionary size is 2^n or 2^n+2^(n-1), at least 4 KiB.
+			dict := binary.LittleEndian.Uint32(b[1:5])
+			if dict < 4096 {
+				return false
+			}
+			if low := dict & -dict; dict != low && dict != low*3 {
+				return false
+			}
+			// The uncompressed size is unknown (all ones) or less than
+			// 256 GiB.
+			sz := binary.LittleEndian.Uint64(b[5:13])
+			return sz == ^uint64(0) || sz < 1<<38
+		},
+	},
 }
 
 // StaticHeader is a helper to create a [detector] for has a constant byte
 // string.
 func staticHeader(h []byte) detector {
 	return detector{
-		Mask: bytes.Repeat([]byte{0xFF}, len(h)),
+		Mask:       bytes.Repeat([]byte{0xFF}, len(h)),
+		Confidence: 1,
 		Check: func(b []byte) bool {
 			return bytes.Equal(h, b)
 		},
@@ -110,36 +212,266 @@ var (
 	gzipHeader = []byte{0x1F, 0x8B, 0x08}
 	zstdHeader = []byte{0x28, 0xB5, 0x2F, 0xFD}
 	bzipHeader = []byte{'B', 'Z', 'h'}
+	xzHeader   = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	// The stream identifier chunk: a chunk type of 0xFF, a 3-byte length of
+	// 6, then "sNaPpY".
+	snappyHeader = []byte{0xFF, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}
+
+	tarMagicPOSIX = []byte("ustar\x00")
+	tarMagicGNU   = []byte("ustar  \x00")
 )
 
+// TarMagicOffset is the offset of the magic in a tar header block.
+const tarMagicOffset = 257
+
+// Some LZ4 frame magic values. These are stored as little-endian integers on
+// the wire.
+//
+// The skippable frame magic is any value in the range 0x184D2A50 to
+// 0x184D2A5F, and is also used by zstd.
+const (
+	lz4Magic       = 0x184D2204
+	lz4LegacyMagic = 0x184C2102
+	lz4SkipMagic   = 0x184D2A50
+)
+
+// SkipFrames returns the length of the skippable frames at the start of "b".
+// If the last of them runs past the end of "b", "ok" is false.
+func skipFrames(b []byte) (n int, ok bool) {
+	for len(b)-n >= 8 && binary.LittleEndian.Uint32(b[n:])&^0xF == lz4SkipMagic {
+		n += 8 + int(binary.LittleEndian.Uint32(b[n+4:]))
+		if n > len(b) || n < 0 {
+			return n, false
+		}
+	}
+	return n, true
+}
+
 // ZlibChecksum is the checksum for zlib stream that does not have a provided
 // dictionary. It's impossible to have a pre-decided dictionary without a
 // sideband.
 var zlibChecksum = adler32.Checksum(nil)
 
+// ZlibHeader reports whether "b" starts with a valid zlib header. If the
+// header indicates a preset dictionary, "dict" is true and "id" is the
+// dictionary's Adler-32 checksum.
+func zlibHeader(b []byte) (id uint32, dict bool, ok bool) {
+	const (
+		magic     = 8
+		maxWindow = 7
+	)
+	if len(b) < 6 {
+		return 0, false, false
+	}
+	x := binary.BigEndian.Uint16(b[:2])
+	if (b[0]&0x0f != magic) || (b[0]>>4 > maxWindow) || x%31 != 0 {
+		return 0, false, false
+	}
+	if b[1]&0x20 != 0 {
+		return binary.BigEndian.Uint32(b[2:]), true, true
+	}
+	return 0, false, true
+}
+
 // DetectCompression reports the compression type indicated based on the header
 // contained in the passed byte slice.
 //
 // "CmpNone" is returned if all detectors report false, but it's possible that
 // it's just a scheme unsupported by this package.
 func detectCompression(b []byte) Compression {
-	t := make([]byte, len(b))
-	for c, d := range detectors {
-		n, l := copy(t, b), len(d.Mask)
-		if n < l {
-			continue
+	return detectTrace(b, nil)
+}
+
+// DetectTrace is [detectCompression], but calls "trace" with the masked bytes
+// and result of every detector that's run, if "trace" is not nil.
+//
+// Most masks are all ones, so the input is only copied for the detectors that
+// need it, into a pooled buffer. This keeps detection from allocating.
+func detectTrace(b []byte, trace func(c Compression, masked []byte, ok bool)) Compression {
+	// Skippable frames hold no data, and both the lz4 and zstd decoders skip
+	// them, so detection uses the frame after them. Anything else there
+	// couldn't be decoded, nor could a frame that isn't in "b".
+	if n, ok := skipFrames(b); n > 0 {
+		if !ok {
+			return KindNone
+		}
+		switch c := detectTrace(b[n:], trace); c {
+		case KindZstd, KindLz4:
+			return c
 		}
-		t := t[:l]
-		for i := range d.Mask {
-			t[i] &= d.Mask[i]
+		return KindNone
+	}
+	var buf *[]byte
+	defer func() {
+		if buf != nil {
+			maskPool.Put(buf)
 		}
-		if d.Check(t) {
-			return Compression(c)
+	}()
+	run := func(c Compression, d *detector) bool {
+		if d.Check == nil {
+			return false
+		}
+		l := len(d.Mask)
+		if len(b) < d.Offset+l {
+			return false
+		}
+		t := b[d.Offset : d.Offset+l]
+		if !d.identity {
+			if buf == nil {
+				buf = maskPool.Get().(*[]byte)
+			}
+			if len(*buf) < l {
+				// Registering a detector may have increased peekSz.
+				*buf = make([]byte, l)
+			}
+			t = (*buf)[:l]
+			for i := range d.Mask {
+				t[i] = b[d.Offset+i] & d.Mask[i]
+			}
+		}
+		ok := d.Check(t)
+		if trace != nil {
+			trace(c, t, ok)
+		}
+		return ok
+	}
+	// Every magic number is checked before any weak header, so that a guess
+	// like zlib's can't claim a stream a stronger check (like tar's, at an
+	// offset) would match.
+	r := reg()
+	for _, strong := range [...]bool{true, false} {
+		for c := range detectors {
+			d := &detectors[c]
+			if d.strong() == strong && run(Compression(c), d) {
+				return Compression(c)
+			}
+		}
+		for i := range r.detectors {
+			d := &r.detectors[i]
+			c := KindNone + 1 + Compression(i)
+			if d.strong() == strong && run(c, d) {
+				return c
+			}
 		}
 	}
 	return KindNone
 }
 
+// Strong reports whether the detector checks a magic number, as opposed to a
+// weak header.
+func (d *detector) strong() bool {
+	return d.Confidence >= 1
+}
+
+// DetectBytes reports the compression scheme indicated by the header at the
+// start of "b", without constructing any Readers.
+//
+// Only the bytes that [Detect] would examine are used. If "b" is shorter than
+// that, only schemes with headers that fit in "b" are considered, which is
+// the same behavior as [Detect] on a short stream.
+func DetectBytes(b []byte) Compression {
+	if sz := peekSz(); len(b) > sz {
+		b = b[:sz]
+	}
+	return detectCompression(b)
+}
+
+// Classify reports the compression scheme indicated by the header at the
+// start of "b", like [DetectBytes], and whether "b" is long enough for the
+// result to be definitive.
+//
+// Detectors are run in order and the first match wins, so a result is
+// definitive if every detector ahead of the match had enough bytes to run.
+// When the reported bool is false, the same bytes with more appended may
+// classify differently; callers buffering a stream can read more and call
+// Classify again.
+func Classify(b []byte) (Compression, bool) {
+	if sz := peekSz(); len(b) > sz {
+		b = b[:sz]
+	}
+	c := detectCompression(b)
+	short := func(d *detector) bool {
+		return d.Check != nil && len(b) < d.Offset+len(d.Mask)
+	}
+	for i := range detectors {
+		if Compression(i) == c {
+			return c, true
+		}
+		if short(&detectors[i]) {
+			return c, false
+		}
+	}
+	r := reg()
+	for i := range r.detectors {
+		if KindNone+1+Compression(i) == c {
+			return c, true
+		}
+		if short(&r.detectors[i]) {
+			return c, false
+		}
+	}
+	return c, true
+}
+
+// DetectAt reports the compression scheme indicated by the header at the start
+// of "r", without modifying any state of "r".
+//
+// A source shorter than the number of bytes [Detect] would examine is handled
+// the same as [DetectBytes].
+func DetectAt(r io.ReaderAt) (Compression, error) {
+	b := make([]byte, peekSz())
+	n, err := r.ReadAt(b, 0)
+	switch {
+	case errors.Is(err, nil):
+	case errors.Is(err, io.EOF):
+	default:
+		return KindNone, err
+	}
+	return detectCompression(b[:n]), nil
+}
+
+// DetectPeek reports the compression scheme indicated by the header returned
+// by "peek", for sources that can provide their first bytes cheaply but aren't
+// an [io.Reader] or [io.ReaderAt].
+//
+// "Peek" is called once, with the number of bytes [Detect] would examine. A
+// shorter result is handled the same as [DetectBytes], so a truncated header
+// is reported as [KindNone]; a longer one is truncated. If "peek" returns an
+// error other than [io.EOF] or [io.ErrUnexpectedEOF], it's returned.
+func DetectPeek(peek func(n int) ([]byte, error)) (Compression, error) {
+	b, err := peek(peekSz())
+	switch {
+	case errors.Is(err, nil):
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+	default:
+		return KindNone, err
+	}
+	return DetectBytes(b), nil
+}
+
+// Peek reports the compression scheme of "r" without decompressing it. The
+// returned [io.Reader] reads the same bytes as "r" would have, including the
+// header examined during detection, and should be used in place of "r".
+//
+// A source shorter than the number of bytes [Detect] would examine is handled
+// the same as [DetectBytes]. If reading the header fails with any other error,
+// the error is returned along with a nil Reader.
+func Peek(r io.Reader) (Compression, io.Reader, error) {
+	if r == nil {
+		r = emptyReader{}
+	}
+	b := make([]byte, peekSz())
+	n, err := io.ReadFull(r, b)
+	switch {
+	case errors.Is(err, nil):
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+	default:
+		return KindNone, nil, err
+	}
+	b = b[:n]
+	return detectCompression(b), io.MultiReader(bytes.NewReader(b), r), nil
+}
+
 // Reader returns an [io.ReadCloser] that transparently reads bytes compressed with
 // one of the following schemes:
 //
@@ -147,64 +479,466 @@ func detectCompression(b []byte) Compression {
 //   - zstd
 //   - bzip2
 //   - zlib
+//   - xz
+//   - lz4
+//   - snappy (framing format)
+//   - lzma
+//
+// Brotli and raw DEFLATE streams have no identifying header, so they are never
+// detected; see [ReaderWith].
+//
+// Uncompressed tar archives are reported as [KindTar] by the functions that
+// report the compression scheme, but are otherwise treated the same as
+// [KindNone].
+//
+// Concatenated streams are decoded as one stream for every scheme that allows
+// them. For zstd, this includes skippable frames between or after data frames,
+// which are skipped. A zstd or lz4 stream that begins with skippable frames
+// is detected by the frame after them, if it's within the bytes examined.
 //
 // If the data does not seem to be one of these schemes, a new [io.ReadCloser]
-// equivalent to the provided [io.Reader] is returned.
+// equivalent to the provided [io.Reader] is returned. If the data is
+// recognized as a format that can't be decoded (such as 7z or zip), that
+// ReadCloser is returned along with an [*UnsupportedError].
+// The returned [io.ReadCloser] implements [Decoder].
+//
+// A nil [io.Reader] is treated as an empty stream: the returned ReadCloser
+// returns [io.EOF] immediately and the scheme is reported as [KindNone]. A
+// non-nil interface holding a nil pointer is not detected, and will panic as
+// usual.
+//
 // The provided [io.Reader] is expected to have any necessary cleanup arranged
 // by the caller; that is, it will not arrange for a Close method to be called
 // if it also implements [io.Closer].
 func Reader(r io.Reader) (rc io.ReadCloser, err error) {
-	rc, _, err = detect(r)
+	rc, _, err = detect(r, &ReaderOpts{})
 	return rc, err
 }
 
+// DetectReader follows the same procedure as [Detect], but reads directly from
+// "br" instead of wrapping the source in another buffer. This lets callers
+// that already have a buffered source choose the buffer size and avoid
+// copying through a second buffer.
+//
+// Detection needs to peek at more bytes than the smallest possible
+// [bufio.Reader] holds, so if "br" is smaller than that, it's wrapped as
+// usual. Bytes of "br" are consumed as the returned [io.ReadCloser] is read;
+// "br" should not be read from directly afterwards.
+func DetectReader(br *bufio.Reader) (io.ReadCloser, Compression, error) {
+	opts := &ReaderOpts{}
+	if br == nil {
+		return detect(nil, opts)
+	}
+	if br.Size() < peekSz() {
+		return detect(br, opts)
+	}
+	return detectBuffered(br, false, opts)
+}
+
 // Detect follows the same procedure as [Reader], but also reports the detected
 // compression scheme.
+//
+// Detect never reports [KindBrotli] or [KindDeflate].
 func Detect(r io.Reader) (io.ReadCloser, Compression, error) {
-	return detect(r)
+	return detect(r, &ReaderOpts{})
+}
+
+// DetectOpts follows the same procedure as [Detect], with the behavior of the
+// returned [io.ReadCloser] modified by the passed [ReaderOpts].
+func DetectOpts(r io.Reader, opts ReaderOpts) (io.ReadCloser, Compression, error) {
+	return detect(r, &opts)
+}
+
+// DecompressAll detects the compression scheme of "r" and returns the entire
+// decompressed contents. If there are more than "max" decompressed bytes,
+// [ErrSizeLimit] is returned.
+//
+// A "max" less than or equal to zero means no limit. On error, the returned
+// slice is nil.
+func DecompressAll(r io.Reader, max int64) ([]byte, Compression, error) {
+	d, c, err := detect(r, &ReaderOpts{MaxSize: max})
+	if err != nil {
+		return nil, c, err
+	}
+	defer d.Close()
+	b, err := io.ReadAll(d)
+	if err != nil {
+		return nil, c, err
+	}
+	return b, c, nil
+}
+
+// Copy detects the compression scheme of "src" and copies the decompressed
+// contents to "dst", returning the number of decompressed bytes written.
+//
+// The copy stops with [ErrSizeLimit] once more than "max" decompressed bytes
+// have been read, and with the Context's error once "ctx" is done; the Context
+// is checked before every Read. The bytes read up to that point have been
+// written to "dst". A "max" less than or equal to zero means no limit.
+func Copy(ctx context.Context, dst io.Writer, src io.Reader, max int64) (written int64, c Compression, err error) {
+	d, c, err := detect(src, &ReaderOpts{ctx: ctx, MaxSize: max})
+	if err != nil {
+		return 0, c, err
+	}
+	defer func() {
+		err = errors.Join(err, d.Close())
+	}()
+	written, err = io.Copy(dst, d)
+	return written, c, err
+}
+
+// ReaderContext is like [Reader], but the returned [io.ReadCloser] reports the
+// Context's error once it's canceled. The Context is also checked before
+// every read of the provided [io.Reader], including during detection.
+//
+// Close always closes the underlying decoder, regardless of the Context's
+// state.
+func ReaderContext(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
+	rc, _, err := detect(r, &ReaderOpts{ctx: ctx})
+	return rc, err
+}
+
+// DetectContext follows the same procedure as [ReaderContext], but also reports
+// the detected compression scheme.
+//
+// The detected scheme, its confidence, and the number of bytes examined are
+// logged at the debug level to the logger associated with the Context. The
+// result of every detector is logged to the execution trace, if one is
+// running; see [runtime/trace].
+func DetectContext(ctx context.Context, r io.Reader) (io.ReadCloser, Compression, error) {
+	return detect(r, &ReaderOpts{ctx: ctx})
+}
+
+// DetectCounting follows the same procedure as [Detect], but returns a
+// [*CountingReadCloser] that reports the number of bytes read from "r" and the
+// number of decompressed bytes read.
+func DetectCounting(r io.Reader) (*CountingReadCloser, Compression, error) {
+	return DetectCountingOpts(r, ReaderOpts{})
+}
+
+// DetectCountingOpts is like [DetectCounting], but configured with the
+// passed ReaderOpts.
+func DetectCountingOpts(r io.Reader, opts ReaderOpts) (*CountingReadCloser, Compression, error) {
+	opts.count = true
+	rc, c, err := detect(r, &opts)
+	if rc == nil {
+		return nil, c, err
+	}
+	return &CountingReadCloser{rc: rc, in: opts.in}, c, err
+}
+
+// LowConfidence is the confidence reported by [DetectConfidence] below which
+// the detected scheme comes from a header that's likely to appear by chance in
+// uncompressed data.
+const LowConfidence = 0.5
+
+// DetectConfidence follows the same procedure as [Detect], but also reports
+// the confidence of the detection in the range [0, 1].
+//
+// Schemes identified by a magic number report 1. Schemes with weak headers,
+// such as zlib, report values below [LowConfidence], and callers may prefer to
+// treat those streams as [KindNone]. [KindNone] reports 0, as no header
+// matched.
+func DetectConfidence(r io.Reader) (io.ReadCloser, Compression, float64, error) {
+	rc, c, err := detect(r, &ReaderOpts{})
+	if err != nil {
+		return nil, c, 0, err
+	}
+	return rc, c, c.Confidence(), nil
+}
+
+// Confidence reports the confidence of a detector match for the Compression,
+// as with [DetectConfidence].
+func (c Compression) Confidence() float64 {
+	switch r := reg(); {
+	case c > KindNone && int(c-KindNone) <= len(r.detectors):
+		return r.detectors[c-KindNone-1].Confidence
+	case c < 0 || int(c) >= len(detectors):
+		return 0
+	}
+	return detectors[c].Confidence
+}
+
+// SupportedKinds returns every Compression that detection can report, in the
+// order the detectors are run: schemes with a magic number, then schemes with
+// weak headers. Schemes added with [RegisterDetector] are included.
+//
+// [KindNone] is not included, nor are schemes that can't be detected, such as
+// [KindBrotli].
+func SupportedKinds() []Compression {
+	var out []Compression
+	r := reg()
+	for _, strong := range [...]bool{true, false} {
+		for c := range detectors {
+			if d := &detectors[c]; d.Check != nil && d.strong() == strong {
+				out = append(out, Compression(c))
+			}
+		}
+		for i := range r.detectors {
+			if r.detectors[i].strong() == strong {
+				out = append(out, KindNone+1+Compression(i))
+			}
+		}
+	}
+	return out
+}
+
+// HeaderMask returns a copy of the mask ANDed with the start of a stream
+// before checking for the header of "c". The length of the mask is the number
+// of bytes examined for "c"; a zero byte means the corresponding byte of the
+// stream is ignored.
+//
+// Nil is returned for any Compression not reported by [SupportedKinds].
+func HeaderMask(c Compression) []byte {
+	var d *detector
+	switch r := reg(); {
+	case c > KindNone && int(c-KindNone) <= len(r.detectors):
+		d = &r.detectors[c-KindNone-1]
+	case c >= 0 && int(c) < len(detectors):
+		d = &detectors[c]
+	default:
+		return nil
+	}
+	if d.Check == nil {
+		return nil
+	}
+	return append(make([]byte, d.Offset), d.Mask...)
+}
+
+// DetectTee follows the same procedure as [Detect], but also writes every byte
+// read from "r" to "w", including the bytes examined during detection. Each
+// byte is written exactly once, in order.
+//
+// Bytes are written as they're read from "r", which may be ahead of what the
+// decoder has consumed due to buffering. Not every decoder reads its source to
+// EOF, so a caller that wants a complete copy of "r" should read the returned
+// [io.ReadCloser] to EOF and then copy any remainder of "r" to "w". An error
+// from "w" is returned from Read.
+func DetectTee(r io.Reader, w io.Writer) (io.ReadCloser, Compression, error) {
+	return detect(r, &ReaderOpts{tee: w})
 }
 
-// Detect (unexported) does the actual work for both [Detect] and [Reader].
-func detect(r io.Reader) (io.ReadCloser, Compression, error) {
-	br := bufio.NewReader(r)
+// DetectBuffered follows the same procedure as [Detect], but also returns a
+// copy of the header bytes that were examined to determine the compression
+// scheme. The length of the returned slice is the number of bytes peeked.
+//
+// The header bytes are the start of the compressed stream, so they can be used
+// to reconstruct it in cases where only the detection result is needed. The
+// internal buffer is not exposed because decoders may read from it
+// concurrently.
+func DetectBuffered(r io.Reader) (io.ReadCloser, Compression, []byte, error) {
+	var opts ReaderOpts
+	rc, c, err := detect(r, &opts)
+	return rc, c, opts.header, err
+}
+
+// ReaderWith returns an [io.ReadCloser] that decompresses the provided
+// [io.Reader] using the scheme indicated by "c", without doing any detection.
+//
+// This is the only way to read schemes that can not be detected from the
+// contents of the stream, like [KindBrotli]. This is useful if the compression
+// scheme has been learned out-of-band, such as from an HTTP
+// "Content-Encoding" header.
+//
+// [KindDeflate] is a raw DEFLATE stream, as described in RFC 1951, with no
+// zlib or gzip wrapper. Such a stream has no header at all, so it can only be
+// read with ReaderWith. Note that the HTTP "deflate" encoding is actually
+// [KindZlib].
+//
+// The same cleanup rules as for [Reader] apply.
+func ReaderWith(r io.Reader, c Compression) (io.ReadCloser, error) {
+	d, err := newReader(r, c, &ReaderOpts{})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Detect (unexported) does the actual work for all the exported detection
+// functions.
+func detect(r io.Reader, opts *ReaderOpts) (Decoder, Compression, error) {
+	if r == nil {
+		return passThrough(emptyReader{}), KindNone, nil
+	}
+	r = opts.source(r)
+	// Decoders reading from a pooled Reader must stop reading from it once
+	// closed, which the parallel bzip2 decoder can't promise.
+	pooled := opts.BufferSize <= 0 && !opts.ParallelBzip2
+	var br *bufio.Reader
+	if pooled {
+		br = getBufio(r, opts.bufferSize())
+	} else {
+		br = bufio.NewReaderSize(r, opts.bufferSize())
+	}
+	return detectBuffered(br, pooled, opts)
+}
+
+// DetectBuffered is the second half of detect, run once the source is
+// buffered. If "pooled" is set, "br" is returned to the pool when the
+// returned Decoder is closed.
+func detectBuffered(br *bufio.Reader, pooled bool, opts *ReaderOpts) (Decoder, Compression, error) {
+	if !opts.ParallelBzip2 {
+		opts.drain = br
+	}
+	// Release arranges for "br" to be returned to the pool when "d" is
+	// closed, if possible. Decoders reading from a copy of the peeked bytes
+	// must not use it, as the copy aliases the buffer.
+	release := func(d *decoder) *decoder {
+		if pooled {
+			d.buf = br
+		}
+		return d
+	}
 	// Populate a buffer with enough bytes to determine what header is at the
 	// start of this Reader.
-	b, err := br.Peek(maxSz)
+	b, err := opts.peek(br)
+	if errors.Is(err, ErrDetectTimeout) {
+		return nil, KindNone, err
+	}
+	opts.header = bytes.Clone(b)
+	var c Compression
 	switch {
 	case errors.Is(err, nil):
+		c, err = opts.detect(b)
+		if err != nil {
+			return nil, KindNone, err
+		}
+		if c == KindNone {
+			if err := unsupportedErr(b); err != nil {
+				return opts.wrap(release(passThrough(br))), KindNone, err
+			}
+		}
 	case errors.Is(err, io.ErrNoProgress):
-		return io.NopCloser(br), KindNone, nil
+		return opts.wrap(release(passThrough(br))), KindNone, nil
 	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
-		// Not enough bytes, just return a reader containing the bytes.
-		return io.NopCloser(bytes.NewReader(b)), KindNone, err
+		// Not enough bytes for every detector, but schemes with shorter
+		// headers may still be present.
+		//
+		// Peek only reports an error if it has fewer bytes than asked for,
+		// so a source that returns its last bytes along with io.EOF only
+		// ends up here if it's actually this short.
+		//
+		// The number of bytes needed for detection is large enough that
+		// complete, uncompressed files may be shorter, so this is not
+		// reported as an error unless asked for.
+		if opts.RequireFullHeader {
+			return opts.wrap(passThrough(bytes.NewReader(b))), KindNone, err
+		}
+		c, err = opts.detect(b)
+		switch {
+		case err != nil:
+			return nil, KindNone, err
+		case c == KindNone:
+			// Just return a reader containing the bytes.
+			return opts.wrap(passThrough(bytes.NewReader(b))), KindNone, unsupportedErr(b)
+		}
 	default:
 		return nil, KindNone, err
 	}
 
-	// Run the detectors.
-	//
-	// All the return types are a little different, so they're handled in the
-	// switch arms.
-	switch c := detectCompression(b); c {
+	var src io.Reader = br
+	var rec *recorder
+	if opts.FallbackOnError {
+		rec = &recorder{br: br}
+		rec.on.Store(true)
+		src = rec
+	}
+	rc, err := newReader(src, c, opts)
+	switch {
+	case err == nil:
+	case rec != nil:
+		// Reconstruct the stream from the bytes the decoder consumed and the
+		// rest of the buffered Reader.
+		return opts.wrap(passThrough(io.MultiReader(&rec.buf, br))), KindNone, nil
+	default:
+		return nil, KindNone, err
+	}
+	if rec != nil {
+		rec.on.Store(false)
+	}
+	if rc.under != nil {
+		rc.r = &classifyReader{r: rc.r, src: opts.src}
+	}
+	return opts.wrap(release(rc)), c, nil
+}
+
+// NewReader constructs the decoder for the scheme "c" over the Reader "r",
+// configured by "opts".
+//
+// All the decoder types are a little different, so they're handled in the
+// switch arms.
+func newReader(r io.Reader, c Compression, opts *ReaderOpts) (*decoder, error) {
+	switch c {
 	case KindGzip:
-		z, err := gzip.NewReader(br)
-		return z, c, err
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		z.Multistream(!opts.NoMultistream)
+		d := &decoder{r: z, c: z, under: z}
+		d.gzflags, d.gzok = parseGzipFlags(opts.header)
+		return d, nil
 	case KindZstd:
-		z, err := zstd.NewReader(br)
+		zr, err := newZstdReader(r, opts)
 		if err != nil {
-			return nil, KindNone, err
+			return nil, err
 		}
-		return z.IOReadCloser(), c, nil
+		return &decoder{r: zr, c: zr, under: zr.dec}, nil
 	case KindBzip2:
-		z := bzip2.NewReader(br)
-		return io.NopCloser(z), c, nil
+		// The standard library's bzip2 reader reads concatenated streams,
+		// checking for another stream's magic at the end of each one.
+		if opts.ParallelBzip2 {
+			z := newBzip2Parallel(r)
+			return &decoder{r: z, c: z, under: z}, nil
+		}
+		z := bzip2.NewReader(r)
+		return &decoder{r: z, under: z}, nil
 	case KindZlib:
-		z, err := zlib.NewReader(br)
-		return z, c, err
-	case KindNone:
+		// NewReaderDict ignores the dictionary if the stream doesn't use one.
+		z, err := zlib.NewReaderDict(r, opts.ZlibDict)
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{r: z, c: z, under: z}, nil
+	case KindXz:
+		z, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{r: z, under: z}, nil
+	case KindLz4:
+		z := lz4.NewReader(r)
+		return &decoder{r: z, under: z}, nil
+	case KindBrotli:
+		z := brotli.NewReader(r)
+		return &decoder{r: z, under: z}, nil
+	case KindSnappy:
+		z := snappy.NewReader(r)
+		return &decoder{r: z, under: z}, nil
+	case KindLzma:
+		z, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{r: z, under: z}, nil
+	case KindDeflate:
+		z := flate.NewReader(r)
+		return &decoder{r: z, c: z, under: z}, nil
+	case KindTar, KindNone:
 		// Return the reconstructed Reader.
-	default:
-		panic(fmt.Sprintf("programmer error: unknown compression type %v (bytes read: %#v)", c, b))
+		return passThrough(r), nil
+	}
+	if reg := reg(); c > KindNone && int(c-KindNone) <= len(reg.detectors) {
+		open := reg.decoders[c-KindNone-1]
+		if open == nil {
+			// There's no decoder for schemes added with RegisterDetector.
+			return passThrough(r), nil
+		}
+		z, err := open(r)
+		if err != nil {
+			return nil, err
+		}
+		return &decoder{r: z, c: z, under: z}, nil
 	}
-	return io.NopCloser(br), KindNone, nil
+	return nil, fmt.Errorf("zreader: unknown compression type %v", c)
 }
@@ -0,0 +1,2078 @@
+package zreader
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Payload is some compressible test data.
+//
+// The file "testdata/payload.bz2" is this payload compressed with the bzip2
+// tool, as there's no bzip2 compressor in the standard library. The file
+// "testdata/payload_upper.bz2" is the same, but uppercased. The file
+// "testdata/payload.lzma" is this payload compressed with the lzma tool.
+var payload = bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1024)
+
+// Compress returns "payload" compressed with the scheme "c".
+func compress(t testing.TB, c Compression) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch c {
+	case KindGzip:
+		w = gzip.NewWriter(&buf)
+	case KindZstd:
+		w, err = zstd.NewWriter(&buf)
+	case KindBzip2:
+		b, err := os.ReadFile("testdata/payload.bz2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	case KindZlib:
+		w = zlib.NewWriter(&buf)
+	case KindXz:
+		w, err = xz.NewWriter(&buf)
+	case KindLz4:
+		w = lz4.NewWriter(&buf)
+	case KindBrotli:
+		w = brotli.NewWriter(&buf)
+	case KindSnappy:
+		w = snappy.NewBufferedWriter(&buf)
+	case KindLzma:
+		w, err = lzma.NewWriter(&buf)
+	case KindNone:
+		return bytes.Clone(payload)
+	default:
+		t.Fatalf("unknown compression: %v", c)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// AllKinds is every Compression constant.
+var allKinds = []Compression{
+	KindGzip,
+	KindZstd,
+	KindBzip2,
+	KindZlib,
+	KindXz,
+	KindLz4,
+	KindBrotli,
+	KindSnappy,
+	KindLzma,
+	KindNone,
+}
+
+func TestReaderWith(t *testing.T) {
+	for _, c := range allKinds {
+		t.Run(c.String(), func(t *testing.T) {
+			rc, err := ReaderWith(bytes.NewReader(compress(t, c)), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := ReaderWith(bytes.NewReader(payload), Compression(-1))
+		if err == nil {
+			t.Error("expected error for unknown compression")
+		}
+	})
+	t.Run("DetectBrotli", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(compress(t, KindBrotli)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestXz(t *testing.T) {
+	b := compress(t, KindXz)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindXz; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Reader", func(t *testing.T) {
+		rc, err := Reader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		in := b[:len(xzHeader)-1]
+		rc, kind, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Errorf("got: %#v, want: %#v", got, in)
+		}
+	})
+	t.Run("BadMagic", func(t *testing.T) {
+		in := bytes.Clone(b)
+		in[len(xzHeader)-1] = 0xFF
+		rc, kind, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestLz4(t *testing.T) {
+	// Make sure the payload spans multiple blocks.
+	in := bytes.Repeat(payload, 4)
+	compress := func(t *testing.T, opts ...lz4.Option) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		w := lz4.NewWriter(&buf)
+		if err := w.Apply(opts...); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	check := func(t *testing.T, b []byte) {
+		t.Helper()
+		rc, kind, err := Detect(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindLz4; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Error("payload mismatch")
+		}
+	}
+
+	t.Run("Frame", func(t *testing.T) {
+		check(t, compress(t, lz4.BlockSizeOption(lz4.Block64Kb)))
+	})
+	t.Run("Legacy", func(t *testing.T) {
+		// The lz4 package's legacy writer pads out the final block, so
+		// construct the frame by hand.
+		blk := make([]byte, lz4.CompressBlockBound(len(in)))
+		n, err := lz4.CompressBlock(in, blk, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := binary.LittleEndian.AppendUint32(nil, lz4LegacyMagic)
+		b = binary.LittleEndian.AppendUint32(b, uint32(n))
+		check(t, append(b, blk[:n]...))
+	})
+	t.Run("Skippable", func(t *testing.T) {
+		b := binary.LittleEndian.AppendUint32(nil, lz4SkipMagic|0x7)
+		b = binary.LittleEndian.AppendUint32(b, 4)
+		b = append(b, "skip"...)
+		check(t, append(b, compress(t, lz4.BlockSizeOption(lz4.Block64Kb))...))
+	})
+	// The skippable frame magic is shared with zstd, so a zstd stream that
+	// starts with one must not be claimed.
+	t.Run("ZstdSkippable", func(t *testing.T) {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		b := binary.LittleEndian.AppendUint32(nil, lz4SkipMagic)
+		b = binary.LittleEndian.AppendUint32(b, 4)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = enc.EncodeAll(in, b)
+		rc, kind, err := Detect(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("SkippableOnly", func(t *testing.T) {
+		b := binary.LittleEndian.AppendUint32(nil, lz4SkipMagic|0x3)
+		b = binary.LittleEndian.AppendUint32(b, 4)
+		b = append(b, "skip"...)
+		if got, want := DetectBytes(append(b, "plain text"...)), KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("String", func(t *testing.T) {
+		if got, want := KindLz4.String(), "lz4"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+}
+
+func TestDetectBuffered(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			in := compress(t, c)
+			rc, kind, hdr, err := DetectBuffered(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			want := peekSz()
+			if len(in) < want {
+				want = len(in)
+			}
+			if got := len(hdr); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := hdr, in[:len(hdr)]; !bytes.Equal(got, want) {
+				t.Errorf("got: %#v, want: %#v", got, want)
+			}
+			// The header should remain valid after reading.
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				t.Error(err)
+			}
+			if got, want := hdr, in[:len(hdr)]; !bytes.Equal(got, want) {
+				t.Errorf("got: %#v, want: %#v", got, want)
+			}
+		})
+	}
+	t.Run("Short", func(t *testing.T) {
+		in := []byte{0x1F, 0x8B}
+		_, _, hdr, _ := DetectBuffered(bytes.NewReader(in))
+		if got, want := hdr, in; !bytes.Equal(got, want) {
+			t.Errorf("got: %#v, want: %#v", got, want)
+		}
+	})
+}
+
+func TestDetectBytes(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			b := compress(t, c)
+			if got, want := DetectBytes(b), c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+	t.Run("Short", func(t *testing.T) {
+		b := compress(t, KindGzip)[:len(gzipHeader)-1]
+		if got, want := DetectBytes(b), KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestClassify(t *testing.T) {
+	gz := compress(t, KindGzip)
+	tt := []struct {
+		Name string
+		In   []byte
+		Want Compression
+		OK   bool
+	}{
+		{Name: "Gzip", In: gz, Want: KindGzip, OK: true},
+		{Name: "GzipHeader", In: gz[:len(gzipHeader)+1], Want: KindGzip, OK: true},
+		{Name: "GzipShort", In: gz[:len(gzipHeader)], Want: KindNone, OK: false},
+		{Name: "Zstd", In: compress(t, KindZstd)[:len(zstdHeader)], Want: KindZstd, OK: true},
+		{Name: "Xz", In: compress(t, KindXz)[:len(xzHeader)], Want: KindXz, OK: true},
+		{Name: "Empty", In: nil, Want: KindNone, OK: false},
+		{Name: "ShortText", In: []byte("hello"), Want: KindNone, OK: false},
+		{Name: "Text", In: payload, Want: KindNone, OK: true},
+		{Name: "TextExact", In: payload[:peekSz()], Want: KindNone, OK: true},
+		{Name: "TextShort", In: payload[:peekSz()-1], Want: KindNone, OK: false},
+	}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			got, ok := Classify(tc.In)
+			if got != tc.Want || ok != tc.OK {
+				t.Errorf("got: %v, %v, want: %v, %v", got, ok, tc.Want, tc.OK)
+			}
+			if got, want := got, DetectBytes(tc.In); got != want {
+				t.Errorf("DetectBytes: got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBzip2Concatenated(t *testing.T) {
+	other, err := os.ReadFile("testdata/payload_upper.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := append(compress(t, KindBzip2), other...)
+	rc, kind, err := Detect(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, want := kind, KindBzip2; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(bytes.Clone(payload), bytes.ToUpper(payload)...); !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func TestZstdConcatenated(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	upper := bytes.ToUpper(payload)
+	frame := func(b []byte) []byte { return enc.EncodeAll(b, nil) }
+	skip := func(n byte, data string) []byte {
+		b := binary.LittleEndian.AppendUint32(nil, 0x184D2A50|uint32(n))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+		return append(b, data...)
+	}
+	tt := []struct {
+		Name   string
+		Frames [][]byte
+		Want   []byte
+	}{
+		{
+			Name:   "Data",
+			Frames: [][]byte{frame(payload), frame(upper)},
+			Want:   append(bytes.Clone(payload), upper...),
+		},
+		{
+			Name:   "Skippable",
+			Frames: [][]byte{frame(payload), skip(0, "skip me"), frame(upper)},
+			Want:   append(bytes.Clone(payload), upper...),
+		},
+		{
+			Name:   "SkippableMagics",
+			Frames: [][]byte{frame(payload), skip(0x5, "five"), skip(0xF, ""), frame(upper), skip(0xA, "ten")},
+			Want:   append(bytes.Clone(payload), upper...),
+		},
+		{
+			Name:   "Empty",
+			Frames: [][]byte{frame(payload), frame(nil), frame(upper)},
+			Want:   append(bytes.Clone(payload), upper...),
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			in := bytes.Join(tc.Frames, nil)
+			rc, kind, err := Detect(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, KindZstd; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.Want) {
+				t.Errorf("got %d bytes, want %d bytes", len(got), len(tc.Want))
+			}
+		})
+	}
+}
+
+// TestZstdFixtures checks detection of zstd streams written by tools that
+// add skippable frames.
+//
+// "testdata/seekable.zst" is the payload in four frames written by the zstd
+// tool, followed by a seek table in the seekable format. "testdata/pzstd.zst"
+// is the same frames, each preceded by a skippable frame holding its size, as
+// pzstd writes.
+func TestZstdFixtures(t *testing.T) {
+	for _, name := range []string{"seekable.zst", "pzstd.zst"} {
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc, kind, err := Detect(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, KindZstd; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("got %d bytes, want %d bytes", len(got), len(payload))
+			}
+		})
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	for _, c := range []Compression{KindGzip, KindZstd, KindLz4, KindNone} {
+		t.Run(c.String(), func(t *testing.T) {
+			rc, err := Reader(bytes.NewReader(compress(t, c)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if _, ok := rc.(io.WriterTo); !ok {
+				t.Fatalf("%T does not implement io.WriterTo", rc)
+			}
+			// If io.Copy uses the WriteTo method, ReadFrom is never called.
+			w := &sentinelWriter{t: t}
+			if _, err := io.Copy(w, rc); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(w.Bytes(), payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+}
+
+// SentinelWriter fails the test if its ReadFrom method is called.
+type sentinelWriter struct {
+	t *testing.T
+	bytes.Buffer
+}
+
+func (w *sentinelWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.t.Error("ReadFrom called")
+	return w.Buffer.ReadFrom(r)
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	for _, c := range []Compression{KindGzip, KindZstd} {
+		in := compress(b, c)
+		b.Run(c.String(), func(b *testing.B) {
+			b.Run("WriteTo", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(payload)))
+				for i := 0; i < b.N; i++ {
+					rc, err := Reader(bytes.NewReader(in))
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.Copy(io.Discard, rc); err != nil {
+						b.Fatal(err)
+					}
+					rc.Close()
+				}
+			})
+			b.Run("Read", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(payload)))
+				for i := 0; i < b.N; i++ {
+					rc, err := Reader(bytes.NewReader(in))
+					if err != nil {
+						b.Fatal(err)
+					}
+					// Hide the WriteTo method.
+					r := struct{ io.Reader }{rc}
+					if _, err := io.Copy(io.Discard, r); err != nil {
+						b.Fatal(err)
+					}
+					rc.Close()
+				}
+			})
+		})
+	}
+}
+
+func TestTar(t *testing.T) {
+	mkTar := func(t *testing.T, f tar.Format, name string) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		w := tar.NewWriter(&buf)
+		if err := w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(payload)),
+			Mode:     0o644,
+			Format:   f,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	check := func(t *testing.T, in []byte, want Compression) {
+		t.Helper()
+		rc, kind, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got := kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Error("content mismatch")
+		}
+	}
+
+	t.Run("USTAR", func(t *testing.T) {
+		check(t, mkTar(t, tar.FormatUSTAR, "payload"), KindTar)
+	})
+	t.Run("PAX", func(t *testing.T) {
+		check(t, mkTar(t, tar.FormatPAX, "payload"), KindTar)
+	})
+	t.Run("GNU", func(t *testing.T) {
+		check(t, mkTar(t, tar.FormatGNU, "payload"), KindTar)
+	})
+	// Names that start with a valid zlib header.
+	t.Run("ZlibName", func(t *testing.T) {
+		for _, n := range []string{"x^file", "hCache/x", "XGL/conf"} {
+			in := mkTar(t, tar.FormatUSTAR, n)
+			if _, _, ok := zlibHeader(in); !ok {
+				t.Errorf("%q: not a zlib header", n)
+			}
+			check(t, in, KindTar)
+		}
+	})
+	t.Run("Binary", func(t *testing.T) {
+		b := make([]byte, 1024)
+		rand.New(rand.NewSource(0)).Read(b)
+		// Make sure this doesn't accidentally look like zlib.
+		b[0] = 0
+		check(t, b, KindNone)
+	})
+	t.Run("Gzip", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(mkTar(t, tar.FormatPAX, "payload")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := DetectBytes(buf.Bytes()), KindGzip; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestDetectAt(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			r := bytes.NewReader(compress(t, c))
+			kind, err := DetectAt(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if got, want := r.Len(), int(r.Size()); got != want {
+				t.Errorf("reader modified: got: %d, want: %d", got, want)
+			}
+		})
+	}
+	t.Run("Short", func(t *testing.T) {
+		kind, err := DetectAt(bytes.NewReader(gzipHeader[:2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Empty", func(t *testing.T) {
+		kind, err := DetectAt(bytes.NewReader(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestDetectPeek(t *testing.T) {
+	// PeekFrom returns a callback serving the first bytes of "b", checking
+	// that it's asked for peekSz bytes.
+	peekFrom := func(t *testing.T, b []byte, err error) func(int) ([]byte, error) {
+		return func(n int) ([]byte, error) {
+			if got, want := n, peekSz(); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if len(b) < n {
+				return b, err
+			}
+			return b[:n], nil
+		}
+	}
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			kind, err := DetectPeek(peekFrom(t, compress(t, c), io.EOF))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+	t.Run("Short", func(t *testing.T) {
+		kind, err := DetectPeek(peekFrom(t, gzipHeader[:2], io.ErrUnexpectedEOF))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("ShortNoError", func(t *testing.T) {
+		// A complete, short stream.
+		b := compress(t, KindZstd)[:8]
+		kind, err := DetectPeek(peekFrom(t, b, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Long", func(t *testing.T) {
+		kind, err := DetectPeek(func(int) ([]byte, error) {
+			return compress(t, KindXz), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindXz; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Error", func(t *testing.T) {
+		want := errors.New("oops")
+		_, err := DetectPeek(peekFrom(t, nil, want))
+		if !errors.Is(err, want) {
+			t.Errorf("got: %v, want: %v", err, want)
+		}
+	})
+}
+
+func TestUnderlying(t *testing.T) {
+	t.Run("Gzip", func(t *testing.T) {
+		const name = "payload.txt"
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Name = name
+		if _, err := w.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rc, _, err := DetectOpts(&buf, ReaderOpts{MaxSize: int64(len(payload))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		z, ok := rc.(Decoder).Underlying().(*gzip.Reader)
+		if !ok {
+			t.Fatalf("unexpected underlying type: %T", rc.(Decoder).Underlying())
+		}
+		if got, want := z.Name, name; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+	t.Run("None", func(t *testing.T) {
+		rc, _, err := DetectCounting(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got := rc.Underlying(); got != nil {
+			t.Errorf("got: %T, want: <nil>", got)
+		}
+	})
+}
+
+func TestGzipHeader(t *testing.T) {
+	want := gzip.Header{
+		Name:    "payload.txt",
+		Comment: "the quick brown fox",
+		ModTime: time.Date(2023, time.June, 1, 12, 0, 0, 0, time.UTC),
+		OS:      3, // Unix
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Header = want
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, kind, err := Detect(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, want := kind, KindGzip; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	got, ok := GzipHeader(rc)
+	if !ok {
+		t.Fatal("no gzip header")
+	}
+	if got.Name != want.Name || got.Comment != want.Comment || got.OS != want.OS {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+	if !got.ModTime.Equal(want.ModTime) {
+		t.Errorf("got: %v, want: %v", got.ModTime, want.ModTime)
+	}
+
+	t.Run("NotGzip", func(t *testing.T) {
+		rc, _, err := Detect(bytes.NewReader(compress(t, KindZstd)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, ok := GzipHeader(rc); ok {
+			t.Error("unexpected gzip header")
+		}
+	})
+}
+
+func TestGzipFlags(t *testing.T) {
+	// Gzipped returns "payload" compressed with the header "h", then sets the
+	// FTEXT and FHCRC flags by hand, as the writer doesn't.
+	gzipped := func(t *testing.T, h gzip.Header, text, hcrc bool) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Header = h
+		if _, err := w.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		if text {
+			b[3] |= byte(GzipFText)
+		}
+		if hcrc {
+			// Only valid for headers without optional fields, which end
+			// at byte 10.
+			b[3] |= byte(GzipFHCRC)
+			crc := crc32.ChecksumIEEE(b[:10])
+			b = append(b[:10:10], append(binary.LittleEndian.AppendUint16(nil, uint16(crc)), b[10:]...)...)
+		}
+		return b
+	}
+	tt := []struct {
+		Name       string
+		Header     gzip.Header
+		Text, HCRC bool
+		Want       GzipFlag
+	}{
+		{Name: "None"},
+		{Name: "Text", Text: true, Want: GzipFText},
+		{Name: "HCRC", HCRC: true, Want: GzipFHCRC},
+		{Name: "Extra", Header: gzip.Header{Extra: []byte("xx")}, Want: GzipFExtra},
+		{Name: "Name", Header: gzip.Header{Name: "payload.txt"}, Want: GzipFName},
+		{Name: "Comment", Header: gzip.Header{Comment: "fox"}, Want: GzipFComment},
+		{
+			Name:   "All",
+			Header: gzip.Header{Extra: []byte("xx"), Name: "payload.txt", Comment: "fox"},
+			Text:   true,
+			Want:   GzipFText | GzipFExtra | GzipFName | GzipFComment,
+		},
+	}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			b := gzipped(t, tc.Header, tc.Text, tc.HCRC)
+			rc, kind, err := DetectOpts(bytes.NewReader(b), ReaderOpts{MaxSize: 1 << 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, KindGzip; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, ok := GzipFlags(rc)
+			if !ok {
+				t.Fatal("no gzip flags")
+			}
+			if want := tc.Want; got != want {
+				t.Errorf("got: %#02x, want: %#02x", got, want)
+			}
+			// The flags don't change decoding.
+			out, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, payload) {
+				t.Error("payload mismatch")
+			}
+
+			var z ReusableReader
+			defer z.Close()
+			if _, err := z.Reset(bytes.NewReader(b)); err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := GzipFlags(&z); !ok || got != tc.Want {
+				t.Errorf("ReusableReader: got: %#02x, %v, want: %#02x", got, ok, tc.Want)
+			}
+		})
+	}
+
+	t.Run("NotGzip", func(t *testing.T) {
+		rc, _, err := Detect(bytes.NewReader(compress(t, KindZstd)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, ok := GzipFlags(rc); ok {
+			t.Error("unexpected gzip flags")
+		}
+	})
+}
+
+func TestLzma(t *testing.T) {
+	t.Run("Fixture", func(t *testing.T) {
+		b, err := os.ReadFile("testdata/payload.lzma")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, kind, err := Detect(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindLzma; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Writer", func(t *testing.T) {
+		if got, want := DetectBytes(compress(t, KindLzma)), KindLzma; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("NearMiss", func(t *testing.T) {
+		tt := []struct {
+			Name string
+			In   []byte
+		}{
+			{
+				Name: "Properties",
+				In:   []byte{0xE1, 0x00, 0x00, 0x00, 0x04, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+			},
+			{
+				Name: "DictSize",
+				In:   []byte{0x5D, 0x00, 0x00, 0x00, 0x05, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+			},
+			{
+				Name: "SmallDict",
+				In:   []byte{0x5D, 0x00, 0x08, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+			},
+			{
+				Name: "Size",
+				In:   []byte{0x5D, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00},
+			},
+			{
+				Name: "Text",
+				In:   payload,
+			},
+		}
+		for _, tc := range tt {
+			t.Run(tc.Name, func(t *testing.T) {
+				if got, want := DetectBytes(tc.In), KindNone; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+			})
+		}
+	})
+}
+
+func TestDetectConfidence(t *testing.T) {
+	check := func(t *testing.T, in []byte, kind Compression, ok func(float64) bool) {
+		t.Helper()
+		rc, c, conf, err := DetectConfidence(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := c, kind; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if !ok(conf) {
+			t.Errorf("unexpected confidence: %v", conf)
+		}
+	}
+	certain := func(f float64) bool { return f == 1 }
+	low := func(f float64) bool { return f > 0 && f < LowConfidence }
+
+	t.Run("Gzip", func(t *testing.T) {
+		check(t, compress(t, KindGzip), KindGzip, certain)
+	})
+	t.Run("Zstd", func(t *testing.T) {
+		check(t, compress(t, KindZstd), KindZstd, certain)
+	})
+	t.Run("Zlib", func(t *testing.T) {
+		check(t, compress(t, KindZlib), KindZlib, low)
+	})
+	t.Run("ZlibLooking", func(t *testing.T) {
+		// Some binary data that happens to start with a valid zlib header.
+		b := make([]byte, 512)
+		rand.New(rand.NewSource(0)).Read(b)
+		b[0], b[1] = 0x78, 0x01
+		check(t, b, KindZlib, low)
+	})
+	t.Run("None", func(t *testing.T) {
+		check(t, payload, KindNone, func(f float64) bool { return f == 0 })
+	})
+}
+
+func TestVerify(t *testing.T) {
+	for _, c := range []Compression{KindGzip, KindZlib} {
+		t.Run(c.String(), func(t *testing.T) {
+			check := func(t *testing.T, in []byte, ok bool) {
+				t.Helper()
+				rc, kind, err := Detect(bytes.NewReader(in))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rc.Close()
+				if got, want := kind, c; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				// Read only a little to start.
+				if _, err := io.ReadFull(rc, make([]byte, 16)); err != nil {
+					t.Fatal(err)
+				}
+				err = Verify(rc)
+				if got, want := err == nil, ok; got != want {
+					t.Errorf("got: %v, want: %v", err, ok)
+				}
+			}
+			t.Run("OK", func(t *testing.T) {
+				check(t, compress(t, c), true)
+			})
+			t.Run("Truncated", func(t *testing.T) {
+				in := compress(t, c)
+				check(t, in[:len(in)-2], false)
+			})
+			t.Run("BadChecksum", func(t *testing.T) {
+				in := compress(t, c)
+				// Gzip ends with the CRC and then length, zlib with the
+				// checksum.
+				off := len(in) - 1
+				if c == KindGzip {
+					off -= 4
+				}
+				in[off] ^= 0x01
+				check(t, in, false)
+			})
+		})
+	}
+}
+
+func TestSnappy(t *testing.T) {
+	t.Run("Framed", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(compress(t, KindSnappy)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindSnappy; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Raw", func(t *testing.T) {
+		in := snappy.Encode(nil, payload)
+		rc, kind, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Error("raw snappy block modified")
+		}
+	})
+	t.Run("Short", func(t *testing.T) {
+		// The whole stream is shorter than the number of bytes needed for
+		// detection of every scheme.
+		const want = "hello"
+		var buf bytes.Buffer
+		w := snappy.NewBufferedWriter(&buf)
+		if _, err := io.WriteString(w, want); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() >= peekSz() {
+			t.Fatalf("stream too long: %d bytes", buf.Len())
+		}
+		rc, kind, err := Detect(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindSnappy; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(got); got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+}
+
+func BenchmarkDetectBytes(b *testing.B) {
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	if err := tw.WriteHeader(&tar.Header{Name: "a", Mode: 0o644, Size: 0, Format: tar.FormatUSTAR}); err != nil {
+		b.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	in := map[string][]byte{
+		"Tar": tarball.Bytes(),
+	}
+	for _, c := range allKinds {
+		in[c.String()] = compress(b, c)
+	}
+	for name, data := range in {
+		want := DetectBytes(data)
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if got := DetectBytes(data); got != want {
+					b.Fatalf("got: %v, want: %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectTee(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			in := compress(t, c)
+			var tee bytes.Buffer
+			rc, kind, err := DetectTee(bytes.NewReader(in), &tee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+			if got, want := tee.Bytes(), in; !bytes.Equal(got, want) {
+				t.Errorf("teed %d bytes, want %d bytes", len(got), len(want))
+			}
+		})
+	}
+}
+
+// Pbzx returns "payload" as a single xz chunk in a pbzx container: the magic,
+// the chunk size, then each chunk's flags and length before its data.
+func pbzx(t testing.TB) []byte {
+	t.Helper()
+	z := compress(t, KindXz)
+	b := []byte("pbzx")
+	b = binary.BigEndian.AppendUint64(b, 16<<20)
+	b = binary.BigEndian.AppendUint64(b, 0)
+	b = binary.BigEndian.AppendUint64(b, uint64(len(z)))
+	return append(b, z...)
+}
+
+func TestUnsupported(t *testing.T) {
+	tt := []struct {
+		Format  string
+		In      []byte
+		Archive bool
+	}{
+		{Format: "7z", In: []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C, 0x00, 0x04}, Archive: true},
+		{Format: "zip", In: append([]byte{'P', 'K', 0x03, 0x04}, payload...), Archive: true},
+		{Format: "lzip", In: []byte("LZIP\x01\x0c")},
+		{Format: "compress", In: append([]byte{0x1F, 0x9D, 0x90}, payload...)},
+		{Format: "pbzx", In: pbzx(t)},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Format, func(t *testing.T) {
+			rc, kind, err := Detect(bytes.NewReader(tc.In))
+			if !errors.Is(err, ErrUnsupportedScheme) {
+				t.Fatalf("got: %v, want: %v", err, ErrUnsupportedScheme)
+			}
+			var ue *UnsupportedError
+			if !errors.As(err, &ue) {
+				t.Fatalf("unexpected error type: %T", err)
+			}
+			if got, want := ue.Format, tc.Format; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := errors.Is(err, ErrUnsupportedArchive), tc.Archive; got != want {
+				t.Errorf("archive: got: %v, want: %v", got, want)
+			}
+			if got, want := kind, KindNone; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.In) {
+				t.Error("stream modified")
+			}
+		})
+	}
+	t.Run("Supported", func(t *testing.T) {
+		for _, c := range allKinds {
+			if _, _, err := Detect(bytes.NewReader(compress(t, c))); err != nil {
+				t.Errorf("%v: %v", c, err)
+			}
+		}
+	})
+}
+
+func TestEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	emptyGzip := buf.Bytes()
+
+	t.Run("ValidGzip", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(emptyGzip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindGzip; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if !rc.(Decoder).Empty() {
+			t.Error("stream not reported as empty")
+		}
+	})
+	t.Run("ZeroLength", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if !rc.(Decoder).Empty() {
+			t.Error("stream not reported as empty")
+		}
+	})
+	t.Run("TruncatedHeader", func(t *testing.T) {
+		in := emptyGzip[:5]
+		if _, _, err := Detect(bytes.NewReader(in)); err == nil {
+			t.Error("expected error for truncated header")
+		}
+		rc, kind, err := DetectOpts(bytes.NewReader(in), ReaderOpts{FallbackOnError: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if rc.(Decoder).Empty() {
+			t.Error("stream reported as empty")
+		}
+	})
+	t.Run("NotEmpty", func(t *testing.T) {
+		rc, _, err := DetectOpts(bytes.NewReader(compress(t, KindZstd)), ReaderOpts{MaxSize: int64(len(payload))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if rc.(Decoder).Empty() {
+			t.Error("stream reported as empty")
+		}
+		// Check that the peeked byte isn't lost.
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+}
+
+func TestPeek(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			in := compress(t, c)
+			kind, r, err := Peek(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Errorf("read %d bytes, want %d identical bytes", len(got), len(in))
+			}
+		})
+	}
+	t.Run("Short", func(t *testing.T) {
+		in := []byte("hi")
+		kind, r, err := Peek(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Errorf("got: %q, want: %q", got, in)
+		}
+	})
+}
+
+func TestDeflate(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	in := buf.Bytes()
+
+	t.Run("ReaderWith", func(t *testing.T) {
+		rc, err := ReaderWith(bytes.NewReader(in), KindDeflate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+	t.Run("Detect", func(t *testing.T) {
+		rc, kind, err := Detect(bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if kind == KindDeflate {
+			t.Errorf("got: %v, want: anything else", kind)
+		}
+	})
+}
+
+func TestNilReader(t *testing.T) {
+	t.Run("Reader", func(t *testing.T) {
+		rc, err := Reader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		n, err := rc.Read(make([]byte, 1))
+		if n != 0 || !errors.Is(err, io.EOF) {
+			t.Errorf("got: %d, %v; want: 0, %v", n, err, io.EOF)
+		}
+	})
+	t.Run("Detect", func(t *testing.T) {
+		rc, kind, err := Detect(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if !rc.(Decoder).Empty() {
+			t.Error("expected empty stream")
+		}
+	})
+	t.Run("Peek", func(t *testing.T) {
+		kind, r, err := Peek(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		b, err := io.ReadAll(r)
+		if len(b) != 0 || err != nil {
+			t.Errorf("got: %q, %v; want: empty, <nil>", b, err)
+		}
+	})
+}
+
+func TestCloseTwice(t *testing.T) {
+	for _, c := range allKinds {
+		t.Run(c.String(), func(t *testing.T) {
+			rc, err := ReaderWith(bytes.NewReader(compress(t, c)), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.Copy(io.Discard, rc); err != nil {
+				t.Error(err)
+			}
+			if err := rc.Close(); err != nil {
+				t.Errorf("first close: %v", err)
+			}
+			if err := rc.Close(); err != nil {
+				t.Errorf("second close: %v", err)
+			}
+		})
+	}
+	t.Run("ParallelBzip2", func(t *testing.T) {
+		rc, _, err := DetectOpts(bytes.NewReader(compress(t, KindBzip2)), ReaderOpts{ParallelBzip2: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := rc.Close(); err != nil {
+				t.Errorf("close %d: %v", i, err)
+			}
+		}
+	})
+}
+
+func TestSupportedKinds(t *testing.T) {
+	all := SupportedKinds()
+	// Magic numbers are checked before weak headers.
+	want := []Compression{
+		KindGzip,
+		KindZstd,
+		KindBzip2,
+		KindXz,
+		KindLz4,
+		KindTar,
+		KindSnappy,
+		KindZlib,
+		KindLzma,
+	}
+	// Other tests may have registered detectors.
+	var got []Compression
+	for _, c := range all {
+		if !c.Registered() {
+			got = append(got, c)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	for _, c := range want {
+		d := &detectors[c]
+		want := append(make([]byte, d.Offset), d.Mask...)
+		m := HeaderMask(c)
+		if !bytes.Equal(m, want) {
+			t.Errorf("%v: got: %x, want: %x", c, m, want)
+		}
+		m[len(m)-1] ^= 0xFF
+		if bytes.Equal(m[d.Offset:], d.Mask) {
+			t.Errorf("%v: mask is not a copy", c)
+		}
+	}
+	for _, c := range []Compression{KindBrotli, KindDeflate, KindNone, -1} {
+		if m := HeaderMask(c); m != nil {
+			t.Errorf("%v: got: %x, want: nil", c, m)
+		}
+	}
+}
+
+func TestGzipReservedFlags(t *testing.T) {
+	in := compress(t, KindGzip)
+	for _, bit := range []byte{1 << 5, 1 << 6, 1 << 7} {
+		b := bytes.Clone(in)
+		b[3] |= bit
+		if got, want := DetectBytes(b), KindNone; got != want {
+			t.Errorf("flag %#02x: got: %v, want: %v", bit, got, want)
+		}
+	}
+	// The defined flags don't affect detection.
+	b := bytes.Clone(in)
+	b[3] |= 0x1F
+	if got, want := DetectBytes(b), KindGzip; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func BenchmarkDetect(b *testing.B) {
+	// NoMatch runs every detector without a match.
+	noMatch := bytes.Repeat([]byte{0x7F}, peekSz())
+	run := func(b *testing.B, in []byte, opts ReaderOpts) {
+		b.ReportAllocs()
+		r := bytes.NewReader(in)
+		for i := 0; i < b.N; i++ {
+			r.Reset(in)
+			rc, _, err := DetectOpts(r, opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			rc.Close()
+		}
+	}
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		in := compress(b, c)
+		b.Run(c.String(), func(b *testing.B) { run(b, in, ReaderOpts{}) })
+	}
+	b.Run("NoMatch", func(b *testing.B) {
+		// The default buffer size uses pooled bufio.Readers, while setting
+		// it explicitly does not.
+		b.Run("Pooled", func(b *testing.B) { run(b, noMatch, ReaderOpts{}) })
+		b.Run("Unpooled", func(b *testing.B) { run(b, noMatch, ReaderOpts{BufferSize: 4096}) })
+	})
+}
+
+func TestBufioPool(t *testing.T) {
+	// Closed Decoders return their buffer to the pool, so make sure reusing
+	// it doesn't change results.
+	for i := 0; i < 3; i++ {
+		for _, c := range allKinds {
+			if c == KindBrotli {
+				continue
+			}
+			rc, kind, err := Detect(bytes.NewReader(compress(t, c)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("%v: payload mismatch", c)
+			}
+			if err := rc.Close(); err != nil {
+				t.Error(err)
+			}
+			if _, err := rc.Read(make([]byte, 1)); err == nil {
+				t.Errorf("%v: expected error reading after close", c)
+			}
+		}
+	}
+}
+
+func TestDecompressAll(t *testing.T) {
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			got, kind, err := DecompressAll(bytes.NewReader(compress(t, c)), int64(len(payload)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, c; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Error("payload mismatch")
+			}
+		})
+	}
+	t.Run("Limit", func(t *testing.T) {
+		got, kind, err := DecompressAll(bytes.NewReader(compress(t, KindGzip)), int64(len(payload)-1))
+		if !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeLimit)
+		}
+		if got != nil {
+			t.Errorf("got %d bytes, want nil", len(got))
+		}
+		if got, want := kind, KindGzip; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("NoLimit", func(t *testing.T) {
+		got, _, err := DecompressAll(bytes.NewReader(compress(t, KindZstd)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	})
+}
+
+func TestDetectReader(t *testing.T) {
+	run := func(t *testing.T, c Compression, sz int) {
+		br := bufio.NewReaderSize(bytes.NewReader(compress(t, c)), sz)
+		rc, kind, err := DetectReader(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, want := kind, c; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("payload mismatch")
+		}
+	}
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		t.Run(c.String(), func(t *testing.T) {
+			run(t, c, 64*1024)
+		})
+	}
+	t.Run("Small", func(t *testing.T) {
+		// Smaller than peekSz, so it has to be wrapped.
+		run(t, KindGzip, 16)
+	})
+	t.Run("Nil", func(t *testing.T) {
+		rc, kind, err := DetectReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := kind, KindNone; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if n, err := rc.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+			t.Errorf("got: %d, %v, want: 0, %v", n, err, io.EOF)
+		}
+	})
+}
+
+// CancelWriter cancels a Context after its first Write.
+//
+// The Buffer isn't embedded, so that io.Copy can't use its ReadFrom method.
+type cancelWriter struct {
+	buf    bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(b []byte) (int, error) {
+	w.cancel()
+	return w.buf.Write(b)
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Ok", func(t *testing.T) {
+		for _, c := range allKinds {
+			if c == KindBrotli {
+				continue
+			}
+			t.Run(c.String(), func(t *testing.T) {
+				var buf bytes.Buffer
+				n, kind, err := Copy(ctx, &buf, bytes.NewReader(compress(t, c)), int64(len(payload)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := kind, c; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				if got, want := n, int64(len(payload)); got != want {
+					t.Errorf("got: %d, want: %d", got, want)
+				}
+				if !bytes.Equal(buf.Bytes(), payload) {
+					t.Error("payload mismatch")
+				}
+			})
+		}
+	})
+	t.Run("Limit", func(t *testing.T) {
+		var buf bytes.Buffer
+		max := int64(len(payload) / 2)
+		n, kind, err := Copy(ctx, &buf, bytes.NewReader(compress(t, KindZstd)), max)
+		if !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeLimit)
+		}
+		if got, want := kind, KindZstd; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		if n > max {
+			t.Errorf("wrote %d bytes, more than the limit %d", n, max)
+		}
+		if got, want := int64(buf.Len()), n; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		var buf bytes.Buffer
+		n, _, err := Copy(ctx, &buf, bytes.NewReader(compress(t, KindGzip)), 0)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+		if n != 0 {
+			t.Errorf("got: %d, want: 0", n)
+		}
+	})
+	t.Run("CanceledMidCopy", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		w := &cancelWriter{cancel: cancel}
+		n, _, err := Copy(ctx, w, bytes.NewReader(compress(t, KindGzip)), 0)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+		if n >= int64(len(payload)) {
+			t.Errorf("copied all %d bytes despite cancellation", n)
+		}
+		if got, want := int64(w.buf.Len()), n; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+	})
+}
+
+func TestStreamErrors(t *testing.T) {
+	gz := compress(t, KindGzip)
+	t.Run("Truncated", func(t *testing.T) {
+		for _, c := range allKinds {
+			switch c {
+			case KindNone:
+				continue
+			case KindBrotli, KindSnappy:
+				// These decoders can't tell a short stream from a bad one.
+				continue
+			}
+			c := c
+			t.Run(c.String(), func(t *testing.T) {
+				b := compress(t, c)
+				rd, _, err := Detect(bytes.NewReader(b[:len(b)/2]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rd.Close()
+				_, err = io.Copy(io.Discard, rd)
+				if !errors.Is(err, ErrTruncated) {
+					t.Errorf("got: %v, want: %v", err, ErrTruncated)
+				}
+				if errors.Is(err, ErrCorrupt) {
+					t.Errorf("truncated stream reported as corrupt: %v", err)
+				}
+			})
+		}
+	})
+	t.Run("Corrupt", func(t *testing.T) {
+		b := bytes.Clone(gz)
+		// Flip a bit in the middle of the deflate body.
+		b[len(b)/2] ^= 0x10
+		rd, _, err := Detect(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		_, err = io.Copy(io.Discard, rd)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("got: %v, want: %v", err, ErrCorrupt)
+		}
+		if errors.Is(err, ErrTruncated) {
+			t.Errorf("corrupt stream reported as truncated: %v", err)
+		}
+	})
+	t.Run("Checksum", func(t *testing.T) {
+		b := bytes.Clone(gz)
+		b[len(b)-5] ^= 0xFF // in the CRC32 of the trailer
+		rd, _, err := Detect(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		_, err = io.Copy(io.Discard, rd)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("got: %v, want: %v", err, ErrCorrupt)
+		}
+		if !errors.Is(err, gzip.ErrChecksum) {
+			t.Errorf("got: %v, want: %v", err, gzip.ErrChecksum)
+		}
+	})
+	t.Run("Source", func(t *testing.T) {
+		// Incompressible, so that the error comes after detection.
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := io.CopyN(w, rand.New(rand.NewSource(1)), 1<<20); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		errSrc := errors.New("source failed")
+		src := io.MultiReader(bytes.NewReader(b[:len(b)/2]), iotest.ErrReader(errSrc))
+		rd, _, err := Detect(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		_, err = io.Copy(io.Discard, rd)
+		if !errors.Is(err, errSrc) {
+			t.Errorf("got: %v, want: %v", err, errSrc)
+		}
+		if errors.Is(err, ErrCorrupt) || errors.Is(err, ErrTruncated) {
+			t.Errorf("source error was classified: %v", err)
+		}
+	})
+}
+
+func TestReadByte(t *testing.T) {
+	// readBytes reads "rd" with ReadByte, interleaved with Read so that
+	// both see the same stream.
+	readBytes := func(t *testing.T, rd io.Reader) []byte {
+		t.Helper()
+		br, ok := rd.(io.ByteReader)
+		if !ok {
+			t.Fatalf("%T does not implement io.ByteReader", rd)
+		}
+		var out []byte
+		p := make([]byte, 7)
+		for i := 0; ; i++ {
+			if i%64 == 63 {
+				n, err := rd.Read(p)
+				out = append(out, p[:n]...)
+				if errors.Is(err, io.EOF) {
+					return out
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			b, err := br.ReadByte()
+			if errors.Is(err, io.EOF) {
+				return out
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, b)
+		}
+	}
+	for _, c := range allKinds {
+		if c == KindBrotli {
+			continue
+		}
+		c := c
+		t.Run(c.String(), func(t *testing.T) {
+			t.Run("Detect", func(t *testing.T) {
+				rd, _, err := Detect(bytes.NewReader(compress(t, c)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rd.Close()
+				if !bytes.Equal(readBytes(t, rd), payload) {
+					t.Error("payload mismatch")
+				}
+			})
+			t.Run("Opts", func(t *testing.T) {
+				rd, _, err := DetectOpts(bytes.NewReader(compress(t, c)), ReaderOpts{MaxSize: int64(len(payload))})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rd.Close()
+				if !bytes.Equal(readBytes(t, rd), payload) {
+					t.Error("payload mismatch")
+				}
+			})
+		})
+	}
+	t.Run("Limit", func(t *testing.T) {
+		rd, _, err := DetectOpts(bytes.NewReader(compress(t, KindGzip)), ReaderOpts{MaxSize: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		br := rd.(io.ByteReader)
+		for i := 0; i < 10; i++ {
+			if _, err := br.ReadByte(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := br.ReadByte(); !errors.Is(err, ErrSizeLimit) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeLimit)
+		}
+	})
+	t.Run("Closed", func(t *testing.T) {
+		rd, _, err := Detect(bytes.NewReader(compress(t, KindZstd)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd.Close()
+		if _, err := rd.(io.ByteReader).ReadByte(); err == nil {
+			t.Error("ReadByte after Close succeeded")
+		}
+	})
+	t.Run("NoProgress", func(t *testing.T) {
+		for _, d := range []Decoder{
+			passThrough(emptyReads{}),
+			&byteDecoder{Decoder: passThrough(emptyReads{})},
+		} {
+			if _, err := d.(io.ByteReader).ReadByte(); !errors.Is(err, io.ErrNoProgress) {
+				t.Errorf("%T: got: %v, want: %v", d, err, io.ErrNoProgress)
+			}
+			d.Close()
+		}
+	})
+	t.Run("Underlying", func(t *testing.T) {
+		for _, opts := range []ReaderOpts{{}, {MaxSize: int64(len(payload))}} {
+			rd, _, err := DetectOpts(bytes.NewReader(compress(t, KindGzip)), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rd.Close()
+			d := rd.(Decoder)
+			if _, err := d.(io.ByteReader).ReadByte(); err != nil {
+				t.Fatal(err)
+			}
+			// The gzip.Reader is past the buffered bytes.
+			if got := d.Underlying(); got != nil {
+				t.Errorf("%T: got: %T, want: nil", d, got)
+			}
+			if _, err := io.ReadAll(d); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := d.Underlying().(*gzip.Reader); !ok {
+				t.Errorf("%T: got: %T, want: %T", d, d.Underlying(), (*gzip.Reader)(nil))
+			}
+		}
+	})
+}
+
+// EmptyReads returns no bytes and no error from every Read.
+type emptyReads struct{}
+
+// Read implements [io.Reader].
+func (emptyReads) Read(_ []byte) (int, error) { return 0, nil }
+
+func TestDataWithEOF(t *testing.T) {
+	t.Run("Kinds", func(t *testing.T) {
+		for _, c := range allKinds {
+			if c == KindBrotli {
+				continue
+			}
+			c := c
+			t.Run(c.String(), func(t *testing.T) {
+				src := iotest.DataErrReader(bytes.NewReader(compress(t, c)))
+				rd, kind, err := Detect(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rd.Close()
+				if got, want := kind, c; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				got, err := io.ReadAll(rd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Error("payload mismatch")
+				}
+			})
+		}
+	})
+	// An empty gzip stream is shorter than the detection header, and is
+	// delivered in a single Read along with io.EOF.
+	t.Run("Short", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := gzip.NewWriter(&buf).Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() >= peekSz() {
+			t.Fatalf("test stream is %d bytes, want fewer than %d", buf.Len(), peekSz())
+		}
+		for _, opts := range []ReaderOpts{{}, {FallbackOnError: true}, {DetectTimeout: time.Second}} {
+			src := iotest.DataErrReader(bytes.NewReader(buf.Bytes()))
+			rd, kind, err := DetectOpts(src, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := kind, KindGzip; got != want {
+				t.Errorf("%+v: got: %v, want: %v", opts, got, want)
+			}
+			if b, err := io.ReadAll(rd); err != nil || len(b) != 0 {
+				t.Errorf("%+v: got: %q, %v, want: empty", opts, b, err)
+			}
+			rd.Close()
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package zreader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Zstd seekable format constants.
+//
+// See https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+// for the format.
+const (
+	zstdSeekTableMagic = 0x184D2A5E
+	zstdSeekableMagic  = 0x8F92EAB1
+	zstdSeekFooterSz   = 9
+)
+
+// ZstdSeekFrame is one entry of a seek table, with the offsets of the frame in
+// the compressed and decompressed streams.
+type zstdSeekFrame struct {
+	coff, csize int64
+	doff, dsize int64
+}
+
+// ReadSeekTable reads the seek table from the end of the zstd stream "r",
+// which is "size" bytes long.
+//
+// A nil slice and nil error are returned if the stream does not end with a
+// seek table. An error is returned if there's a seek table that's malformed
+// or doesn't describe the stream.
+func readSeekTable(r io.ReaderAt, size int64) ([]zstdSeekFrame, error) {
+	if size < 8+zstdSeekFooterSz {
+		return nil, nil
+	}
+	var ft [zstdSeekFooterSz]byte
+	if _, err := r.ReadAt(ft[:], size-zstdSeekFooterSz); err != nil {
+		return nil, unexpected(err)
+	}
+	if binary.LittleEndian.Uint32(ft[5:]) != zstdSeekableMagic {
+		return nil, nil
+	}
+	n := int64(binary.LittleEndian.Uint32(ft[:4]))
+	desc := ft[4]
+	if desc&0x7C != 0 {
+		return nil, errors.New("zreader: reserved bits set in zstd seek table descriptor")
+	}
+	entSz := int64(8)
+	if desc&0x80 != 0 {
+		// Per-frame checksums are present, but not checked.
+		entSz = 12
+	}
+	tblSz := n*entSz + zstdSeekFooterSz
+	if 8+tblSz > size {
+		return nil, errors.New("zreader: zstd seek table larger than stream")
+	}
+	start := size - 8 - tblSz
+	tbl := make([]byte, 8+tblSz)
+	if _, err := r.ReadAt(tbl, start); err != nil {
+		return nil, unexpected(err)
+	}
+	if m := binary.LittleEndian.Uint32(tbl); m != zstdSeekTableMagic {
+		return nil, fmt.Errorf("zreader: bad zstd seek table magic: %08x", m)
+	}
+	if sz := int64(binary.LittleEndian.Uint32(tbl[4:])); sz != tblSz {
+		return nil, fmt.Errorf("zreader: bad zstd seek table size: got %d, want %d", sz, tblSz)
+	}
+
+	frames := make([]zstdSeekFrame, n)
+	var coff, doff int64
+	for i := range frames {
+		e := tbl[8+int64(i)*entSz:]
+		f := &frames[i]
+		f.coff, f.doff = coff, doff
+		f.csize = int64(binary.LittleEndian.Uint32(e))
+		f.dsize = int64(binary.LittleEndian.Uint32(e[4:]))
+		coff += f.csize
+		doff += f.dsize
+	}
+	if coff != start {
+		return nil, fmt.Errorf("zreader: zstd seek table describes %d bytes, stream has %d", coff, start)
+	}
+	return frames, nil
+}
+
+// ZstdSeeker implements seeking over a zstd seekable stream by decoding only
+// the frame containing the current offset.
+type zstdSeeker struct {
+	r      io.ReaderAt
+	frames []zstdSeekFrame
+	dec    *zstdReader
+	// Size is the decompressed size of the stream.
+	size int64
+	pos  int64
+	// Cur is the index of the frame "dec" is decoding, or -1, and at is the
+	// decompressed offset "dec" is at.
+	cur int
+	at  int64
+}
+
+var _ io.ReadSeekCloser = (*zstdSeeker)(nil)
+
+// NewZstdSeeker returns a zstdSeeker for the stream "r" described by
+// "frames".
+func newZstdSeeker(r io.ReaderAt, frames []zstdSeekFrame, opts *ReaderOpts) (*zstdSeeker, error) {
+	dec, err := newZstdReader(nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &zstdSeeker{r: r, frames: frames, dec: dec, cur: -1}
+	if len(frames) != 0 {
+		l := frames[len(frames)-1]
+		s.size = l.doff + l.dsize
+	}
+	return s, nil
+}
+
+// Read implements [io.Reader].
+func (s *zstdSeeker) Read(p []byte) (int, error) {
+	if s.dec.dec == nil {
+		return 0, errClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(s.frames), func(i int) bool {
+		f := &s.frames[i]
+		return f.doff+f.dsize > s.pos
+	})
+	f := &s.frames[i]
+	if s.cur != i || s.at > s.pos {
+		if err := s.dec.dec.Reset(io.NewSectionReader(s.r, f.coff, f.csize)); err != nil {
+			s.cur = -1
+			return 0, err
+		}
+		s.cur, s.at = i, f.doff
+	}
+	if s.at < s.pos {
+		n, err := io.CopyN(io.Discard, s.dec, s.pos-s.at)
+		s.at += n
+		if err != nil {
+			s.cur = -1
+			return 0, unexpected(err)
+		}
+	}
+	if rem := f.doff + f.dsize - s.pos; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	n, err := s.dec.Read(p)
+	s.at += int64(n)
+	s.pos += int64(n)
+	if errors.Is(err, io.EOF) {
+		err = nil
+		if s.at < f.doff+f.dsize {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		s.cur = -1
+	}
+	return n, err
+}
+
+// Seek implements [io.Seeker].
+func (s *zstdSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = s.pos + offset
+	case io.SeekEnd:
+		abs = s.size + offset
+	default:
+		return s.pos, fmt.Errorf("zreader: invalid whence: %d", whence)
+	}
+	if abs < 0 {
+		return s.pos, ErrNegativeOffset
+	}
+	s.pos = abs
+	return abs, nil
+}
+
+// Close implements [io.Closer].
+func (s *zstdSeeker) Close() error {
+	return s.dec.Close()
+}
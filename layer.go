@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 
+	"github.com/quay/claircore/internal/zreader"
 	"github.com/quay/claircore/pkg/tarfs"
+	"github.com/quay/claircore/pkg/tmp"
 )
 
 // LayerDescription is a description of a container layer. It should contain
@@ -74,13 +78,27 @@ type Layer struct {
 	Headers map[string][]string `json:"headers"`
 
 	cleanup []io.Closer
+	// Sys is built on first use by sysInit, so layers only read by streaming
+	// scanners never index the whole tar. SysOnce is allocated by Init, which
+	// keeps Layer free of a lock that vet would flag copies of.
+	sysOnce *sync.Once
 	sys     fs.FS
+	sysErr  error
 	rd      io.ReaderAt
 	closed  bool // Used to catch double-closes.
+	// Compression is the scheme the blob passed to Init was compressed with.
+	compression zreader.Compression
 }
 
 // Init initializes a Layer in-place. This is provided for flexibility when
 // constructing a slice of Layers.
+//
+// The blob in "r" is expected to be an uncompressed tar. Unless the media type
+// says it is one, compressed blobs are detected and decompressed to a
+// temporary file that's removed on Close, up to a limit of 64 GiB. The
+// detected scheme is reported by [Layer.Compression]. The [fs.FS] returned by
+// [Layer.FS] is built on its first use, so a blob that is not a valid tar is
+// reported there rather than here.
 func (l *Layer) Init(ctx context.Context, desc *LayerDescription, r io.ReaderAt) error {
 	if l.noFun != nil {
 		return fmt.Errorf("claircore: Init called on already initialized Layer")
@@ -94,6 +112,7 @@ func (l *Layer) Init(ctx context.Context, desc *LayerDescription, r io.ReaderAt)
 	l.URI = desc.URI
 	l.Headers = desc.Headers
 	l.rd = r
+	l.sysOnce = new(sync.Once)
 	defer func() {
 		if success {
 			return
@@ -103,24 +122,33 @@ func (l *Layer) Init(ctx context.Context, desc *LayerDescription, r io.ReaderAt)
 		}
 	}()
 
+	plain := false
 	switch desc.MediaType {
 	case `application/vnd.oci.image.layer.v1.tar`,
-		`application/vnd.oci.image.layer.v1.tar+gzip`,
-		`application/vnd.oci.image.layer.v1.tar+zstd`,
 		`application/vnd.oci.image.layer.nondistributable.v1.tar`,
+		`application/vnd.docker.image.rootfs.diff.tar`:
+		// Trust the media type. The start of a tar can look like a weak
+		// compression header, such as zlib's.
+		plain = true
+	case `application/vnd.oci.image.layer.v1.tar+gzip`,
+		`application/vnd.oci.image.layer.v1.tar+zstd`,
 		`application/vnd.oci.image.layer.nondistributable.v1.tar+gzip`,
-		`application/vnd.oci.image.layer.nondistributable.v1.tar+zstd`:
-		sys, err := tarfs.New(r)
-		switch {
-		case errors.Is(err, nil):
-		default:
-			return fmt.Errorf("claircore: layer %v: unable to create fs.FS: %w", desc.Digest, err)
-		}
-		l.sys = sys
+		`application/vnd.oci.image.layer.nondistributable.v1.tar+zstd`,
+		`application/vnd.docker.image.rootfs.diff.tar.gzip`,
+		`application/vnd.docker.image.rootfs.foreign.diff.tar.gzip`:
 	default:
-		return fmt.Errorf("claircore: layer %v: unknown MediaType %q", desc.Digest, desc.MediaType)
+		// Unknown media types are accepted if the contents can be identified
+		// as a tar or a compressed stream.
+		c, err := zreader.DetectAt(r)
+		if err != nil || c == zreader.KindNone {
+			return fmt.Errorf("claircore: layer %v: unknown MediaType %q", desc.Digest, desc.MediaType)
+		}
+	}
+	if plain {
+		l.compression = zreader.KindNone
+	} else if err := l.decompress(ctx, r, maxLayerSize); err != nil {
+		return fmt.Errorf("claircore: layer %v: unable to decompress: %w", desc.Digest, err)
 	}
-
 	l.noFun = &l
 	_, file, line, _ := runtime.Caller(2)
 	runtime.SetFinalizer(l.noFun, func(_ **Layer) {
@@ -130,6 +158,75 @@ func (l *Layer) Init(ctx context.Context, desc *LayerDescription, r io.ReaderAt)
 	return nil
 }
 
+// MaxLayerSize is the most decompressed bytes [Layer.Init] writes to a
+// temporary file for a compressed blob. It's well past any real layer, and
+// only there so that a decompression bomb can't fill the disk.
+const maxLayerSize = 64 << 30
+
+// Decompress detects the compression scheme of "r" and, if it's compressed,
+// arranges for "rd" to be a temporary file holding the decompressed contents.
+// No more than "max" decompressed bytes are written.
+//
+// Only schemes identified by a magic number are decompressed; a match on a
+// weak header is more likely to be the start of a tar.
+func (l *Layer) decompress(ctx context.Context, r io.ReaderAt, max int64) error {
+	c, err := zreader.DetectAt(r)
+	if err != nil {
+		return err
+	}
+	// Uncompressed layers may or may not be detected as tar, depending on the
+	// format of the first header.
+	if c == zreader.KindTar || c.Confidence() < 1 {
+		l.compression = zreader.KindNone
+		return nil
+	}
+	f, err := tmp.NewFile("", "layer.*.tar")
+	if err != nil {
+		return err
+	}
+	l.cleanup = append(l.cleanup, f)
+	if _, _, err := zreader.Copy(ctx, f, io.NewSectionReader(r, 0, math.MaxInt64), max); err != nil {
+		return err
+	}
+	l.compression = c
+	l.rd = f.File
+	return nil
+}
+
+// Compression reports the name of the compression scheme of the blob the
+// Layer was initialized with, or "none" if it was not compressed. An
+// uninitialized Layer reports the empty string.
+//
+// The contents returned by [Layer.Reader] and [Layer.FS] are always
+// decompressed.
+func (l *Layer) Compression() string {
+	if l.noFun == nil {
+		return ""
+	}
+	// Unlike String, MarshalText knows the names of registered schemes.
+	b, err := l.compression.MarshalText()
+	if err != nil {
+		return l.compression.String()
+	}
+	return string(b)
+}
+
+// SetCompression records "name" as the compression scheme of the Layer's
+// original blob, for fetchers that decompress the blob before passing it to
+// [Layer.Init]. The name is one reported by [Layer.Compression], such as
+// "gzip" or "zstd". The Layer's contents are not changed.
+func (l *Layer) SetCompression(name string) error {
+	if l.noFun == nil {
+		return errors.New("claircore: SetCompression: uninitialized Layer")
+	}
+	var c zreader.Compression
+	if err := c.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("claircore: SetCompression: %w", err)
+	}
+	l.compression = c
+	return nil
+}
+
 // Close releases held resources by this Layer.
 //
 // Not calling Close may cause the program to panic.
@@ -196,12 +293,30 @@ func (l *Layer) FS() (fs.FS, error) {
 	if l.noFun == nil {
 		return nil, errors.New("claircore: unable to return FS: uninitialized Layer")
 	}
-	return l.sys, nil
+	return l.sysInit()
+}
+
+// SysInit builds the layer's [fs.FS] once.
+func (l *Layer) sysInit() (fs.FS, error) {
+	if l.sysOnce == nil {
+		return nil, errors.New("claircore: unable to return FS: uninitialized Layer")
+	}
+	l.sysOnce.Do(func() {
+		sys, err := tarfs.New(l.rd)
+		if err != nil {
+			l.sysErr = fmt.Errorf("claircore: layer %v: unable to create fs.FS: %w", l.Hash, err)
+			return
+		}
+		l.sys = sys
+	})
+	return l.sys, l.sysErr
 }
 
 // Reader returns a [ReadAtCloser] of the layer.
 //
-// It should also implement [io.Seeker], and should be a tar stream.
+// It should also implement [io.Seeker], and should be a tar stream. If the blob
+// the Layer was initialized with was compressed, this reads the decompressed
+// contents.
 func (l *Layer) Reader() (ReadAtCloser, error) {
 	if l.noFun == nil {
 		return nil, errors.New("claircore: unable to return Reader: uninitialized Layer")
@@ -218,6 +333,12 @@ func (l *Layer) Reader() (ReadAtCloser, error) {
 			SectionReader: io.NewSectionReader(rd, 0, fi.Size()),
 			File:          rd,
 		}, nil
+	case interface {
+		io.ReaderAt
+		Size() int64
+	}:
+		// For example, a [bytes.Reader] over a memory-mapped file.
+		return &rac{io.NewSectionReader(rd, 0, rd.Size())}, nil
 	default:
 	}
 	// Doing this with no size breaks the "seek to the end trick".
@@ -311,9 +432,13 @@ func (l *Layer) Files(paths ...string) (map[string]*bytes.Buffer, error) {
 		want[p] = struct{}{}
 	}
 
+	sys, err := l.sysInit()
+	if err != nil {
+		return nil, err
+	}
 	f := make(map[string]*bytes.Buffer)
 	// Walk the fs. ReadFile will handle symlink resolution.
-	if err := fs.WalkDir(l.sys, ".", func(p string, d fs.DirEntry, err error) error {
+	if err := fs.WalkDir(sys, ".", func(p string, d fs.DirEntry, err error) error {
 		switch {
 		case err != nil:
 			return err
@@ -324,7 +449,7 @@ func (l *Layer) Files(paths ...string) (map[string]*bytes.Buffer, error) {
 			return nil
 		}
 		delete(want, p)
-		b, err := fs.ReadFile(l.sys, p)
+		b, err := fs.ReadFile(sys, p)
 		if err != nil {
 			return err
 		}
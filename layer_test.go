@@ -1,14 +1,19 @@
 package claircore_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/test"
 )
@@ -94,6 +99,27 @@ func TestLayer(t *testing.T) {
 				t.Error("unexpected success")
 			}
 		})
+		t.Run("NotTar", func(t *testing.T) {
+			var l claircore.Layer
+			desc := claircore.LayerDescription{
+				Digest:    "sha256:" + strings.Repeat("00c0ffee", 8),
+				MediaType: `application/vnd.oci.image.layer.v1.tar`,
+			}
+			// The fs.FS is built on first use, so Init doesn't read the tar.
+			if err := l.Init(ctx, &desc, bytes.NewReader([]byte("not a tar"))); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if err := l.Close(); err != nil {
+					t.Errorf("close error: %v", err)
+				}
+			})
+			_, err := l.FS()
+			t.Logf("error: %v", err)
+			if err == nil {
+				t.Error("unexpected success")
+			}
+		})
 	})
 	t.Run("Close", func(t *testing.T) {
 		t.Run("Success", func(t *testing.T) {
@@ -209,4 +235,191 @@ func TestLayer(t *testing.T) {
 			}
 		})
 	})
+	t.Run("Compressed", func(t *testing.T) {
+		const (
+			name     = "etc/os-release"
+			contents = "ID=test\n"
+		)
+		var layer bytes.Buffer
+		tw := tar.NewWriter(&layer)
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(contents)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, contents); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		tt := []struct {
+			Name      string
+			MediaType string
+			Compress  func(io.Writer) (io.WriteCloser, error)
+		}{
+			{
+				Name:      "gzip",
+				MediaType: `application/vnd.oci.image.layer.v1.tar+gzip`,
+				Compress: func(w io.Writer) (io.WriteCloser, error) {
+					return gzip.NewWriter(w), nil
+				},
+			},
+			{
+				Name:      "zstd",
+				MediaType: `application/vnd.oci.image.layer.v1.tar+zstd`,
+				Compress: func(w io.Writer) (io.WriteCloser, error) {
+					return zstd.NewWriter(w)
+				},
+			},
+			{
+				Name:      "gzip",
+				MediaType: `application/vnd.docker.image.rootfs.diff.tar.gzip`,
+				Compress: func(w io.Writer) (io.WriteCloser, error) {
+					return gzip.NewWriter(w), nil
+				},
+			},
+			{
+				// Unknown media types fall back to detection.
+				Name:      "zstd",
+				MediaType: `application/octet-stream`,
+				Compress: func(w io.Writer) (io.WriteCloser, error) {
+					return zstd.NewWriter(w)
+				},
+			},
+		}
+		for _, tc := range tt {
+			t.Run(tc.Name, func(t *testing.T) {
+				var blob bytes.Buffer
+				zw, err := tc.Compress(&blob)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := zw.Write(layer.Bytes()); err != nil {
+					t.Fatal(err)
+				}
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				var l claircore.Layer
+				desc := claircore.LayerDescription{
+					Digest:    "sha256:" + strings.Repeat("00c0ffee", 8),
+					MediaType: tc.MediaType,
+				}
+				if err := l.Init(ctx, &desc, bytes.NewReader(blob.Bytes())); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				t.Cleanup(func() {
+					if err := l.Close(); err != nil {
+						t.Errorf("close error: %v", err)
+					}
+				})
+				if got, want := l.Compression(), tc.Name; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+
+				sys, err := l.FS()
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := fs.ReadFile(sys, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(b), contents; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+
+				rac, err := l.Reader()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rac.Close()
+				h, err := tar.NewReader(rac).Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := h.Name, name; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			})
+		}
+	})
+	// Tars whose first entry's name starts with a valid zlib header.
+	t.Run("ZlibName", func(t *testing.T) {
+		const contents = "ID=test\n"
+		for _, name := range []string{"x^file", "hCache/x", "XGL/conf"} {
+			var layer bytes.Buffer
+			tw := tar.NewWriter(&layer)
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     name,
+				Typeflag: tar.TypeReg,
+				Mode:     0o644,
+				Size:     int64(len(contents)),
+				Format:   tar.FormatUSTAR,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(tw, contents); err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for _, tc := range []struct {
+				Name      string
+				MediaType string
+				In        []byte
+			}{
+				{"Tar", `application/vnd.oci.image.layer.v1.tar`, layer.Bytes()},
+				{"Unknown", `application/octet-stream`, layer.Bytes()},
+			} {
+				t.Run(name+"/"+tc.Name, func(t *testing.T) {
+					var l claircore.Layer
+					desc := claircore.LayerDescription{
+						Digest:    "sha256:" + strings.Repeat("00c0ffee", 8),
+						MediaType: tc.MediaType,
+					}
+					if err := l.Init(ctx, &desc, bytes.NewReader(tc.In)); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					t.Cleanup(func() {
+						if err := l.Close(); err != nil {
+							t.Errorf("close error: %v", err)
+						}
+					})
+					if got, want := l.Compression(), "none"; got != want {
+						t.Errorf("got: %q, want: %q", got, want)
+					}
+					sys, err := l.FS()
+					if err != nil {
+						t.Fatal(err)
+					}
+					b, err := fs.ReadFile(sys, name)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got, want := string(b), contents; got != want {
+						t.Errorf("got: %q, want: %q", got, want)
+					}
+				})
+			}
+		}
+	})
+	t.Run("Uncompressed", func(t *testing.T) {
+		l := goodLayer(t)
+		t.Cleanup(func() {
+			if err := l.Close(); err != nil {
+				t.Errorf("close error: %v", err)
+			}
+		})
+		if got, want := l.Compression(), "none"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
 }
@@ -0,0 +1,76 @@
+package libindex
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+// DetectCache remembers the compression scheme detected for a layer, keyed by
+// the layer's digest, so that fetching the same layer again skips detection.
+//
+// Implementations must be safe for concurrent use.
+type DetectCache interface {
+	// Get reports the scheme stored for "digest", if any.
+	Get(digest string) (zreader.Compression, bool)
+	// Put stores the scheme for "digest".
+	Put(digest string, c zreader.Compression)
+}
+
+// NewDetectCache returns a [DetectCache] holding at most "size" entries,
+// evicting the least recently used. A size less than one is treated as one.
+func NewDetectCache(size int) DetectCache {
+	if size < 1 {
+		size = 1
+	}
+	return &lruCache{
+		size: size,
+		ll:   list.New(),
+		m:    make(map[string]*list.Element, size),
+	}
+}
+
+// LruCache is the [DetectCache] returned by [NewDetectCache].
+type lruCache struct {
+	mu   sync.Mutex
+	size int
+	// Ll is ordered from most to least recently used, and holds
+	// *lruEntry values.
+	ll *list.List
+	m  map[string]*list.Element
+}
+
+type lruEntry struct {
+	digest string
+	c      zreader.Compression
+}
+
+// Get implements [DetectCache].
+func (l *lruCache) Get(digest string) (zreader.Compression, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.m[digest]
+	if !ok {
+		return zreader.KindNone, false
+	}
+	l.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).c, true
+}
+
+// Put implements [DetectCache].
+func (l *lruCache) Put(digest string, c zreader.Compression) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.m[digest]; ok {
+		e.Value.(*lruEntry).c = c
+		l.ll.MoveToFront(e)
+		return
+	}
+	l.m[digest] = l.ll.PushFront(&lruEntry{digest: digest, c: c})
+	for l.ll.Len() > l.size {
+		e := l.ll.Back()
+		l.ll.Remove(e)
+		delete(l.m, e.Value.(*lruEntry).digest)
+	}
+}
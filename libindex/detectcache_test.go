@@ -0,0 +1,109 @@
+package libindex
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+func TestDetectCacheEvict(t *testing.T) {
+	c := NewDetectCache(2)
+	c.Put("a", zreader.KindGzip)
+	c.Put("b", zreader.KindZstd)
+	if _, ok := c.Get("a"); !ok {
+		t.Error("missing entry: a")
+	}
+	// "B" is now the least recently used.
+	c.Put("c", zreader.KindNone)
+	if _, ok := c.Get("b"); ok {
+		t.Error("unexpected entry: b")
+	}
+	for k, want := range map[string]zreader.Compression{"a": zreader.KindGzip, "c": zreader.KindNone} {
+		got, ok := c.Get(k)
+		if !ok || got != want {
+			t.Errorf("%s: got: %v, %v, want: %v", k, got, ok, want)
+		}
+	}
+}
+
+func TestDetectCacheConcurrent(t *testing.T) {
+	const size = 8
+	c := NewDetectCache(size)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				k := strconv.Itoa((i + j) % (2 * size))
+				c.Put(k, zreader.KindGzip)
+				c.Get(k)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.(*lruCache).ll.Len(); got > size {
+		t.Errorf("got: %d entries, want: <= %d", got, size)
+	}
+}
+
+// CountingCache records the calls made to a DetectCache.
+type countingCache struct {
+	DetectCache
+	hits, puts atomic.Int64
+}
+
+func (c *countingCache) Get(digest string) (zreader.Compression, bool) {
+	k, ok := c.DetectCache.Get(digest)
+	if ok {
+		c.hits.Add(1)
+	}
+	return k, ok
+}
+
+func (c *countingCache) Put(digest string, k zreader.Compression) {
+	c.puts.Add(1)
+	c.DetectCache.Put(digest, k)
+}
+
+func TestFetchDetectCache(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	const ct = 4
+	srv := gzipLayerServer(t, ct, 64*1024)
+	descs := gzipLayerDescs(t, srv, ct)
+	cache := &countingCache{DetectCache: NewDetectCache(ct)}
+	a := NewRemoteFetchArenaOptions(srv.Client(), t.TempDir(), FetchOptions{DetectCache: cache})
+	defer a.Close(ctx)
+
+	// Closing the FetchProxy releases the files, so the second round
+	// fetches every layer again.
+	for i := 0; i < 2; i++ {
+		f := a.Realizer(ctx).(*FetchProxy)
+		if _, err := f.RealizeDescriptions(ctx, descs); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	if got, want := cache.puts.Load(), int64(ct); got != want {
+		t.Errorf("detections: got: %d, want: %d", got, want)
+	}
+	// The second round hits once when planning and once when fetching.
+	if got, want := cache.hits.Load(), int64(2*ct); got != want {
+		t.Errorf("cache hits: got: %d, want: %d", got, want)
+	}
+	for _, d := range descs {
+		if got, ok := cache.DetectCache.Get(d.Digest); !ok || got != zreader.KindGzip {
+			t.Errorf("%s: got: %v, %v, want: %v", d.Digest, got, ok, zreader.KindGzip)
+		}
+	}
+}
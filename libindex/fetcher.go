@@ -14,12 +14,15 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/quay/zlog"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	"golang.org/x/sync/singleflight"
 	"golang.org/x/sys/unix"
 
@@ -52,15 +55,111 @@ type RemoteFetchArena struct {
 	// The string is a layer digest.
 	rc   sync.Map
 	root string
+
+	// Limit is the number of layers a single call to
+	// [FetchProxy.RealizeDescriptions] fetches at once.
+	limit int
+	// Sem, if not nil, bounds the number of layers being fetched and
+	// decompressed at once across all users of the arena.
+	sem *semaphore.Weighted
+	// Inflight, if not nil, bounds the copy buffers holding decompressed
+	// bytes before they're written to disk, across all fetches. MaxInflight
+	// is its size.
+	inflight    *semaphore.Weighted
+	maxInflight int64
+	// MaxDecompressed, if greater than zero, is the number of decompressed
+	// bytes a single [FetchProxy] may realize.
+	maxDecompressed int64
+	// DetectCache, if not nil, holds the detected compression of layers.
+	detectCache DetectCache
+}
+
+// FetchOptions are options for a [RemoteFetchArena].
+type FetchOptions struct {
+	// Concurrency is the number of layers fetched and decompressed at once,
+	// across all users of the arena. A value less than or equal to zero means
+	// GOMAXPROCS layers per call to [FetchProxy.RealizeDescriptions], with no
+	// arena-wide limit.
+	Concurrency int
+	// MaxInflight is the number of decompressed bytes that may be waiting
+	// to be written to disk at once, across all fetches. It only bounds the
+	// buffers that decoder output is copied through: the memory decoders
+	// use themselves, such as a zstd window or an xz dictionary, is not
+	// counted, so use Concurrency to bound that. A value less than or equal
+	// to zero means no limit.
+	MaxInflight int64
+	// MaxDecompressedBytes is the number of decompressed bytes that may be
+	// realized by a single [FetchProxy], that is, across all the layers of a
+	// manifest being indexed. Exceeding it returns a [*QuotaError]. Layers
+	// that are already present in the arena count against the quota. A value
+	// less than or equal to zero means no limit.
+	MaxDecompressedBytes int64
+	// DetectCache, if not nil, is consulted for a layer's compression scheme
+	// before running detection, and updated once a layer has been fetched and
+	// verified. It's also used to start the layers that are slowest to
+	// decompress first. See [NewDetectCache].
+	DetectCache DetectCache
+}
+
+// QuotaError is returned when the layers realized by a [FetchProxy] are
+// larger than the configured [FetchOptions.MaxDecompressedBytes].
+type QuotaError struct {
+	// Layer is the digest of the layer that exceeded the quota.
+	Layer string
+	// Limit is the configured quota.
+	Limit int64
+}
+
+// Error implements error.
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("fetcher: layer %s exceeds decompressed size quota of %d bytes", e.Layer, e.Limit)
+}
+
+// Quota tracks the decompressed bytes realized by a [FetchProxy].
+type quota struct {
+	max  int64
+	used atomic.Int64
+}
+
+// Charge adds "n" bytes to the quota, reporting a [*QuotaError] if that puts
+// it over the limit. A nil quota has no limit.
+func (q *quota) charge(layer string, n int64) error {
+	if q == nil {
+		return nil
+	}
+	if q.used.Add(n) > q.max {
+		return &QuotaError{Layer: layer, Limit: q.max}
+	}
+	return nil
 }
 
 // NewRemoteFetchArena returns an initialized RemoteFetchArena.
 func NewRemoteFetchArena(wc *http.Client, root string) *RemoteFetchArena {
-	return &RemoteFetchArena{
-		wc:   wc,
-		sf:   &singleflight.Group{},
-		root: root,
+	return NewRemoteFetchArenaOptions(wc, root, FetchOptions{})
+}
+
+// NewRemoteFetchArenaOptions returns an initialized RemoteFetchArena,
+// configured with the passed FetchOptions.
+func NewRemoteFetchArenaOptions(wc *http.Client, root string, opts FetchOptions) *RemoteFetchArena {
+	a := &RemoteFetchArena{
+		wc:    wc,
+		sf:    &singleflight.Group{},
+		root:  root,
+		limit: runtime.GOMAXPROCS(0),
+	}
+	if opts.Concurrency > 0 {
+		a.limit = opts.Concurrency
+		a.sem = semaphore.NewWeighted(int64(opts.Concurrency))
+	}
+	if opts.MaxInflight > 0 {
+		a.inflight = semaphore.NewWeighted(opts.MaxInflight)
+		a.maxInflight = opts.MaxInflight
 	}
+	if opts.MaxDecompressedBytes > 0 {
+		a.maxDecompressed = opts.MaxDecompressedBytes
+	}
+	a.detectCache = opts.DetectCache
+	return a
 }
 
 // Rc is a reference counter.
@@ -69,6 +168,9 @@ type rc struct {
 	val   *os.File
 	count int
 	done  func()
+	// Kind is the compression scheme the layer was fetched with. The file
+	// holds the decompressed layer.
+	kind zreader.Compression
 }
 
 // NewRc makes an rc.
@@ -145,10 +247,11 @@ var (
 	errStale  = errors.New("stale file reference")
 )
 
-// FetchInto populates "l" and "cl" via a [singleflight.Group].
+// FetchInto populates "l" and "cl" via a [singleflight.Group], charging the
+// decompressed size of the layer to "q".
 //
 // It returns a closure to be used with an [errgroup.Group]
-func (a *RemoteFetchArena) fetchInto(ctx context.Context, l *claircore.Layer, cl *io.Closer, desc *claircore.LayerDescription) (do func() error) {
+func (a *RemoteFetchArena) fetchInto(ctx context.Context, q *quota, l *claircore.Layer, cl *io.Closer, desc *claircore.LayerDescription) (do func() error) {
 	key := desc.Digest
 	// All the refcounting needs to happen _outside_ the singleflight, because
 	// the result of a singleflight call can be shared. Without doing it this
@@ -196,19 +299,124 @@ func (a *RemoteFetchArena) fetchInto(ctx context.Context, l *claircore.Layer, cl
 			r.Close()
 			return err
 		}
-		if err := l.Init(ctx, desc, f); err != nil {
+		// The file is shared, so the quota is charged here rather than while
+		// decompressing: a layer another request already fetched costs the
+		// same as one fetched for this request.
+		fi, err := f.Stat()
+		if err == nil {
+			err = q.charge(key, fi.Size())
+		}
+		if err != nil {
+			f.Close()
+			r.Close()
+			return err
+		}
+		// Prefer a memory mapping of the file, so detection and the layer's
+		// filesystem read the cached blob without copies or syscalls.
+		var ra io.ReaderAt = f
+		var unmap io.Closer = closeFunc(func() error { return nil })
+		switch m, err := mapFile(f); {
+		case errors.Is(err, nil):
+			ra, unmap = m, m
+		default:
+			zlog.Debug(ctx).Err(err).Msg("unable to map layer, using file")
+		}
+		if err := l.Init(ctx, desc, ra); err != nil {
+			unmap.Close()
+			f.Close()
+			r.Close()
+			return err
+		}
+		// The stored file is decompressed, so the Layer can't detect the
+		// scheme the blob was fetched with.
+		if err := l.SetCompression(compressionLabel(c.kind)); err != nil {
+			l.Close()
+			unmap.Close()
 			f.Close()
 			r.Close()
 			return err
 		}
 		*cl = closeFunc(func() error {
-			return errors.Join(f.Close(), r.Close())
+			return errors.Join(unmap.Close(), f.Close(), r.Close())
 		})
 		return nil
 	}
 	return do
 }
 
+// CompressionLabel returns the name of "c" for use as a metric label. Unlike
+// [zreader.Compression.String], this knows the names of registered schemes.
+func compressionLabel(c zreader.Compression) string {
+	b, err := c.MarshalText()
+	if err != nil {
+		return "unknown"
+	}
+	return string(b)
+}
+
+// MediaTypeCompression reports the compression scheme indicated by the
+// layer media type or content-type "mt".
+func mediaTypeCompression(mt string) (zreader.Compression, bool) {
+	switch {
+	case mt == "application/vnd.docker.image.rootfs.diff.tar.gzip":
+		// Catch the old docker media type.
+		fallthrough
+	case mt == "application/gzip" || mt == "application/x-gzip":
+		// GHCR reports gzipped layers as the latter.
+		fallthrough
+	case strings.HasSuffix(mt, ".tar+gzip"):
+		return zreader.KindGzip, true
+	case mt == "application/zstd":
+		fallthrough
+	case strings.HasSuffix(mt, ".tar+zstd"):
+		return zreader.KindZstd, true
+	case mt == "application/x-tar":
+		fallthrough
+	case strings.HasSuffix(mt, ".tar"):
+		return zreader.KindNone, true
+	}
+	return zreader.KindNone, false
+}
+
+// CopyBounded is like [io.Copy], but holds the weight of its buffer in "sem"
+// from before every Read of "src" until the bytes read have been written to
+// "dst". This way, decoder output is accounted for as soon as it's produced,
+// not only while it's being written. The buffer is no larger than "max", the
+// size of "sem", so that it can always be admitted. Memory held inside "src"
+// is not accounted for.
+func copyBounded(ctx context.Context, dst io.Writer, src io.Reader, sem *semaphore.Weighted, max int64) (int64, error) {
+	sz := int64(32 * 1024)
+	if sz > max {
+		sz = max
+	}
+	buf := make([]byte, sz)
+	var written int64
+	for {
+		if err := sem.Acquire(ctx, sz); err != nil {
+			return written, err
+		}
+		nr, rErr := src.Read(buf)
+		var wErr error
+		if nr > 0 {
+			var nw int
+			nw, wErr = dst.Write(buf[:nr])
+			written += int64(nw)
+			if wErr == nil && nw != nr {
+				wErr = io.ErrShortWrite
+			}
+		}
+		sem.Release(sz)
+		switch {
+		case wErr != nil:
+			return written, wErr
+		case rErr == io.EOF:
+			return written, nil
+		case rErr != nil:
+			return written, rErr
+		}
+	}
+}
+
 // CloseFunc is an adapter in the vein of [http.HandlerFunc].
 type closeFunc func() error
 
@@ -249,6 +457,12 @@ func (a *RemoteFetchArena) fetchUnlinkedFile(ctx context.Context, key string, de
 		span.SetStatus(codes.Ok, "")
 		return v.(*rc), nil
 	}
+	if a.sem != nil {
+		if err := a.sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		defer a.sem.Release(1)
+	}
 	// Otherwise, it needs to be populated.
 	f, err := os.OpenFile(a.root, os.O_WRONLY|unix.O_TMPFILE, 0644)
 	if err != nil {
@@ -295,8 +509,19 @@ func (a *RemoteFetchArena) fetchUnlinkedFile(ctx context.Context, key string, de
 	//
 	// The ultimate solution is to move to a fetcher that proxies to HTTP range
 	// requests.
-	zr, kind, err := zreader.Detect(tr)
+	// No single layer can be larger than the whole quota, so use that to stop
+	// decompressing early. The cumulative limit is enforced in fetchInto.
+	opts := zreader.ReaderOpts{MaxSize: a.maxDecompressed}
+	var cached bool
+	if a.detectCache != nil {
+		if c, ok := a.detectCache.Get(desc.Digest); ok {
+			opts.Known, cached = &c, true
+		}
+	}
+	span.SetAttributes(attribute.Bool("payload.compression.cached", cached))
+	zr, kind, err := zreader.DetectCountingOpts(tr, opts)
 	if err != nil {
+		fetchDecodeErrors.WithLabelValues("unknown").Inc()
 		return nil, fmt.Errorf("fetcher: error determining compression: %w", err)
 	}
 	defer zr.Close()
@@ -312,10 +537,12 @@ func (a *RemoteFetchArena) fetchUnlinkedFile(ctx context.Context, key string, de
 			ct = "application/gzip"
 		case zreader.KindZstd:
 			ct = "application/zstd"
-		case zreader.KindNone:
+		case zreader.KindNone, zreader.KindTar:
 			ct = "application/x-tar"
 		default:
-			return nil, fmt.Errorf("fetcher: disallowed compression kind: %q", kind.String())
+			if !kind.Registered() {
+				return nil, fmt.Errorf("fetcher: disallowed compression kind: %q", kind.String())
+			}
 		}
 		zlog.Debug(ctx).
 			Str("content-type", ct).
@@ -325,36 +552,70 @@ func (a *RemoteFetchArena) fetchUnlinkedFile(ctx context.Context, key string, de
 
 	var wantZ zreader.Compression
 	switch {
-	case ct == "application/vnd.docker.image.rootfs.diff.tar.gzip":
-		// Catch the old docker media type.
-		fallthrough
-	case ct == "application/gzip" || ct == "application/x-gzip":
-		// GHCR reports gzipped layers as the latter.
-		fallthrough
-	case strings.HasSuffix(ct, ".tar+gzip"):
-		wantZ = zreader.KindGzip
-	case ct == "application/zstd":
-		fallthrough
-	case strings.HasSuffix(ct, ".tar+zstd"):
-		wantZ = zreader.KindZstd
-	case ct == "application/x-tar":
-		fallthrough
-	case strings.HasSuffix(ct, ".tar"):
-		wantZ = zreader.KindNone
+	case kind.Registered():
+		// Schemes added with claircore.RegisterCompression have no known
+		// content-type, so whatever was reported is trusted.
+		wantZ = kind
 	default:
-		return nil, fmt.Errorf("fetcher: unknown content-type %q", ct)
+		var ok bool
+		wantZ, ok = mediaTypeCompression(ct)
+		if !ok {
+			return nil, fmt.Errorf("fetcher: unknown content-type %q", ct)
+		}
 	}
+	// Uncompressed layers may or may not be detected as tar, depending on the
+	// format of the first header.
+	if kind == zreader.KindTar {
+		kind = zreader.KindNone
+	}
+	// The detected compression is authoritative: registries and mirrors are
+	// known to mislabel layers, and the decompressed result is what's stored.
 	if kind != wantZ {
-		return nil, fmt.Errorf("fetcher: mismatched compression (%q) and content-type (%q)", kind.String(), ct)
+		switch kind {
+		case zreader.KindGzip, zreader.KindZstd, zreader.KindNone:
+		default:
+			return nil, fmt.Errorf("fetcher: disallowed compression kind: %q", kind.String())
+		}
+		zlog.Warn(ctx).
+			Str("content-type", ct).
+			Stringer("advertised", wantZ).
+			Stringer("detected", kind).
+			Msg("mismatched compression and content-type, using detected compression")
+		span.SetAttributes(attribute.Bool("payload.compression.mismatch", true))
 	}
 
-	buf := bufio.NewWriter(f)
-	n, err := io.Copy(buf, zr)
+	label := compressionLabel(kind)
+	fetchLayerCounter.WithLabelValues(label).Inc()
+	start := time.Now()
+	var n int64
+	if a.inflight != nil {
+		// Don't let a buffer hold on to bytes outside the accounting.
+		n, err = copyBounded(ctx, f, zr, a.inflight, a.maxInflight)
+	} else {
+		buf := bufio.NewWriter(f)
+		n, err = io.Copy(buf, zr)
+		if err == nil {
+			err = buf.Flush()
+		}
+	}
+	fetchDecompressDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	fetchCompressedBytes.WithLabelValues(label).Add(float64(zr.CompressedBytes()))
+	fetchDecompressedBytes.WithLabelValues(label).Add(float64(zr.DecompressedBytes()))
 	zlog.Debug(ctx).Int64("size", n).Msg("wrote file")
-	if err != nil {
-		return nil, err
+	if errors.Is(err, zreader.ErrSizeLimit) {
+		return nil, &QuotaError{Layer: desc.Digest, Limit: a.maxDecompressed}
 	}
-	if err := buf.Flush(); err != nil {
+	switch {
+	case err == nil:
+	case errors.Is(err, zreader.ErrTruncated),
+		errors.Is(err, zreader.ErrCorrupt),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		fetchDecodeErrors.WithLabelValues(label).Inc()
+		// Every decoder checks the end of the stream, so a download that was
+		// cut short is reported here instead of producing a partial layer.
+		return nil, fmt.Errorf("fetcher: layer truncated or corrupt (%v): %w", kind, err)
+	default:
+		// Errors writing the file, cancellation, and the like.
 		return nil, err
 	}
 	if got := vh.Sum(nil); !bytes.Equal(got, want) {
@@ -363,10 +624,14 @@ func (a *RemoteFetchArena) fetchUnlinkedFile(ctx context.Context, key string, de
 			hex.EncodeToString(want))
 		return nil, err
 	}
+	if a.detectCache != nil && !cached {
+		a.detectCache.Put(desc.Digest, kind)
+	}
 
 	rc := newRc(f, func() {
 		a.rc.Delete(key)
 	})
+	rc.kind = kind
 	if _, ok := a.rc.Swap(key, rc); ok {
 		rc.Ref().Close()
 		return nil, fmt.Errorf("fetcher: double-store for key %q", key)
@@ -395,12 +660,17 @@ func (a *RemoteFetchArena) Close(ctx context.Context) error {
 //
 // The concrete return type is [*FetchProxy].
 func (a *RemoteFetchArena) Realizer(_ context.Context) indexer.Realizer {
-	return &FetchProxy{a: a}
+	p := &FetchProxy{a: a}
+	if a.maxDecompressed > 0 {
+		p.q = &quota{max: a.maxDecompressed}
+	}
+	return p
 }
 
 // FetchProxy tracks the files fetched for layers.
 type FetchProxy struct {
 	a       *RemoteFetchArena
+	q       *quota
 	cleanup []io.Closer
 }
 
@@ -428,12 +698,12 @@ func (p *FetchProxy) RealizeDescriptions(ctx context.Context, descs []claircore.
 	ctx, span := tracer.Start(ctx, "RealizeDescriptions")
 	defer span.End()
 	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(runtime.GOMAXPROCS(0))
+	g.SetLimit(p.a.limit)
 	ls := make([]claircore.Layer, len(descs))
 	cleanup := make([]io.Closer, len(descs))
 
-	for i := range descs {
-		g.Go(p.a.fetchInto(ctx, &ls[i], &cleanup[i], &descs[i]))
+	for _, i := range p.a.plan(descs) {
+		g.Go(p.a.fetchInto(ctx, p.q, &ls[i], &cleanup[i], &descs[i]))
 	}
 
 	if e := g.Wait(); e != nil {
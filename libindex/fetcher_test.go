@@ -2,10 +2,14 @@ package libindex
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
@@ -13,13 +17,20 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
 	"github.com/quay/zlog"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/quay/claircore"
+	"github.com/quay/claircore/dpkg"
 	"github.com/quay/claircore/internal/wart"
+	"github.com/quay/claircore/internal/zreader"
 	"github.com/quay/claircore/test"
 )
 
@@ -248,3 +259,596 @@ func commonLayerServer(t testing.TB, ct int) ([]claircore.LayerDescription, http
 		inner.ServeHTTP(w, r)
 	})
 }
+
+func TestFetchMislabeled(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	descs, h := commonLayerServer(t, 1)
+	// Claim the plain tar is gzipped.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/vnd.oci.image.layer.v1.tar+gzip")
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	for i := range descs {
+		descs[i].URI = srv.URL + descs[i].URI
+		descs[i].MediaType = `application/vnd.oci.image.layer.v1.tar+gzip`
+	}
+	a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+	t.Cleanup(func() {
+		if err := a.Close(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+
+	f := a.Realizer(ctx).(*FetchProxy)
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ls, err := f.RealizeDescriptions(ctx, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys, err := ls[0].FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(sys, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), fmt.Sprintf("%032d\n", 0); got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestFetchTruncated(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	b := bytes.Repeat([]byte("truncated\n"), 4096)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Size: int64(len(b)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Cut the download short. The digest describes the truncated bytes, so
+	// only decompression can notice.
+	body := buf.Bytes()[:buf.Len()-8]
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "application/vnd.oci.image.layer.v1.tar+gzip")
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	descs := []claircore.LayerDescription{{
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(body)),
+		URI:       srv.URL + "/0",
+		MediaType: `application/vnd.oci.image.layer.v1.tar+gzip`,
+		Headers:   make(map[string][]string),
+	}}
+	a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+	t.Cleanup(func() {
+		if err := a.Close(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	f := a.Realizer(ctx).(*FetchProxy)
+	defer f.Close()
+	_, err := f.RealizeDescriptions(ctx, descs)
+	t.Log(err)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got: %v, want: %v", err, io.ErrUnexpectedEOF)
+	}
+	if !errors.Is(err, zreader.ErrTruncated) {
+		t.Errorf("got: %v, want: %v", err, zreader.ErrTruncated)
+	}
+}
+
+func TestFetchCanceled(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(zlog.Test(context.Background(), t))
+	defer cancel()
+	srv := gzipLayerServer(t, 1, 1024*1024)
+	descs := gzipLayerDescs(t, srv, 1)
+	// Stall the download part way through, then cancel once the body has
+	// started arriving.
+	next := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		w.Header().Set("content-type", rec.Header().Get("content-type"))
+		w.Write(rec.Body.Bytes()[:rec.Body.Len()/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	c := srv.Client()
+	c.Transport = cancelTransport{RoundTripper: c.Transport, cancel: cancel}
+	a := NewRemoteFetchArena(c, t.TempDir())
+	t.Cleanup(func() {
+		if err := a.Close(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	// Call fetchUnlinkedFile directly: fetchInto returns as soon as the
+	// Context is done, without waiting for the fetch's error.
+	_, err := a.fetchUnlinkedFile(ctx, descs[0].Digest, &descs[0])
+	t.Log(err)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got: %v, want: %v", err, context.Canceled)
+	}
+	if err != nil && strings.Contains(err.Error(), "truncated or corrupt") {
+		t.Errorf("cancellation reported as a bad layer: %v", err)
+	}
+}
+
+// CancelTransport calls "cancel" on the first read of a response body.
+type cancelTransport struct {
+	http.RoundTripper
+	cancel context.CancelFunc
+}
+
+func (t cancelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: t.cancel}
+	return res, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (b *cancelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.once.Do(b.cancel)
+	return n, err
+}
+
+// GzipLayerServer serves "ct" gzipped layers, each with a file of "sz" bytes.
+func gzipLayerServer(t testing.TB, ct, sz int) *httptest.Server {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	blobs := make(map[string][]byte, ct)
+	for i := 0; i < ct; i++ {
+		b := make([]byte, sz)
+		// Half random, so it doesn't compress away to nothing.
+		rng.Read(b[:sz/2])
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		if err := tw.WriteHeader(&tar.Header{Name: strconv.Itoa(i), Size: int64(sz), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		blobs["/"+strconv.Itoa(i)] = buf.Bytes()
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := blobs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("content-type", "application/vnd.oci.image.layer.v1.tar+gzip")
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// GzipLayerDescs returns descriptions for the layers served by
+// gzipLayerServer.
+func gzipLayerDescs(t testing.TB, srv *httptest.Server, ct int) []claircore.LayerDescription {
+	t.Helper()
+	ds := make([]claircore.LayerDescription, ct)
+	for i := range ds {
+		u := srv.URL + "/" + strconv.Itoa(i)
+		res, err := srv.Client().Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds[i] = claircore.LayerDescription{
+			Digest:    fmt.Sprintf("sha256:%x", h.Sum(nil)),
+			URI:       u,
+			MediaType: `application/vnd.oci.image.layer.v1.tar+gzip`,
+			Headers:   make(map[string][]string),
+		}
+	}
+	return ds
+}
+
+func TestFetchOptions(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	const ct = 16
+	srv := gzipLayerServer(t, ct, 256*1024)
+	descs := gzipLayerDescs(t, srv, ct)
+
+	// Sums returns the checksum of every realized layer's contents.
+	sums := func(t *testing.T, opts FetchOptions) []string {
+		t.Helper()
+		a := NewRemoteFetchArenaOptions(srv.Client(), t.TempDir(), opts)
+		defer func() {
+			if err := a.Close(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		f := a.Realizer(ctx).(*FetchProxy)
+		defer func() {
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+		ls, err := f.RealizeDescriptions(ctx, descs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]string, len(ls))
+		for i := range ls {
+			rd, err := ls[i].Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, rd.(io.Reader))
+			rd.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[i] = fmt.Sprintf("%x", h.Sum(nil))
+		}
+		return out
+	}
+
+	want := sums(t, FetchOptions{Concurrency: 1})
+	for _, opts := range []FetchOptions{
+		{},
+		{Concurrency: 8},
+		{Concurrency: 8, MaxInflight: 4096},
+		{MaxInflight: 1},
+	} {
+		opts := opts
+		t.Run(fmt.Sprintf("%d,%d", opts.Concurrency, opts.MaxInflight), func(t *testing.T) {
+			got := sums(t, opts)
+			if !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+		})
+	}
+}
+
+// HeldReader reports whether all of "sem" is available during each Read.
+type heldReader struct {
+	r    io.Reader
+	sem  *semaphore.Weighted
+	max  int64
+	free bool
+}
+
+func (h *heldReader) Read(p []byte) (int, error) {
+	if h.sem.TryAcquire(h.max) {
+		h.sem.Release(h.max)
+		h.free = true
+	}
+	return h.r.Read(p)
+}
+
+func TestCopyBounded(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const max = 1024
+	sem := semaphore.NewWeighted(max)
+	src := bytes.Repeat([]byte("claircore"), 1000)
+	h := &heldReader{r: bytes.NewReader(src), sem: sem, max: max}
+	var dst bytes.Buffer
+	n, err := copyBounded(ctx, &dst, h, sem, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, int64(len(src)); got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if !bytes.Equal(dst.Bytes(), src) {
+		t.Error("contents mismatch")
+	}
+	// The buffer's weight is held while the decoder fills it.
+	if h.free {
+		t.Error("semaphore not held during Read")
+	}
+	if !sem.TryAcquire(max) {
+		t.Error("semaphore not released")
+	}
+}
+
+func BenchmarkRealize(b *testing.B) {
+	ctx := zlog.Test(context.Background(), b)
+	const ct = 16
+	srv := gzipLayerServer(b, ct, 4*1024*1024)
+	descs := gzipLayerDescs(b, srv, ct)
+	for _, opts := range []FetchOptions{
+		{Concurrency: 1},
+		{Concurrency: 4},
+		{Concurrency: 4, MaxInflight: 1024 * 1024},
+		{},
+	} {
+		opts := opts
+		b.Run(fmt.Sprintf("%d,%d", opts.Concurrency, opts.MaxInflight), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				a := NewRemoteFetchArenaOptions(srv.Client(), b.TempDir(), opts)
+				f := a.Realizer(ctx).(*FetchProxy)
+				if _, err := f.RealizeDescriptions(ctx, descs); err != nil {
+					b.Fatal(err)
+				}
+				if err := f.Close(); err != nil {
+					b.Error(err)
+				}
+				if err := a.Close(ctx); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchZstdIndex(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	const status = `Package: bogus
+Status: install ok installed
+Architecture: all
+Version: 1
+
+`
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, d := range []string{"var/", "var/lib/", "var/lib/dpkg/", "var/lib/dpkg/info/"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d, Mode: 0o755}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "var/lib/dpkg/status", Size: int64(len(status)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, status); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	const mt = `application/vnd.oci.image.layer.v1.tar+zstd`
+	body := buf.Bytes()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", mt)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	descs := []claircore.LayerDescription{{
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(body)),
+		URI:       srv.URL + "/0",
+		MediaType: mt,
+		Headers:   make(map[string][]string),
+	}}
+
+	a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+	t.Cleanup(func() {
+		if err := a.Close(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	f := a.Realizer(ctx).(*FetchProxy)
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ls, err := f.RealizeDescriptions(ctx, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The arena stores layers decompressed, but reports the scheme they were
+	// fetched with.
+	if got, want := ls[0].Compression(), "zstd"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	pkgs, err := new(dpkg.Scanner).Scan(ctx, &ls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("got: %d packages, want: 1", len(pkgs))
+	}
+	if got, want := pkgs[0].Name, "bogus"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestFetchRegisteredCompression(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	// A toy scheme: the magic, then the tar with every byte inverted.
+	magic := []byte("NOT!\x00")
+	if err := claircore.RegisterCompression("libindex-not", magic, func(r io.Reader) (io.ReadCloser, error) {
+		if _, err := io.CopyN(io.Discard, r, int64(len(magic))); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(notReader{r}), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	const contents = "registered\n"
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Size: int64(len(contents)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(tw, contents); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Clone(magic)
+	for _, b := range layer.Bytes() {
+		body = append(body, ^b)
+	}
+	const mt = `application/vnd.example.layer.v1.tar+not`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", mt)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	descs := []claircore.LayerDescription{{
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(body)),
+		URI:       srv.URL + "/0",
+		MediaType: mt,
+		Headers:   make(map[string][]string),
+	}}
+
+	a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+	t.Cleanup(func() {
+		if err := a.Close(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	f := a.Realizer(ctx).(*FetchProxy)
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	ls, err := f.RealizeDescriptions(ctx, descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ls[0].Compression(), "libindex-not"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	sys, err := ls[0].FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(sys, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), contents; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+// NotReader inverts every byte read through it.
+type notReader struct {
+	r io.Reader
+}
+
+func (r notReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] = ^p[i]
+	}
+	return n, err
+}
+
+func TestFetchQuota(t *testing.T) {
+	t.Parallel()
+	ctx := zlog.Test(context.Background(), t)
+	const (
+		ct = 4
+		sz = 256 * 1024
+	)
+	srv := gzipLayerServer(t, ct, sz)
+	descs := gzipLayerDescs(t, srv, ct)
+
+	realize := func(t *testing.T, a *RemoteFetchArena) error {
+		t.Helper()
+		f := a.Realizer(ctx).(*FetchProxy)
+		t.Cleanup(func() {
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		_, err := f.RealizeDescriptions(ctx, descs)
+		return err
+	}
+	check := func(t *testing.T, err error) {
+		t.Helper()
+		var qe *QuotaError
+		if !errors.As(err, &qe) {
+			t.Fatalf("got: %v, want: %T", err, qe)
+		}
+		t.Log(err)
+	}
+
+	t.Run("Under", func(t *testing.T) {
+		a := NewRemoteFetchArenaOptions(srv.Client(), t.TempDir(), FetchOptions{
+			MaxDecompressedBytes: 2 * ct * sz,
+		})
+		defer a.Close(ctx)
+		if err := realize(t, a); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Cumulative", func(t *testing.T) {
+		// Every layer fits on its own, but not all together.
+		a := NewRemoteFetchArenaOptions(srv.Client(), t.TempDir(), FetchOptions{
+			MaxDecompressedBytes: 2 * sz,
+		})
+		defer a.Close(ctx)
+		check(t, realize(t, a))
+	})
+	t.Run("Layer", func(t *testing.T) {
+		a := NewRemoteFetchArenaOptions(srv.Client(), t.TempDir(), FetchOptions{
+			MaxDecompressedBytes: sz / 2,
+		})
+		defer a.Close(ctx)
+		check(t, realize(t, a))
+	})
+	t.Run("Cached", func(t *testing.T) {
+		// Layers already in the arena still count against a new request.
+		a := NewRemoteFetchArenaOptions(srv.Client(), t.TempDir(), FetchOptions{
+			MaxDecompressedBytes: 3 * sz,
+		})
+		defer a.Close(ctx)
+		f := a.Realizer(ctx).(*FetchProxy)
+		defer f.Close()
+		if _, err := f.RealizeDescriptions(ctx, descs[:2]); err != nil {
+			t.Fatal(err)
+		}
+		check(t, realize(t, a))
+	})
+}
@@ -1,6 +1,8 @@
 package libindex
 
 import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.opentelemetry.io/otel"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"go.opentelemetry.io/otel/trace"
@@ -13,3 +15,51 @@ func init() {
 		trace.WithSchemaURL(semconv.SchemaURL),
 	)
 }
+
+var (
+	fetchLayerCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore_indexer",
+			Subsystem: "fetcher",
+			Name:      "layers_total",
+			Help:      "Total number of layers fetched, by detected compression.",
+		},
+		[]string{"compression"},
+	)
+	fetchCompressedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore_indexer",
+			Subsystem: "fetcher",
+			Name:      "compressed_bytes_total",
+			Help:      "Total number of bytes read from fetched layers before decompression.",
+		},
+		[]string{"compression"},
+	)
+	fetchDecompressedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore_indexer",
+			Subsystem: "fetcher",
+			Name:      "decompressed_bytes_total",
+			Help:      "Total number of bytes written for fetched layers after decompression.",
+		},
+		[]string{"compression"},
+	)
+	fetchDecodeErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore_indexer",
+			Subsystem: "fetcher",
+			Name:      "decode_errors_total",
+			Help:      "Total number of fetched layers that failed to decompress.",
+		},
+		[]string{"compression"},
+	)
+	fetchDecompressDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore_indexer",
+			Subsystem: "fetcher",
+			Name:      "decompress_duration_seconds",
+			Help:      "The duration of downloading and decompressing fetched layers.",
+		},
+		[]string{"compression"},
+	)
+)
@@ -0,0 +1,129 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// CounterValue scrapes the default registry for the value of the counter
+// "name" with the "compression" label "c".
+func counterValue(t *testing.T, name, c string) float64 {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "compression" && l.GetValue() == c {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestFetchMetrics(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	b := bytes.Repeat([]byte("metrics\n"), 1024)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Size: int64(len(b)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(layer.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := enc.EncodeAll(layer.Bytes(), nil)
+	enc.Close()
+	blobs := map[string][]byte{
+		"/gzip": gz.Bytes(),
+		"/zstd": zst,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/vnd.oci.image.layer.v1.tar+"+r.URL.Path[1:])
+		w.Write(blobs[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	names := []string{
+		"claircore_indexer_fetcher_layers_total",
+		"claircore_indexer_fetcher_compressed_bytes_total",
+		"claircore_indexer_fetcher_decompressed_bytes_total",
+	}
+	for _, c := range []string{"gzip", "zstd"} {
+		c := c
+		t.Run(c, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			before := make([]float64, len(names))
+			for i, n := range names {
+				before[i] = counterValue(t, n, c)
+			}
+			errsBefore := counterValue(t, "claircore_indexer_fetcher_decode_errors_total", c)
+			body := blobs["/"+c]
+			descs := []claircore.LayerDescription{{
+				Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(body)),
+				URI:       srv.URL + "/" + c,
+				MediaType: "application/vnd.oci.image.layer.v1.tar+" + c,
+				Headers:   make(map[string][]string),
+			}}
+			a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+			defer func() {
+				if err := a.Close(ctx); err != nil {
+					t.Error(err)
+				}
+			}()
+			f := a.Realizer(ctx).(*FetchProxy)
+			if _, err := f.RealizeDescriptions(ctx, descs); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+
+			want := []float64{1, float64(len(body)), float64(layer.Len())}
+			for i, n := range names {
+				got := counterValue(t, n, c) - before[i]
+				if got < want[i] {
+					t.Errorf("%s: got: %v, want: >= %v", n, got, want[i])
+				}
+			}
+			if got, want := counterValue(t, "claircore_indexer_fetcher_decode_errors_total", c)-errsBefore, 0.0; got != want {
+				t.Errorf("decode errors: got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package libindex
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// MappedFile is a read-only memory mapping of a file.
+//
+// Reads through a mappedFile don't make syscalls or copy through a kernel
+// buffer, which helps the many small reads done when building and walking a
+// layer's filesystem.
+type mappedFile struct {
+	*bytes.Reader
+	b []byte
+}
+
+// MapFile maps the contents of "f" into memory. The mapping is independent of
+// "f" and must be released with Close.
+//
+// Empty files can't be mapped and report an error.
+func mapFile(f *os.File) (*mappedFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sz := fi.Size()
+	if sz <= 0 || int64(int(sz)) != sz {
+		return nil, fmt.Errorf("libindex: unable to map file of size %d", sz)
+	}
+	b, err := unix.Mmap(int(f.Fd()), 0, int(sz), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("libindex: unable to map file: %w", err)
+	}
+	return &mappedFile{Reader: bytes.NewReader(b), b: b}, nil
+}
+
+// Close implements [io.Closer].
+func (m *mappedFile) Close() error {
+	if m.b == nil {
+		return nil
+	}
+	b := m.b
+	m.b = nil
+	m.Reader = bytes.NewReader(nil)
+	return unix.Munmap(b)
+}
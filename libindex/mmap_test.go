@@ -0,0 +1,119 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestMappedLayer(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	// Write a gzipped layer to disk, as if it were cached.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for i := 0; i < 10; i++ {
+		b := bytes.Repeat([]byte{byte('a' + i)}, 1024*i)
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     fmt.Sprintf("etc/file%d", i),
+			Size:     int64(len(b)),
+			Mode:     0o644,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "layer")
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	desc := claircore.LayerDescription{
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(buf.Bytes())),
+		URI:       "file:///dev/null",
+		MediaType: `application/vnd.oci.image.layer.v1.tar+gzip`,
+	}
+
+	// Contents describes every file in the Layer, and the size of its Reader.
+	contents := func(t *testing.T, ra io.ReaderAt) []string {
+		t.Helper()
+		var l claircore.Layer
+		if err := l.Init(ctx, &desc, ra); err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if got, want := l.Compression(), "gzip"; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		sys, err := l.FS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		if err := fs.WalkDir(sys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			b, err := fs.ReadFile(sys, p)
+			if err != nil {
+				return err
+			}
+			out = append(out, fmt.Sprintf("%s %x", p, sha256.Sum256(b)))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		rd, err := l.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		sz, err := rd.(io.Seeker).Seek(0, io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(out, fmt.Sprintf("size %d", sz))
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := mapFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	want := contents(t, f)
+	got := contents(t, m)
+	if !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
@@ -0,0 +1,75 @@
+package libindex
+
+import (
+	"sort"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/internal/zreader"
+)
+
+// Decode costs, used to order the layers of a manifest for fetching.
+//
+// These are relative and only need to rank the schemes: a gzip layer takes
+// a few times as long to decompress as a zstd layer of the same size. Layers
+// with an unknown scheme are assumed to be gzip, as most layers are.
+const (
+	costPresent = iota
+	costNone
+	costFast
+	costGzip
+	costSlow
+)
+
+// DecodeCost returns the relative cost of decompressing a layer compressed
+// with "c".
+func decodeCost(c zreader.Compression) int {
+	switch c {
+	case zreader.KindNone, zreader.KindTar:
+		return costNone
+	case zreader.KindZstd, zreader.KindLz4, zreader.KindSnappy:
+		return costFast
+	case zreader.KindBzip2, zreader.KindXz, zreader.KindLzma:
+		return costSlow
+	}
+	return costGzip
+}
+
+// Plan returns the indexes of "descs" in the order they should be fetched.
+//
+// Fetches are bounded, so the layers that are slowest to decompress are
+// started first and the fast ones fill in behind them; starting a gzip layer
+// last would leave it running alone once everything else has finished.
+// Layers already in the arena are cheapest and go last. The compression of a
+// layer is taken from the [DetectCache], falling back to its media type.
+// Layers of equal cost keep their manifest order.
+func (a *RemoteFetchArena) plan(descs []claircore.LayerDescription) []int {
+	cost := make([]int, len(descs))
+	for i := range descs {
+		d := &descs[i]
+		if _, ok := a.rc.Load(d.Digest); ok {
+			cost[i] = costPresent
+			continue
+		}
+		var c zreader.Compression
+		ok := false
+		if a.detectCache != nil {
+			c, ok = a.detectCache.Get(d.Digest)
+		}
+		if !ok {
+			c, ok = mediaTypeCompression(d.MediaType)
+		}
+		if !ok {
+			cost[i] = costGzip
+			continue
+		}
+		cost[i] = decodeCost(c)
+	}
+	order := make([]int, len(descs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return cost[order[i]] > cost[order[j]]
+	})
+	return order
+}
@@ -0,0 +1,56 @@
+package libindex
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/internal/zreader"
+)
+
+func TestPlan(t *testing.T) {
+	const (
+		gz = `application/vnd.oci.image.layer.v1.tar+gzip`
+		zs = `application/vnd.oci.image.layer.v1.tar+zstd`
+		nc = `application/vnd.oci.image.layer.v1.tar`
+	)
+	descs := []claircore.LayerDescription{
+		{Digest: "zstd-0", MediaType: zs},
+		{Digest: "gzip-0", MediaType: gz},
+		{Digest: "none-0", MediaType: nc},
+		{Digest: "zstd-1", MediaType: zs},
+		{Digest: "gzip-1", MediaType: gz},
+		{Digest: "unknown", MediaType: `application/octet-stream`},
+	}
+
+	t.Run("MediaType", func(t *testing.T) {
+		a := NewRemoteFetchArena(http.DefaultClient, t.TempDir())
+		got := a.plan(descs)
+		want := []int{1, 4, 5, 0, 3, 2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("DetectCache", func(t *testing.T) {
+		c := NewDetectCache(8)
+		// Mislabeled: the cache knows better.
+		c.Put("gzip-1", zreader.KindZstd)
+		c.Put("zstd-0", zreader.KindXz)
+		a := NewRemoteFetchArenaOptions(http.DefaultClient, t.TempDir(), FetchOptions{DetectCache: c})
+		got := a.plan(descs)
+		want := []int{0, 1, 5, 3, 4, 2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+	t.Run("Present", func(t *testing.T) {
+		a := NewRemoteFetchArena(http.DefaultClient, t.TempDir())
+		a.rc.Store("gzip-0", &rc{})
+		got := a.plan(descs)
+		want := []int{4, 5, 0, 3, 2, 1}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
@@ -6,13 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
 	"net/url"
-	"path"
 
 	"github.com/quay/zlog"
 
+	"github.com/quay/claircore/internal/zreader"
 	"github.com/quay/claircore/libvuln/driver"
 	"github.com/quay/claircore/pkg/tmp"
 )
@@ -110,6 +109,10 @@ type FetcherConfig struct {
 // Fetch makes GET requests, and will make conditional requests using the
 // passed-in hint.
 //
+// If Fetcher.Compression is CompressionAuto, the response body is decompressed
+// according to its contents, so any scheme zreader supports works regardless
+// of the reported content type.
+//
 // Tmp.File is used to return a ReadCloser that outlives the passed-in context.
 func (f *Fetcher) Fetch(ctx context.Context, hint driver.Fingerprint) (io.ReadCloser, driver.Fingerprint, error) {
 	ctx = zlog.ContextWithValues(ctx, "component", "pkg/ovalutil/Fetcher.Fetch")
@@ -154,58 +157,16 @@ func (f *Fetcher) Fetch(ctx context.Context, hint driver.Fingerprint) (io.ReadCl
 
 	var r io.Reader
 	cmp := f.Compression
-Compression:
 	switch cmp {
 	case CompressionAuto:
-		var kind string
-		for _, h := range []string{`content-type`, `content-disposition`} {
-			v := res.Header.Get(h)
-			if v == "" {
-				continue
-			}
-			switch h {
-			case `content-type`:
-				kind, _, err = mime.ParseMediaType(v)
-				if err == nil {
-					goto Found
-				}
-			case `content-disposition`:
-				var params map[string]string
-				_, params, err = mime.ParseMediaType(v)
-				if err != nil {
-					break
-				}
-				fn, ok := params["filename"]
-				if !ok {
-					break
-				}
-				kind = mime.TypeByExtension(path.Ext(fn))
-				if kind != "" {
-					goto Found
-				}
-			default:
-				panic("unreachable")
-			}
-			zlog.Debug(ctx).
-				Err(err).
-				Str("header", h).
-				Str("value", v).
-				Msg("failed to parse incoming HTTP header")
-		}
-		kind = mime.TypeByExtension(path.Ext(res.Request.URL.Path))
-	Found:
-		switch kind {
-		case `application/x-bzip2`:
-			cmp = CompressionBzip2
-		case `application/gzip`, `application/x-gzip`:
-			cmp = CompressionGzip
-		case `application/zstd`:
-			cmp = CompressionZstd
-		default:
-			// unknown type
-			cmp = CompressionNone
+		// Let zreader remove any content-coding and sniff the scheme from the
+		// body itself, rather than trusting the headers.
+		zr, err := zreader.HTTPBody(res)
+		if err != nil {
+			return nil, hint, err
 		}
-		goto Compression
+		defer zr.Close()
+		r = zr
 	case CompressionNone:
 		r = res.Body
 	case CompressionGzip:
@@ -0,0 +1,90 @@
+package ovalutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+)
+
+func TestFetchAuto(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	want := bytes.Repeat([]byte("<oval_definitions/>\n"), 1024)
+	tt := []struct {
+		Name     string
+		Compress func(testing.TB) []byte
+	}{
+		{
+			Name: "Gzip",
+			Compress: func(t testing.TB) []byte {
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				if _, err := w.Write(want); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return buf.Bytes()
+			},
+		},
+		{
+			Name: "Zstd",
+			Compress: func(t testing.TB) []byte {
+				enc, err := zstd.NewWriter(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				return enc.EncodeAll(want, nil)
+			},
+		},
+		{
+			Name: "None",
+			Compress: func(testing.TB) []byte {
+				return want
+			},
+		},
+	}
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			body := tc.Compress(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				// Deliberately unhelpful.
+				w.Header().Set("content-type", "application/octet-stream")
+				w.Write(body)
+			}))
+			defer srv.Close()
+			u, err := url.Parse(srv.URL + "/db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &Fetcher{
+				URL:         u,
+				Client:      srv.Client(),
+				Compression: CompressionAuto,
+			}
+			rc, _, err := f.Fetch(ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got: %d bytes, want: %d bytes", len(got), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+//go:build go1.23
+
+package tarfs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"iter"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+// Entries returns an iterator over the entries of the tar archive in "r",
+// which may be compressed with any scheme [zreader.Detect] recognizes.
+//
+// Entries are read lazily, so only one entry is in memory at a time. The
+// yielded Reader returns the contents of the entry, and is only valid until
+// the iterator advances. If an error is encountered, a nil Header is yielded
+// along with a Reader that returns the error, and iteration stops.
+func Entries(r io.Reader) iter.Seq2[*tar.Header, io.Reader] {
+	return func(yield func(*tar.Header, io.Reader) bool) {
+		zr, _, err := zreader.Detect(r)
+		if err != nil {
+			// An *UnsupportedError comes with a Reader that's not used here.
+			if zr != nil {
+				zr.Close()
+			}
+			yield(nil, errReader{err})
+			return
+		}
+		defer zr.Close()
+		tr := tar.NewReader(zr)
+		for {
+			h, err := tr.Next()
+			switch {
+			case errors.Is(err, nil):
+			case errors.Is(err, io.EOF):
+				return
+			default:
+				yield(nil, errReader{err})
+				return
+			}
+			if !yield(h, tr) {
+				return
+			}
+		}
+	}
+}
+
+// ErrReader is an [io.Reader] that always returns an error.
+type errReader struct {
+	err error
+}
+
+// Read implements [io.Reader].
+func (r errReader) Read(_ []byte) (int, error) {
+	return 0, r.err
+}
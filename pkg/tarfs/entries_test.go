@@ -0,0 +1,126 @@
+//go:build go1.23
+
+package tarfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/quay/claircore/internal/zreader"
+)
+
+func TestEntries(t *testing.T) {
+	in, err := os.ReadFile(`testdata/concat.tar`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := enc.EncodeAll(in, nil)
+	enc.Close()
+
+	// List returns a description of every entry yielded for "b".
+	list := func(t *testing.T, b []byte) []string {
+		t.Helper()
+		var out []string
+		for h, r := range Entries(bytes.NewReader(b)) {
+			if h == nil {
+				_, err := r.Read(nil)
+				t.Fatal(err)
+			}
+			s := sha256.New()
+			if _, err := io.Copy(s, r); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, fmt.Sprintf("%s %v %d %x", h.Name, h.Typeflag, h.Size, s.Sum(nil)))
+		}
+		return out
+	}
+	want := list(t, in)
+	if len(want) == 0 {
+		t.Fatal("no entries")
+	}
+	for _, tc := range []struct {
+		Name string
+		In   []byte
+	}{
+		{Name: "Gzip", In: gz.Bytes()},
+		{Name: "Zstd", In: zst},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := list(t, tc.In)
+			if !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+		})
+	}
+
+	t.Run("Stop", func(t *testing.T) {
+		n := 0
+		for range Entries(bytes.NewReader(gz.Bytes())) {
+			n++
+			break
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		var sawErr bool
+		for h, r := range Entries(bytes.NewReader(gz.Bytes()[:gz.Len()/2])) {
+			if h != nil {
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					sawErr = true
+					break
+				}
+				continue
+			}
+			if _, err := r.Read(nil); err == nil {
+				t.Error("expected error, got nil")
+			}
+			sawErr = true
+		}
+		if !sawErr {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		var n int
+		for h, r := range Entries(bytes.NewReader([]byte("PK\x03\x04 not a tar"))) {
+			n++
+			if h != nil {
+				t.Errorf("unexpected header: %v", h.Name)
+				continue
+			}
+			_, err := r.Read(nil)
+			var uerr *zreader.UnsupportedError
+			if !errors.As(err, &uerr) {
+				t.Errorf("got: %v, want: %T", err, uerr)
+			}
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	})
+}
@@ -3,6 +3,8 @@ package tarfs
 
 import (
 	"archive/tar"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -12,6 +14,8 @@ import (
 	"strconv"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/quay/claircore/internal/zreader"
 )
 
 // FS implements a filesystem abstraction over an io.ReaderAt containing a tar.
@@ -153,6 +157,39 @@ func New(r io.ReaderAt) (*FS, error) {
 	return &s, nil
 }
 
+// ErrTooLarge is reported by [NewFromReader] when the decompressed archive is
+// larger than the passed limit.
+var ErrTooLarge = errors.New("tarfs: archive too large")
+
+// NewFromReader creates an FS from the tar read from "r", which may be
+// compressed with any scheme [zreader.Reader] detects.
+//
+// Random access needs the decompressed archive, so it's read into memory in
+// its entirety; nothing is written to disk. The memory used is bounded by
+// "max", the largest decompressed size accepted: a larger archive stops
+// decompressing at the limit and reports [ErrTooLarge]. A "max" less than
+// one is an error. Callers that have the uncompressed archive in a file, or
+// don't have a reasonable bound, should use [New] instead.
+func NewFromReader(r io.Reader, max int64) (*FS, error) {
+	if max < 1 {
+		return nil, fmt.Errorf("tarfs: invalid size limit: %d", max)
+	}
+	rc, _, err := zreader.DetectOpts(r, zreader.ReaderOpts{MaxSize: max})
+	if err != nil {
+		return nil, fmt.Errorf("tarfs: error decompressing archive: %w", err)
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	switch _, err := buf.ReadFrom(rc); {
+	case errors.Is(err, nil):
+	case errors.Is(err, zreader.ErrSizeLimit):
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrTooLarge, max)
+	default:
+		return nil, fmt.Errorf("tarfs: error reading archive: %w", err)
+	}
+	return New(bytes.NewReader(buf.Bytes()))
+}
+
 // Add does what it says on the tin.
 //
 // In addition, it creates any needed leading directory elements. The caller
@@ -3,8 +3,10 @@ package tarfs
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -618,3 +620,78 @@ func TestInvalidName(t *testing.T) {
 		}
 	}
 }
+
+func TestNewFromReader(t *testing.T) {
+	in, err := os.ReadFile(`testdata/concat.tar`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tree returns a description of every entry in the FS built from "r".
+	tree := func(t *testing.T, r io.Reader) []string {
+		t.Helper()
+		sys, err := NewFromReader(r, 1<<30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		if err := fs.WalkDir(sys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			ent := fmt.Sprintf("%s %v %d", p, fi.Mode(), fi.Size())
+			if fi.Mode().IsRegular() {
+				b, err := fs.ReadFile(sys, p)
+				if err != nil {
+					return err
+				}
+				ent += fmt.Sprintf(" %x", sha256.Sum256(b))
+			}
+			out = append(out, ent)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	want := tree(t, bytes.NewReader(in))
+	if len(want) < 2 {
+		t.Fatalf("unexpectedly small tree: %q", want)
+	}
+	got := tree(t, bytes.NewReader(gz.Bytes()))
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d:\n%q\n%q", len(got), len(want), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got: %q, want: %q", got[i], want[i])
+		}
+	}
+
+	t.Run("Limit", func(t *testing.T) {
+		// Exactly the size of the archive is fine, one byte less is not.
+		if _, err := NewFromReader(bytes.NewReader(gz.Bytes()), int64(len(in))); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		_, err := NewFromReader(bytes.NewReader(gz.Bytes()), int64(len(in)-1))
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("got: %v, want: %v", err, ErrTooLarge)
+		}
+		if _, err := NewFromReader(bytes.NewReader(gz.Bytes()), 0); err == nil {
+			t.Error("expected error for zero limit")
+		}
+	})
+}